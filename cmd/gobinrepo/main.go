@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sort"
 	"strings"
 	"syscall"
@@ -18,6 +19,9 @@ import (
 	"github.com/martencassel/gobinrepo/internal/remote"
 	"github.com/martencassel/gobinrepo/internal/util/blobs"
 	"github.com/martencassel/gobinrepo/internal/util/config"
+	"github.com/martencassel/gobinrepo/internal/util/filestore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/uploads"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -65,7 +69,7 @@ func main() {
 	}
 	log.Infof("Using public URL: %s", cfg.Server.PublicURL)
 
-	router, err := buildRouterWithConfig(cfg, devMode)
+	router, closeHandlers, err := buildRouterWithConfig(cfg, devMode)
 	if err != nil {
 		panic(err)
 	}
@@ -78,8 +82,10 @@ func main() {
 		log.WithFields(log.Fields{
 			"remote":       name,
 			"package_type": r.PackageType,
+			"kind":         r.Kind,
 			"remote_url":   r.RemoteURL,
 			"has_creds":    hasCreds,
+			"offline":      r.Offline || cfg.Server.Offline,
 		}).Info("Configured remote")
 	}
 
@@ -130,6 +136,7 @@ func main() {
 	} else {
 		log.Infof("Server exiting")
 	}
+	closeHandlers()
 }
 
 func initRouter(devMode bool) *gin.Engine {
@@ -145,7 +152,9 @@ func initRouter(devMode bool) *gin.Engine {
 	return r
 }
 
-func buildRouterWithConfig(cfg *config.Config, devMode bool) (*gin.Engine, error) {
+// buildRouterWithConfig wires all handlers into a router. The returned func
+// releases background resources held by the handlers.
+func buildRouterWithConfig(cfg *config.Config, devMode bool) (*gin.Engine, func(), error) {
 	r := initRouter(devMode)
 	r.Use(mw.RequestTracer())
 	r.Use(mw.LoggingMiddleware())
@@ -153,35 +162,78 @@ func buildRouterWithConfig(cfg *config.Config, devMode bool) (*gin.Engine, error
 	// Build config store from the loaded config
 	store := configstore.NewRepoConfigStore()
 	for name, r := range cfg.Remotes {
-		if r.Username == nil || r.Password == nil {
-			store.Add(configstore.RepoConfig{
-				RepoKey:     name,
-				RemoteURL:   r.RemoteURL,
-				PackageType: r.PackageType,
-			})
-			continue
+		repoCfg := configstore.RepoConfig{
+			RepoKey:           name,
+			RemoteURL:         r.RemoteURL,
+			PackageType:       r.PackageType,
+			Kind:              r.Kind,
+			TagTTL:            r.TagTTL,
+			TagList:           r.TagList,
+			Members:           r.Members,
+			Namespaces:        r.Namespaces,
+			Hosts:             r.Hosts,
+			PrefetchPlatforms: r.PrefetchPlatforms,
+			Sync:              r.Sync,
+			Offline:           r.Offline || cfg.Server.Offline,
+			CosignKeys:        r.CosignKeys,
+			PinTags:           r.PinTags,
+			Credentials:       r.Credentials,
+			RateLimitReserve:  r.RateLimitReserve,
+			DockerConfig:      r.DockerConfig,
+			CredentialHelper:  r.CredentialHelper,
+			CredentialTTL:     r.CredentialTTL,
+			CacheRedirects:    r.CacheRedirects,
+			AllowSchema1:      r.AllowSchema1,
+			SBOM:              r.SBOM,
 		}
-		store.Add(configstore.RepoConfig{
-			RepoKey:   name,
-			RemoteURL: r.RemoteURL,
-			Username:  *r.Username,
-			Password:  *r.Password,
-		})
+		if r.Username != nil && r.Password != nil {
+			repoCfg.Username = *r.Username
+			repoCfg.Password = *r.Password
+		}
+		store.Add(repoCfg)
 	}
 	blobs, err := blobs.NewBlobStoreFS(cfg.Cache.Path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	manifests, err := manifests.NewStore(blobs, filepath.Join(cfg.Cache.Path, "manifests"))
+	if err != nil {
+		return nil, nil, err
+	}
+	uploads, err := uploads.NewStore(filepath.Join(cfg.Cache.Path, "uploads"))
+	if err != nil {
+		return nil, nil, err
 	}
 	mw := mw.NewRepoKeyMiddleware()
 	r.Use(mw.Middleware())
 
-	docker := remote.NewDockerRemoteHandler(blobs, store, true)
+	if err := checkRoutes(cfg); err != nil {
+		return nil, nil, err
+	}
+	docker := remote.NewDockerRemoteHandler(blobs, manifests, uploads, store, true)
+	if m := cfg.Server.DockerMirror; m != "" {
+		if r, ok := store.Get(m); !ok || r.PackageType != configstore.PackageTypeDocker {
+			return nil, nil, fmt.Errorf("docker_mirror %q is not a docker remote", m)
+		}
+		docker.SetMirror(m)
+	}
+	if err := docker.CheckCosignKeys(); err != nil {
+		return nil, nil, err
+	}
+	if err := docker.CheckDockerConfigs(); err != nil {
+		return nil, nil, err
+	}
+	if err := docker.StartSync(); err != nil {
+		return nil, nil, err
+	}
 	docker.RegisterRoutes(r)
 
-	debian := remote.NewDebianRemoteHandler(blobs, store, true)
+	// Helm and Debian files are addressed by path rather than digest.
+	files := filestore.NewFileStore(filepath.Join(cfg.Cache.Path, "files"))
+	debian := remote.NewDebianRemoteHandler(blobs, files, store, true)
 	debian.RegisterRoutes(r)
 
-	helm := remote.NewHelmRepoHandler(blobs, store)
+	helm := remote.NewHelmRepoHandler(blobs, files, store)
 	helm.Register(r)
 
 	r.NoRoute(func(c *gin.Context) {
@@ -197,5 +249,28 @@ func buildRouterWithConfig(cfg *config.Config, devMode bool) (*gin.Engine, error
 			"message": "No route matched your request",
 		})
 	})
-	return r, nil
+	return r, docker.Close, nil
+}
+
+// checkRoutes rejects a namespace or host routed to more than one remote.
+func checkRoutes(cfg *config.Config) error {
+	seen := map[string]string{}
+	for name, r := range cfg.Remotes {
+		for _, key := range append(prefixed("ns:", r.Namespaces), prefixed("host:", r.Hosts)...) {
+			key = strings.ToLower(key)
+			if other, ok := seen[key]; ok && other != name {
+				return fmt.Errorf("%s is routed to both %s and %s", key, other, name)
+			}
+			seen[key] = name
+		}
+	}
+	return nil
+}
+
+func prefixed(prefix string, values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, prefix+v)
+	}
+	return out
 }
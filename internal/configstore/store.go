@@ -3,6 +3,7 @@ package configstore
 import (
 	"fmt"
 	"sync"
+	"time"
 )
 
 // PackageType
@@ -47,21 +48,187 @@ func (p *PackageType) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// RepoKind selects how a repository is served.
+type RepoKind int
+
+const (
+	// RepoKindRemote proxies and caches an upstream registry.
+	RepoKindRemote RepoKind = iota
+	// RepoKindHosted stores content pushed by clients; there is no upstream.
+	RepoKindHosted
+	// RepoKindVirtual resolves pulls through an ordered list of member
+	// repositories and stores nothing itself.
+	RepoKindVirtual
+)
+
+func (k RepoKind) String() string {
+	switch k {
+	case RepoKindHosted:
+		return "hosted"
+	case RepoKindVirtual:
+		return "virtual"
+	default:
+		return "remote"
+	}
+}
+
+func (k *RepoKind) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch s {
+	case "", "remote":
+		*k = RepoKindRemote
+	case "hosted":
+		*k = RepoKindHosted
+	case "virtual":
+		*k = RepoKindVirtual
+	default:
+		return fmt.Errorf("unknown repository kind %q", s)
+	}
+	return nil
+}
+
+// TagListMode selects where tags/list answers come from for a remote.
+type TagListMode int
+
+const (
+	// TagListUpstream proxies tags/list to the upstream registry.
+	TagListUpstream TagListMode = iota
+	// TagListCached lists only the tags resolved through the cache.
+	TagListCached
+	// TagListMerge combines upstream and cached tags, falling back to the
+	// cached ones when upstream is unreachable.
+	TagListMerge
+)
+
+func (m TagListMode) String() string {
+	switch m {
+	case TagListCached:
+		return "cached"
+	case TagListMerge:
+		return "merge"
+	default:
+		return "upstream"
+	}
+}
+
+// ParseTagListMode parses the config and query form of a TagListMode.
+func ParseTagListMode(s string) (TagListMode, error) {
+	switch s {
+	case "", "upstream":
+		return TagListUpstream, nil
+	case "cached":
+		return TagListCached, nil
+	case "merge":
+		return TagListMerge, nil
+	default:
+		return TagListUpstream, fmt.Errorf("unknown tag list mode %q", s)
+	}
+}
+
+func (m *TagListMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	mode, err := ParseTagListMode(s)
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
 // RepoConfig represents a mapping from repoKey → remote registry URL.
 type RepoConfig struct {
 	RepoKey     string      `json:"repoKey"`
 	PackageType PackageType `json:"packageType"`
+	Kind        RepoKind    `json:"kind"`
 	RemoteURL   string      `json:"remoteURL"`
 	Username    string      `json:"username"`
 	Password    string      `json:"password"`
+	// TagTTL is how long a tag resolution is trusted before revalidation.
+	TagTTL time.Duration `json:"tagTTL"`
+	// TagList selects the default source of tags/list for remotes.
+	TagList TagListMode `json:"tagList"`
+	// Members are the repoKeys a virtual repository resolves through, in order.
+	Members []string `json:"members"`
+	// Namespaces are the registries (containerd's ns query parameter, e.g.
+	// "ghcr.io") whose unprefixed requests this repository serves.
+	Namespaces []string `json:"namespaces"`
+	// Hosts are the request Host names whose unprefixed requests this
+	// repository serves.
+	Hosts []string `json:"hosts"`
+	// PrefetchPlatforms lists os/arch[/variant] platforms whose images are
+	// fetched in the background when an index passes through a remote.
+	PrefetchPlatforms []string `json:"prefetchPlatforms"`
+	// Sync lists the repositories whose matching tags are pulled into the
+	// cache on a schedule.
+	Sync []SyncRule `json:"sync"`
+	// Offline keeps the repository from contacting its upstream: tags and
+	// metadata resolve from their last cached state and anything not cached
+	// is reported missing.
+	Offline bool `json:"offline"`
+	// CosignKeys are paths of PEM public keys; when set, only manifests with
+	// a cosign signature by one of them are served.
+	CosignKeys []string `json:"cosignKeys"`
+	// PinTags holds every tag at its pinned digest when upstream moves it;
+	// the new digest is served only once an operator approves it.
+	PinTags bool `json:"pinTags"`
+	// Credentials are additional accounts, used in turn after Username and
+	// Password when upstream rate limits one of them.
+	Credentials []Credential `json:"credentials"`
+	// RateLimitReserve is the number of remaining upstream pulls at which
+	// cached tags stop being re-fetched.
+	RateLimitReserve int `json:"rateLimitReserve"`
+	// DockerConfig is the path of a docker config.json to take the upstream
+	// credential from.
+	DockerConfig string `json:"dockerConfig"`
+	// CredentialHelper is the docker credential helper to ask for the
+	// upstream credential, e.g. "ecr-login" for docker-credential-ecr-login.
+	CredentialHelper string `json:"credentialHelper"`
+	// CredentialTTL is how long a credential from DockerConfig or
+	// CredentialHelper is cached.
+	CredentialTTL time.Duration `json:"credentialTTL"`
+	// CacheRedirects remembers where upstream redirected blob requests for
+	// the lifetime of the target URL.
+	CacheRedirects bool `json:"cacheRedirects"`
+	// AllowSchema1 lets deprecated Docker schema 1 manifests through.
+	AllowSchema1 bool `json:"allowSchema1"`
+	// SBOM caches every image served and records its package inventory.
+	SBOM bool `json:"sbom"`
+}
+
+// Credential is an upstream account.
+type Credential struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// SyncRule keeps tags of upstream repositories mirrored. Tags is a regular
+// expression and Semver a constraint such as ">=2.8"; either may be empty.
+// Max keeps only the newest matches by version.
+type SyncRule struct {
+	Repositories []string      `json:"repositories" yaml:"repositories"`
+	Tags         string        `json:"tags" yaml:"tags,omitempty"`
+	Semver       string        `json:"semver" yaml:"semver,omitempty"`
+	Max          int           `json:"max" yaml:"max,omitempty"`
+	Interval     time.Duration `json:"interval" yaml:"interval,omitempty"`
 }
 
 func (c RepoConfig) String() string {
-	return fmt.Sprintf("PackageType: %s URL=%s Username=%s Password=%s",
+	return fmt.Sprintf("PackageType: %s Kind=%s URL=%s Username=%s Password=%s TagTTL=%s TagList=%s Members=%v Offline=%t",
 		c.PackageType,
+		c.Kind,
 		c.RemoteURL,
 		c.Username,
 		mask(c.Password),
+		c.TagTTL,
+		c.TagList,
+		c.Members,
+		c.Offline,
 	)
 }
 
@@ -72,6 +239,16 @@ func mask(s string) string {
 	return "****"
 }
 
+// IsHosted reports whether the repository stores pushed content itself.
+func (c RepoConfig) IsHosted() bool {
+	return c.Kind == RepoKindHosted
+}
+
+// IsVirtual reports whether the repository aggregates other repositories.
+func (c RepoConfig) IsVirtual() bool {
+	return c.Kind == RepoKindVirtual
+}
+
 // RepoConfigStore is an in-memory store for repo configurations.
 type RepoConfigStore struct {
 	mu      sync.RWMutex
@@ -1,7 +1,6 @@
 package remote
 
 import (
-	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -16,20 +15,22 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/martencassel/gobinrepo/internal/configstore"
 	"github.com/martencassel/gobinrepo/internal/util/blobs"
-	digest "github.com/opencontainers/go-digest"
+	"github.com/martencassel/gobinrepo/internal/util/filestore"
 	log "github.com/sirupsen/logrus"
 )
 
 type DebianRemoteHandler struct {
 	blobs blobs.BlobStore
+	files *fileCache
 	store *configstore.RepoConfigStore
 	*configstore.RepoConfigStore
 	traceEnable bool
 }
 
-func NewDebianRemoteHandler(blobs blobs.BlobStore, store *configstore.RepoConfigStore, traceEnable bool) *DebianRemoteHandler {
+func NewDebianRemoteHandler(blobs blobs.BlobStore, files filestore.FileStore, store *configstore.RepoConfigStore, traceEnable bool) *DebianRemoteHandler {
 	return &DebianRemoteHandler{
 		blobs:       blobs,
+		files:       &fileCache{blobs: blobs, files: files},
 		store:       store,
 		traceEnable: traceEnable,
 	}
@@ -79,64 +80,58 @@ func (r *DebianRemoteHandler) handleDebianRequest(c *gin.Context) {
 
 func (r *DebianRemoteHandler) handleInRelease(c *gin.Context, repoKey, path string, repoConfig *configstore.RepoConfig) {
 	log.Infof("Handling InRelease for repoKey=%s, path=%s", repoKey, path)
-	resp := r.forwardRequest(c, repoKey, path, repoConfig)
-	r.writeResponse(c, resp)
+	r.serveMetadata(c, repoKey, path, repoConfig)
 }
 
 func (r *DebianRemoteHandler) handleRelease(c *gin.Context, repoKey, path string, repoConfig *configstore.RepoConfig) {
 	log.Infof("Handling Release for repoKey=%s, path=%s", repoKey, path)
-	resp := r.forwardRequest(c, repoKey, path, repoConfig)
-	r.writeResponse(c, resp)
+	r.serveMetadata(c, repoKey, path, repoConfig)
 }
 
 func (r *DebianRemoteHandler) handleReleaseGPG(c *gin.Context, repoKey, path string, repoConfig *configstore.RepoConfig) {
 	log.Infof("Handling ReleaseGPG for repoKey=%s, path=%s", repoKey, path)
-	resp := r.forwardRequest(c, repoKey, path, repoConfig)
-	r.writeResponse(c, resp)
+	r.serveMetadata(c, repoKey, path, repoConfig)
 }
 
 func (r *DebianRemoteHandler) handlePackages(c *gin.Context, repoKey, path string, repoConfig *configstore.RepoConfig) {
 	log.Infof("Handling Packages for repoKey=%s, path=%s", repoKey, path)
-	resp := r.forwardRequest(c, repoKey, path, repoConfig)
-	r.writeResponse(c, resp)
+	r.serveMetadata(c, repoKey, path, repoConfig)
 }
 
 func (r *DebianRemoteHandler) handlePackagesGz(c *gin.Context, repoKey, path string, repoConfig *configstore.RepoConfig) {
 	log.Infof("Handling PackagesGz for repoKey=%s, path=%s", repoKey, path)
-	resp := r.forwardRequest(c, repoKey, path, repoConfig)
-	r.writeResponse(c, resp)
+	r.serveMetadata(c, repoKey, path, repoConfig)
 }
 
 func (r *DebianRemoteHandler) handlePackagesXz(c *gin.Context, repoKey, path string, repoConfig *configstore.RepoConfig) {
 	log.Infof("Handling PackagesXz for repoKey=%s, path=%s", repoKey, path)
-	resp := r.forwardRequest(c, repoKey, path, repoConfig)
-	r.writeResponse(c, resp)
+	r.serveMetadata(c, repoKey, path, repoConfig)
 }
 
-func (r *DebianRemoteHandler) getFile(repoKey, path string) (bool, io.ReadCloser, error) {
-	digestPath := filepath.Join("/tmp/filestore", repoKey, path)
-	// Check if digest file exists
-	if _, err := os.Stat(digestPath); err != nil {
-		if os.IsNotExist(err) {
-			return false, nil, fmt.Errorf("digest file does not exist: %w", err)
+// serveMetadata forwards a repository index file and caches the latest copy,
+// which is what an offline remote serves.
+func (r *DebianRemoteHandler) serveMetadata(c *gin.Context, repoKey, path string, repoConfig *configstore.RepoConfig) {
+	if repoConfig.Offline {
+		if !r.files.serve(c, repoKey, path, "text/plain") {
+			r.writeOffline(c, repoKey, path)
 		}
-		return false, nil, fmt.Errorf("failed to stat digest file: %w", err)
-	}
-	digestBytes, err := os.ReadFile(digestPath)
-	if err != nil {
-		return false, nil, fmt.Errorf("failed to read digest file: %w", err)
+		return
 	}
-	dgstStr := strings.TrimSpace(string(digestBytes))
-	dgst, err := digest.Parse(dgstStr)
-	if err != nil {
-		return false, nil, fmt.Errorf("invalid digest in digest file: %w", err)
+	resp := r.forwardRequest(c, repoKey, path, repoConfig)
+	if resp == nil || resp.StatusCode != http.StatusOK {
+		r.writeResponse(c, resp)
+		return
 	}
-	// Retrieve blob from blob store
-	blobReader, err := r.blobs.Get(context.Background(), dgst)
-	if err != nil {
-		return false, nil, fmt.Errorf("failed to get blob from blob store: %w", err)
+	defer resp.Body.Close()
+	if err := r.files.store(c.Request.Context(), repoKey, path, resp.Body, c.Writer); err != nil {
+		log.Errorf("Error caching %s for repoKey=%s: %v", path, repoKey, err)
 	}
-	return true, blobReader, nil
+}
+
+// writeOffline reports a file that an offline remote has not cached, in plain
+// text as apt shows it.
+func (r *DebianRemoteHandler) writeOffline(c *gin.Context, repoKey, path string) {
+	c.String(http.StatusNotFound, "%s not cached and %s is offline\n", path, repoKey)
 }
 
 func (r *DebianRemoteHandler) handlePool(c *gin.Context, repoKey, path string, repoConfig *configstore.RepoConfig) {
@@ -146,93 +141,30 @@ func (r *DebianRemoteHandler) handlePool(c *gin.Context, repoKey, path string, r
 		return
 	}
 	// Check if file exists in local filestore
-	found, blobReader, err := r.getFile(repoKey, path)
-	if err != nil {
-		log.Warnf("Error checking local filestore for file: %v", err)
+	if r.files.serve(c, repoKey, path, "application/vnd.debian.binary-package") {
+		return
 	}
-	if found {
-		log.Infof("Serving file from local filestore: repoKey=%s, path=%s", repoKey, path)
-		// Stream blob to response
-		_, err := io.Copy(c.Writer, blobReader)
-		if err != nil {
-			log.Errorf("Error streaming blob from local filestore: %v", err)
-			handleError(c, 500, fmt.Sprintf("Failed to stream blob: %v", err))
-			return
-		}
+	if repoConfig.Offline {
+		r.writeOffline(c, repoKey, path)
 		return
 	}
 	// File not found locally; fetch from upstream and store
 	log.Infof("File not found in local filestore; fetching from upstream: repoKey=%s, path=%s", repoKey, path)
-
-	// 1. Forward the request upstream
 	resp := r.forwardRequest(c, repoKey, path, repoConfig)
 	if resp == nil {
 		log.Errorf("Error forwarding request for blob: repoKey=%s, path=%s", repoKey, path)
-		handleError(c, 500, "failed to forward request")
-		return
-	}
-	// 2. Prepare sinks
-	h := sha256.New()
-	err = os.MkdirAll("/tmp/blobstore/tmp", 0o755)
-	if err != nil {
-		log.Errorf("Error creating temp directory for blob upload: %v", err)
-		handleError(c, 500, "Failed to create temp directory for blob upload")
-		return
-	}
-	tmpFile, err := os.CreateTemp("/tmp/blobstore/tmp", "upload-*")
-	if err != nil {
-		log.Errorf("Error creating temp file for blob upload: %v", err)
-		handleError(c, 500, "Failed to create temp file for blob upload")
-		return
-	}
-
-	downstream := c.Writer // Gins response writer
-
-	// 3. Multiwriter: temp file + hasher + downstream
-	mw := io.MultiWriter(tmpFile, h, downstream)
-
-	// 4. Copy upstream response into all three
-	if _, err := io.Copy(mw, resp.Body); err != nil {
-		log.Errorf("Error copying upstream response: %v", err)
-		handleError(c, 500, fmt.Sprintf("Failed to read upstream response: %v", err))
-		return
-	}
-	// 5. Finalize digest
-	dgst := digest.NewDigest(digest.SHA256, h)
-
-	// 6. Commit temp file to blob store
-	// Rewind temp file if Put needs to read from it.
-	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
-		log.Errorf("Error seeking temp file: %v", err)
-		handleError(c, 500, fmt.Sprintf("Failed to seek temp file: %v", err))
-		return
-	}
-	if err := r.blobs.Put(c.Request.Context(), dgst, tmpFile); err != nil {
-		log.Errorf("Error storing blob in blob store: %v", err)
-		handleError(c, 500, fmt.Sprintf("Failed to store blob: %v", err))
 		return
 	}
-	// 7. Delete temp file if needed
-	if err := tmpFile.Close(); err != nil {
-		log.Warnf("Failed to close temp file: %v", err)
-	}
-	if err := os.Remove(tmpFile.Name()); err != nil {
-		log.Warnf("Failed to remove temp file: %v", err)
-	}
-
-	// Save to index file
-	digestPath := filepath.Join("/tmp/filestore", repoKey, path)
-	err = os.MkdirAll(filepath.Dir(digestPath), 0o755)
-	if err != nil {
-		log.Errorf("Error creating directories for filestore: %v", err)
-		handleError(c, 500, fmt.Sprintf("Failed to create directories for filestore: %v", err))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+			log.Warnf("Error copying upstream response: %v", err)
+		}
 		return
 	}
-	err = os.WriteFile(digestPath, []byte(dgst.String()), 0o644)
-	if err != nil {
-		log.Errorf("Error writing digest file: %v", err)
-		handleError(c, 500, fmt.Sprintf("Failed to write digest file: %v", err))
-		return
+	// Stream to the client while storing the package.
+	if err := r.files.store(c.Request.Context(), repoKey, path, resp.Body, c.Writer); err != nil {
+		log.Errorf("Error caching %s for repoKey=%s: %v", path, repoKey, err)
 	}
 }
 
@@ -0,0 +1,69 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	"github.com/martencassel/gobinrepo/internal/util/filestore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebianOffline(t *testing.T) {
+	files := map[string]string{
+		"/dists/stable/Release":    "Suite: stable\n",
+		"/pool/main/f/foo_1.0.deb": "deb",
+		"/dists/stable/InRelease":  "signed",
+		"/pool/main/b/bar_1.0.deb": "other",
+	}
+	hits := map[string]int{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	bfs, err := blobs.NewBlobStoreFS(filepath.Join(dir, "blobs"))
+	assert.NoError(t, err)
+	store := configstore.NewRepoConfigStore()
+	cfg := configstore.RepoConfig{RepoKey: "deb", PackageType: configstore.PackageTypeDebian, RemoteURL: upstream.URL}
+	store.Add(cfg)
+	h := NewDebianRemoteHandler(bfs, filestore.NewFileStore(filepath.Join(dir, "files")), store, false)
+	r := gin.New()
+	h.RegisterRoutes(r)
+
+	for _, p := range []string{"/dists/stable/Release", "/pool/main/f/foo_1.0.deb"} {
+		w := serve(r, http.MethodGet, "/debian/deb"+p, nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, files[p], w.Body.String())
+	}
+	w := serve(r, http.MethodGet, "/debian/deb/pool/main/z/zed_1.0.deb", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	cfg.Offline = true
+	store.Add(cfg)
+	for _, p := range []string{"/dists/stable/Release", "/pool/main/f/foo_1.0.deb"} {
+		w := serve(r, http.MethodGet, "/debian/deb"+p, nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, files[p], w.Body.String())
+		assert.Equal(t, 1, hits[p])
+	}
+	for _, p := range []string{"/dists/stable/InRelease", "/pool/main/b/bar_1.0.deb", "/pool/main/z/zed_1.0.deb"} {
+		w := serve(r, http.MethodGet, "/debian/deb"+p, nil, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "deb is offline")
+	}
+	assert.Zero(t, hits["/dists/stable/InRelease"])
+	assert.Zero(t, hits["/pool/main/b/bar_1.0.deb"])
+}
@@ -2,68 +2,194 @@ package remote
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/martencassel/gobinrepo/internal/configstore"
 	"github.com/martencassel/gobinrepo/internal/mw"
 	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
 	"github.com/martencassel/gobinrepo/internal/util/oci"
-	"github.com/martencassel/gobinrepo/internal/util/trace"
+	"github.com/martencassel/gobinrepo/internal/util/uploads"
 	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 )
 
 type DockerRemoteHandler struct {
-	blobs blobs.BlobStore
-	store *configstore.RepoConfigStore
+	blobs     blobs.BlobStore
+	fills     *blobs.FillGroup
+	clients   *clientPool
+	manifests *manifests.Store
+	uploads   *uploads.Store
+	store     *configstore.RepoConfigStore
 	*configstore.RepoConfigStore
 	traceEnable bool
+	// mirror is the repoKey serving unprefixed paths, as sent by dockerd
+	// configured with registry-mirrors.
+	mirror string
+
+	// bg scopes background work such as caching referrers; Close cancels it.
+	bg     context.Context
+	stopBg context.CancelFunc
+	bgWG   sync.WaitGroup
+	// prefetching holds the indexes whose platform images are being fetched.
+	prefetching sync.Map
+	// inventorying holds the images whose package inventory is being built.
+	inventorying sync.Map
+	jobs         *prefetchJobs
+	// keys caches parsed cosign public keys by path; verified holds the
+	// repoKey@digest of manifests that passed their signature policy.
+	keys     sync.Map
+	verified sync.Map
 }
 
-func NewDockerRemoteHandler(blobs blobs.BlobStore, store *configstore.RepoConfigStore, traceEnable bool) *DockerRemoteHandler {
+func NewDockerRemoteHandler(blobStore blobs.BlobStore, manifests *manifests.Store, uploads *uploads.Store, store *configstore.RepoConfigStore, traceEnable bool) *DockerRemoteHandler {
+	bg, stopBg := context.WithCancel(context.Background())
 	return &DockerRemoteHandler{
-		blobs:       blobs,
+		bg:          bg,
+		stopBg:      stopBg,
+		blobs:       blobStore,
+		fills:       blobs.NewFillGroup(blobStore),
+		clients:     newClientPool(traceEnable),
+		manifests:   manifests,
+		uploads:     uploads,
 		store:       store,
 		traceEnable: traceEnable,
+		jobs:        newPrefetchJobs(DefaultPrefetchConcurrency),
 	}
 }
 
+// Close aborts in-flight upstream downloads and background work and
+// releases upstream clients.
+func (h *DockerRemoteHandler) Close() {
+	h.stopBg()
+	h.fills.Close()
+	h.bgWG.Wait()
+	h.clients.Close()
+}
+
+// goBackground runs fn detached from any request, until Close.
+func (h *DockerRemoteHandler) goBackground(fn func(ctx context.Context)) {
+	h.bgWG.Add(1)
+	go func() {
+		defer h.bgWG.Done()
+		fn(h.bg)
+	}()
+}
+
 // RegisterRoutes registers the Docker Remote Registry API routes
 func (h *DockerRemoteHandler) RegisterRoutes(r *gin.Engine) {
-	r.GET("/v2", func(c *gin.Context) {
+	apiVersion := func(c *gin.Context) {
 		c.Header("Docker-Distribution-API-Version", "registry/2.0")
 		c.Status(http.StatusOK)
-	})
+	}
+	r.GET("/v2", apiVersion)
+	r.HEAD("/v2", apiVersion)
+	r.GET("/v2/_catalog", h.GetCatalog)
 	r.GET("/v2/:repoKey/*path", h.handleV2)
+	r.HEAD("/v2/:repoKey/*path", h.handleV2)
+	// Writes are only accepted by hosted repositories.
+	r.POST("/v2/:repoKey/*path", h.handleV2)
+	r.PATCH("/v2/:repoKey/*path", h.handleV2)
+	r.PUT("/v2/:repoKey/*path", h.handleV2)
+	r.DELETE("/v2/:repoKey/*path", h.handleV2)
+
+	r.POST("/api/prefetch", h.StartPrefetch)
+	r.GET("/api/prefetch/:id", h.GetPrefetch)
+	r.GET("/api/pins", h.ListPins)
+	r.POST("/api/pins/approve", h.ApprovePin)
+	r.GET("/api/ratelimits", h.GetRateLimits)
+	r.GET("/api/docker/:repoKey/inspect/*ref", h.InspectImage)
+	r.GET("/api/docker/:repoKey/sbom/*ref", h.GetSBOM)
 }
 
 func (h *DockerRemoteHandler) handleV2(c *gin.Context) {
 	repoKey := c.Param("repoKey")
 	rest := strings.TrimPrefix(c.Param("path"), "/")
+	if rest == "_catalog" && c.Request.Method == http.MethodGet {
+		if _, ok := h.store.Get(repoKey); !ok {
+			writeUnknownRepo(c)
+			return
+		}
+		h.writeCatalog(c, []string{repoKey})
+		return
+	}
+	if mirror, ok := h.routeFor(c.Request, repoKey); ok {
+		// An unknown first component is part of the image name, e.g.
+		// /v2/library/nginx/manifests/latest from a docker daemon or
+		// containerd using gobinrepo as a mirror. The path is rewritten so
+		// the handlers below parse it as if it carried the routed repoKey.
+		rest = strings.TrimSuffix(repoKey+"/"+rest, "/")
+		repoKey = mirror
+		c.Request.URL.Path = "/v2/" + repoKey + "/" + rest
+		c.Request.URL.RawPath = ""
+	}
+	if cfg, ok := h.store.Get(repoKey); ok {
+		switch cfg.Kind {
+		case configstore.RepoKindHosted:
+			h.handleHostedV2(c, &cfg, rest)
+			return
+		case configstore.RepoKindVirtual:
+			h.handleVirtualV2(c, &cfg, rest)
+			return
+		}
+	}
+	if m := c.Request.Method; m != http.MethodGet && m != http.MethodHead {
+		writeRegistryError(c, http.StatusMethodNotAllowed, codeUnsupported, "repository is read-only")
+		return
+	}
 	switch {
 	case strings.Contains(rest, "/manifests/"):
 		parts := strings.SplitN(rest, "/manifests/", 2)
 		if len(parts) != 2 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid manifest path"})
+			writeRegistryError(c, http.StatusBadRequest, codeNameInvalid, "invalid manifest path")
+			return
+		}
+		if c.Request.Method == http.MethodHead {
+			h.HeadManifestWithParams(c, repoKey, parts[0], parts[1])
 			return
 		}
 		h.GetManifestWithParams(c, repoKey, parts[0], parts[1])
 	case strings.Contains(rest, "/blobs/"):
 		parts := strings.SplitN(rest, "/blobs/", 2)
 		name, digest := parts[0], parts[1]
+		if c.Request.Method == http.MethodHead {
+			h.HeadBlobWithParams(c, repoKey, name, digest)
+			return
+		}
 		h.GetBlobWithParams(c, repoKey, name, digest)
+	case strings.Contains(rest, "/referrers/"):
+		if c.Request.Method == http.MethodHead {
+			c.Status(http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.SplitN(rest, "/referrers/", 2)
+		cfg, ok := h.store.Get(repoKey)
+		if !ok {
+			writeUnknownRepo(c)
+			return
+		}
+		h.GetReferrers(c, &cfg, parts[0], parts[1])
 	case strings.Contains(rest, "/tags/list"):
+		if c.Request.Method == http.MethodHead {
+			c.Status(http.StatusMethodNotAllowed)
+			return
+		}
 		parts := strings.SplitN(rest, "/tags/list", 2)
 		name := parts[0]
-		h.GetTagListWithParams(c, name)
+		h.GetTagListWithParams(c, repoKey, name)
 	default:
-		c.JSON(http.StatusNotFound, gin.H{"error": "unsupported v2 path"})
+		writeRegistryError(c, http.StatusNotFound, codeUnsupported, "unsupported v2 path")
 	}
 }
 
@@ -83,12 +209,12 @@ func (h *DockerRemoteHandler) GetBlobWithParams(c *gin.Context, repoKey, name, d
 func (h *DockerRemoteHandler) GetManifest(c *gin.Context) {
 	repoKey, ok := repoKeyFromContext(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing repoKey"})
+		writeRegistryError(c, http.StatusBadRequest, codeNameInvalid, "invalid or missing repoKey")
 		return
 	}
 	cfg, ok := h.store.Get(repoKey)
 	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "unknown repoKey: repository configuration not found"})
+		writeUnknownRepo(c)
 		return
 	}
 	log.Infof("Using repo config: %s", cfg.String())
@@ -98,32 +224,199 @@ func (h *DockerRemoteHandler) GetManifest(c *gin.Context) {
 
 	url, err := oci.ParseOCIURL(reqURL)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OCI URL"})
+		writeRegistryError(c, http.StatusBadRequest, codeNameInvalid, "invalid OCI URL")
 		return
 	}
-	client := newTracedRegistryClient(cfg.RemoteURL, h.traceEnable, &cfg)
+	if url.Reference.IsTag() && !oci.IsValidTag(url.Reference.Tag) {
+		writeRegistryError(c, http.StatusBadRequest, codeTagInvalid, "invalid tag")
+		return
+	}
+	ctx := c.Request.Context()
+	start := time.Now()
 	normalizedName := normalizeName(cfg.RemoteURL, url.Name.Rest())
 
-	resp, err := client.GetManifest(c.Request.Context(), normalizedName, url.Reference.String(), c.Request.Header)
+	// Conditional headers are answered locally against the stored digest; an
+	// upstream 304 would leave nothing to cache or serve.
+	desc, data, err := h.remoteManifest(ctx, &cfg, normalizedName, url.Reference, upstreamHeaders(c.Request.Header))
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to get manifest from upstream"})
+		if !writeOffline(c, &cfg, err, codeManifestUnknown) && !writeDenied(c, err) && !writeManifestRefused(c, err) {
+			writeUpstreamError(c, err, codeManifestUnknown, "failed to get manifest from upstream")
+		}
 		return
 	}
+	writeManifest(c, desc, data)
+	log.WithFields(log.Fields{
+		"repoKey":  repoKey,
+		"name":     normalizedName,
+		"ref":      url.Reference.String(),
+		"digest":   desc.Digest,
+		"size":     desc.Size,
+		"duration": time.Since(start).Round(time.Millisecond),
+	}).Info("Manifest served")
+}
+
+// remoteManifest resolves ref in a remote repository. Digest references are
+// immutable, so a cached copy is always served; tags go through resolveTag.
+// Either way the manifest must pass the remote's signature policy, and schema
+// 1 manifests are refused unless the remote allows them.
+func (h *DockerRemoteHandler) remoteManifest(ctx context.Context, cfg *configstore.RepoConfig, name string, ref oci.Reference, hdr http.Header) (manifests.Descriptor, []byte, error) {
+	if ref.IsDigest() {
+		desc, data, err := h.manifests.Get(ctx, digest.Digest(ref.Digest))
+		switch {
+		case err == nil:
+			if err := checkSchema1(cfg, desc); err != nil {
+				return manifests.Descriptor{}, nil, err
+			}
+			if err := h.verifyManifest(ctx, cfg, name, desc, data); err != nil {
+				return manifests.Descriptor{}, nil, err
+			}
+			h.linkRevision(cfg.RepoKey, name, desc.Digest)
+			h.inventoryImage(cfg, name, desc, data)
+			log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "digest": desc.Digest}).Debug("Manifest served from local store")
+			return desc, data, nil
+		case !errors.Is(err, manifests.ErrNotFound):
+			log.Warnf("failed to read cached manifest %s: %v", ref.Digest, err)
+		}
+	}
+	client := h.clients.Get(cfg)
+	var desc manifests.Descriptor
+	var data []byte
+	var err error
+	if ref.IsTag() {
+		desc, data, err = h.resolveTag(ctx, cfg, client, name, ref.Tag, hdr)
+	} else {
+		desc, data, err = h.fetchManifest(ctx, client, name, ref, hdr)
+		if err == nil {
+			h.linkRevision(cfg.RepoKey, name, desc.Digest)
+		}
+	}
+	if err != nil {
+		return manifests.Descriptor{}, nil, err
+	}
+	if err := checkSchema1(cfg, desc); err != nil {
+		return manifests.Descriptor{}, nil, err
+	}
+	if err := h.verifyManifest(ctx, cfg, name, desc, data); err != nil {
+		return manifests.Descriptor{}, nil, err
+	}
+	h.prefetchPlatforms(cfg, name, desc, data)
+	h.inventoryImage(cfg, name, desc, data)
+	return desc, data, nil
+}
+
+// linkRevision records that manifest d was pulled through repository name, so
+// the repository shows up in the catalog. Tags are recorded by resolveTag.
+func (h *DockerRemoteHandler) linkRevision(repoKey, name string, d digest.Digest) {
+	linked, err := h.manifests.HasRevision(repoKey, name, d)
+	if err == nil && !linked {
+		err = h.manifests.PutRevision(repoKey, name, d)
+	}
+	if err != nil {
+		log.Warnf("failed to link manifest %s: %v", d, err)
+	}
+}
+
+// fetchManifest retrieves a manifest from upstream and caches it.
+func (h *DockerRemoteHandler) fetchManifest(ctx context.Context, client *oci.RegistryClient, name string, ref oci.Reference, hdr http.Header) (manifests.Descriptor, []byte, error) {
+	resp, err := client.GetManifest(ctx, name, ref.String(), hdr)
+	if err != nil {
+		if resp != nil {
+			err = newUpstreamError(resp, err)
+			_ = resp.Body.Close()
+		}
+		return manifests.Descriptor{}, nil, err
+	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
 			log.Warnf("failed to close response body: %v", cerr)
 		}
 	}()
+	return h.cacheManifest(ctx, resp, ref)
+}
 
-	c.Status(resp.StatusCode)
-	for k, v := range resp.Header {
-		for _, vv := range v {
-			c.Header(k, vv)
+// cacheManifest reads an upstream manifest response, verifies it against the
+// digests the upstream and the client claim, and stores it in the manifest store.
+func (h *DockerRemoteHandler) cacheManifest(ctx context.Context, resp *http.Response, ref oci.Reference) (manifests.Descriptor, []byte, error) {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, manifests.MaxManifestSize+1))
+	if err != nil {
+		return manifests.Descriptor{}, nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if len(data) > manifests.MaxManifestSize {
+		return manifests.Descriptor{}, nil, fmt.Errorf("manifest exceeds %d bytes", manifests.MaxManifestSize)
+	}
+	mediaType := manifestMediaType(resp.Header.Get("Content-Type"), data)
+	for _, want := range []string{resp.Header.Get("Docker-Content-Digest"), ref.Digest} {
+		// The digest of a signed schema 1 manifest leaves out its
+		// signatures, so it cannot be checked against the bytes.
+		if want == "" || mediaType == oci.MediaTypeDockerSchema1Signed {
+			continue
+		}
+		d, err := digest.Parse(want)
+		if err != nil {
+			return manifests.Descriptor{}, nil, fmt.Errorf("invalid digest %q: %w", want, err)
+		}
+		if got := d.Algorithm().FromBytes(data); got != d {
+			return manifests.Descriptor{}, nil, fmt.Errorf("manifest digest mismatch: got %s, want %s", got, d)
 		}
 	}
-	_, err = io.Copy(c.Writer, resp.Body)
+	desc, err := h.manifests.Put(ctx, mediaType, data)
 	if err != nil {
-		log.Errorf("Failed to copy response body: %v", err)
+		return manifests.Descriptor{}, nil, err
+	}
+	return desc, data, nil
+}
+
+// manifestMediaType prefers the upstream Content-Type and falls back to the
+// mediaType field embedded in the manifest itself.
+func manifestMediaType(contentType string, data []byte) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil && mt != "" && mt != "application/json" && mt != "text/plain" {
+		return mt
+	}
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(data, &probe); err == nil && probe.MediaType != "" {
+		return probe.MediaType
+	}
+	return v1.MediaTypeImageManifest
+}
+
+// writeManifestHeaders sets the headers the distribution spec requires for
+// a manifest response, without a body.
+func writeManifestHeaders(c *gin.Context, desc manifests.Descriptor) {
+	c.Header("Content-Type", desc.MediaType)
+	if desc.Size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(desc.Size, 10))
+	}
+	c.Header("Docker-Content-Digest", desc.Digest.String())
+	c.Header("ETag", fmt.Sprintf(`"%s"`, desc.Digest))
+	c.Status(http.StatusOK)
+}
+
+// writeManifest answers a manifest request, with a body unless the request is
+// a HEAD or the client already holds the manifest. A manifest of a media type
+// the client does not accept is not served; schema 1 manifests carry a
+// deprecation warning.
+func writeManifest(c *gin.Context, desc manifests.Descriptor, data []byte) {
+	if !oci.Acceptable(acceptedTypes(c.Request.Header), desc.MediaType) {
+		writeNotAcceptable(c)
+		return
+	}
+	if oci.IsSchema1(desc.MediaType) {
+		c.Header("Warning", schema1Warning)
+	}
+	if etagMatches(c.Request, desc.Digest) {
+		c.Header("Docker-Content-Digest", desc.Digest.String())
+		c.Header("ETag", fmt.Sprintf(`"%s"`, desc.Digest))
+		c.Status(http.StatusNotModified)
+		return
+	}
+	writeManifestHeaders(c, desc)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if _, err := c.Writer.Write(data); err != nil {
+		log.Errorf("Failed to write manifest: %v", err)
 	}
 }
 
@@ -131,12 +424,12 @@ func (h *DockerRemoteHandler) GetManifest(c *gin.Context) {
 func (h *DockerRemoteHandler) GetBlob(c *gin.Context) {
 	repoKey, ok := repoKeyFromContext(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing repoKey"})
+		writeRegistryError(c, http.StatusBadRequest, codeNameInvalid, "invalid or missing repoKey")
 		return
 	}
 	cfg, ok := h.store.Get(repoKey)
 	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "unknown repoKey"})
+		writeUnknownRepo(c)
 		return
 	}
 	log.Infof("Using repo config: %s", cfg.String())
@@ -156,7 +449,7 @@ func (h *DockerRemoteHandler) GetBlob(c *gin.Context) {
 	// Parse and validate
 	ociURL, requestDigest, err := oci.ParseDigestURL(c.Request.URL.String(), "registry-1.docker.io")
 	if err != nil {
-		writeError(c, http.StatusBadRequest, "Invalid blob request", err)
+		writeRegistryError(c, http.StatusBadRequest, codeDigestInvalid, "invalid blob request")
 		return
 	}
 
@@ -189,120 +482,240 @@ func (h *DockerRemoteHandler) streamBlob(req *blobRequest, cfg *configstore.Repo
 	// Try local cache
 	exists, err := h.blobs.Exists(req.Ctx, req.Digest)
 	if err != nil {
-		req.Gin.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check blob existence"})
+		writeInternalError(req.Gin, "failed to check blob existence", err)
 		return
 	}
 	if exists {
-		// Handle conditional headers
-		if inm := req.Gin.Request.Header.Get("If-None-Match"); inm == etag {
-			req.Gin.Writer.Header().Set("ETag", etag)
-			req.Gin.Writer.Header().Set("Docker-Content-Digest", dockerContentDigest)
-			req.Gin.Writer.WriteHeader(http.StatusNotModified)
-			return
-		}
-
-		reader, err := h.blobs.Get(req.Ctx, req.Digest)
-		if err != nil {
-			req.Gin.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open blob"})
-			return
-		}
-		defer func() {
-			if cerr := reader.Close(); cerr != nil {
-				log.Warnf("failed to close reader: %v", cerr)
-			}
-		}()
-		req.Gin.Writer.Header().Set("ETag", etag)
-		req.Gin.Writer.Header().Set("Docker-Content-Digest", dockerContentDigest)
-
-		// Cache-Control: public, max-age=31536000, immutable
-		req.Gin.Writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-		written, err := io.Copy(req.Gin.Writer, reader)
-		if err != nil {
-			req.Gin.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stream blob"})
-			return
-		}
-
-		req.Logger.WithFields(log.Fields{
-			"digest":   req.Digest,
-			"size":     written,
-			"duration": time.Since(req.Start).Round(time.Millisecond),
-		}).Info("Blob served from local store")
+		h.serveCachedBlob(req)
 		return
 	}
-	client := newTracedRegistryClient(cfg.RemoteURL, h.traceEnable, cfg)
+	client := h.clients.Get(cfg)
 
 	normalizedName := normalizeName(cfg.RemoteURL, req.URL.Name.Rest())
 
-	resp, err := client.GetBlob(req.Ctx, normalizedName, req.URL.Reference.String(), req.Gin.Request.Header)
+	// Always fetch the whole blob so the cache fill stays intact; a client
+	// range is cut out of the stream on the way through. Conditional headers
+	// are answered locally, since the digest identifies the content.
+	rangeHdr := requestedRange(req.Gin.Request, etag)
+	upstreamHdr := upstreamHeaders(req.Gin.Request.Header, "Range", "If-Range")
+
+	fetch := blobFetcher(client, normalizedName, req.Digest, upstreamHdr)
+	// Concurrent misses for the same digest share one upstream download.
+	fill := h.fills.Join(req.Digest, cfg.RepoKey, fetch)
+	size, err := fill.Wait(req.Ctx)
+	if err != nil && fill.Origin != cfg.RepoKey {
+		// The shared download ran with another remote's name and credentials;
+		// that remote failing says nothing about ours.
+		req.Logger.WithError(err).WithField("origin", fill.Origin).Debug("Shared blob fetch failed, retrying with own remote")
+		fill = h.fills.Join(req.Digest, cfg.RepoKey, fetch)
+		size, err = fill.Wait(req.Ctx)
+	}
 	if err != nil {
-		req.Gin.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch blob from upstream"})
+		if !writeOffline(req.Gin, cfg, err, codeBlobUnknown) {
+			writeUpstreamError(req.Gin, err, codeBlobUnknown, "failed to fetch blob from upstream")
+		}
+		return
+	}
+	reader, err := fill.NewReader(req.Ctx)
+	if err != nil {
+		writeUpstreamError(req.Gin, err, codeBlobUnknown, "failed to fetch blob from upstream")
 		return
 	}
 	defer func() {
-		if cerr := resp.Body.Close(); cerr != nil {
-			log.Warnf("failed to close response body: %v", cerr)
+		if cerr := reader.Close(); cerr != nil {
+			log.Warnf("failed to close reader: %v", cerr)
 		}
 	}()
 
-	// If upstream says Not Modified, just forward that
-	if resp.StatusCode == http.StatusNotModified {
-		req.Gin.Writer.Header().Set("ETag", etag)
-		req.Gin.Writer.Header().Set("Docker-Content-Digest", dockerContentDigest)
-		req.Gin.Writer.WriteHeader(http.StatusNotModified)
+	req.Gin.Header("ETag", etag)
+	req.Gin.Header("Docker-Content-Digest", dockerContentDigest)
+	req.Gin.Header("Content-Type", "application/octet-stream")
+	req.Gin.Header("Accept-Ranges", "bytes")
+
+	// A range can only be served when upstream told us the full size.
+	status := http.StatusOK
+	body := io.Reader(reader)
+	if size >= 0 {
+		length := size
+		rng, partial, err := selectRange(rangeHdr, size)
+		if err != nil {
+			writeRangeError(req.Gin.Writer, size)
+			return
+		}
+		if partial {
+			if err := seekTo(reader, rng.start); err != nil {
+				writeInternalError(req.Gin, "failed to seek blob", err)
+				return
+			}
+			req.Gin.Header("Content-Range", rng.contentRange(size))
+			status = http.StatusPartialContent
+			body = io.LimitReader(reader, rng.length)
+			length = rng.length
+		}
+		req.Gin.Header("Content-Length", strconv.FormatInt(length, 10))
+	}
+	req.Gin.Status(status)
+
+	written, err := io.Copy(req.Gin.Writer, body)
+	if err != nil {
+		if errors.Is(req.Ctx.Err(), context.Canceled) {
+			req.Logger.WithFields(log.Fields{
+				"digest": req.Digest,
+			}).Warn("Streaming aborted due to server shutdown or client disconnect")
+		} else {
+			req.Logger.WithError(err).WithField("digest", req.Digest).Warn("Streaming blob from upstream failed")
+		}
 		return
 	}
 
-	// Copy response headers from upstream, but ensure consistent ETag and Docker-Content-Digest
-	for k, v := range resp.Header {
-		// Skip ETag and Docker-Content-Digest as we'll set them consistently
-		if k != "ETag" && k != "Docker-Content-Digest" {
-			for _, vv := range v {
-				req.Gin.Header(k, vv)
+	req.Logger.WithFields(log.Fields{
+		"digest":   req.Digest,
+		"size":     written,
+		"duration": time.Since(req.Start).Round(time.Millisecond),
+		"status":   status,
+	}).Info("Blob streamed from upstream")
+}
+
+// blobFetcher returns a FetchFunc downloading d from upstream.
+func blobFetcher(client *oci.RegistryClient, name string, d digest.Digest, hdr http.Header) blobs.FetchFunc {
+	return func(ctx context.Context) (io.ReadCloser, int64, error) {
+		resp, err := client.FetchBlob(ctx, name, d.String(), hdr)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			ue := newUpstreamError(resp, fmt.Errorf("blob fetch failed (%s): %s", d, resp.Status))
+			if cerr := resp.Body.Close(); cerr != nil {
+				log.Warnf("failed to close response body: %v", cerr)
 			}
+			return nil, 0, ue
 		}
+		return resp.Body, resp.ContentLength, nil
 	}
+}
 
-	// Set consistent headers based on the request digest
-	req.Gin.Header("ETag", etag)
-	req.Gin.Header("Docker-Content-Digest", dockerContentDigest)
-	req.Gin.Status(resp.StatusCode)
+// cacheBlob makes sure d is in the blob store, downloading it from upstream
+// through the fill group when it is missing.
+func (h *DockerRemoteHandler) cacheBlob(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name string, d digest.Digest) error {
+	exists, err := h.blobs.Exists(ctx, d)
+	if err != nil || exists {
+		return err
+	}
+	return h.fills.Join(d, cfg.RepoKey, blobFetcher(client, name, d, nil)).Done(ctx)
+}
+
+// serveCachedBlob streams a blob from the local store, honoring conditional
+// and range headers.
+func (h *DockerRemoteHandler) serveCachedBlob(req *blobRequest) {
+	etag := fmt.Sprintf(`"%s"`, req.Digest.String())
+	dockerContentDigest := req.Digest.String()
+
+	// Handle conditional headers
+	if etagMatches(req.Gin.Request, req.Digest) {
+		req.Gin.Writer.Header().Set("ETag", etag)
+		req.Gin.Writer.Header().Set("Docker-Content-Digest", dockerContentDigest)
+		req.Gin.Writer.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-	writer, err := h.blobs.WriterAtomic(req.Ctx, req.Digest)
+	reader, err := h.blobs.Get(req.Ctx, req.Digest)
 	if err != nil {
-		req.Gin.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create blob writer"})
+		writeInternalError(req.Gin, "failed to open blob", err)
 		return
 	}
 	defer func() {
-		if cerr := writer.Close(); cerr != nil {
-			log.Warnf("failed to close writer: %v", cerr)
+		if cerr := reader.Close(); cerr != nil {
+			log.Warnf("failed to close reader: %v", cerr)
 		}
 	}()
-
-	written, err := io.Copy(io.MultiWriter(req.Gin.Writer, writer), resp.Body)
+	size, err := h.blobs.Stat(req.Ctx, req.Digest)
 	if err != nil {
-		if errors.Is(req.Ctx.Err(), context.Canceled) {
-			req.Logger.WithFields(log.Fields{
-				"digest": req.Digest,
-			}).Warn("Streaming aborted due to server shutdown or client disconnect")
-		} else {
-			req.Gin.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stream blob"})
+		writeInternalError(req.Gin, "failed to stat blob", err)
+		return
+	}
+	req.Gin.Writer.Header().Set("ETag", etag)
+	req.Gin.Writer.Header().Set("Docker-Content-Digest", dockerContentDigest)
+	req.Gin.Writer.Header().Set("Accept-Ranges", "bytes")
+	req.Gin.Writer.Header().Set("Content-Type", "application/octet-stream")
+
+	// Cache-Control: public, max-age=31536000, immutable
+	req.Gin.Writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	status := http.StatusOK
+	body := io.Reader(reader)
+	length := size
+	rangeHdr := requestedRange(req.Gin.Request, etag)
+	rng, partial, err := selectRange(rangeHdr, size)
+	if err != nil {
+		req.Logger.WithError(err).WithField("range", rangeHdr).Debug("Rejecting range request")
+		writeRangeError(req.Gin.Writer, size)
+		return
+	}
+	if partial {
+		if err := seekTo(reader, rng.start); err != nil {
+			writeInternalError(req.Gin, "failed to seek blob", err)
+			return
 		}
+		req.Gin.Writer.Header().Set("Content-Range", rng.contentRange(size))
+		status = http.StatusPartialContent
+		body = io.LimitReader(reader, rng.length)
+		length = rng.length
+	}
+	req.Gin.Writer.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	req.Gin.Writer.WriteHeader(status)
+
+	written, err := io.Copy(req.Gin.Writer, body)
+	if err != nil {
+		req.Logger.WithError(err).WithField("digest", req.Digest).Warn("Streaming cached blob aborted")
 		return
 	}
 
 	req.Logger.WithFields(log.Fields{
 		"digest":   req.Digest,
 		"size":     written,
+		"status":   status,
 		"duration": time.Since(req.Start).Round(time.Millisecond),
-		"status":   resp.StatusCode,
-	}).Info("Blob streamed from upstream")
+	}).Info("Blob served from local store")
 }
 
+// writeError answers a failed request to one of the /api endpoints.
 func writeError(c *gin.Context, status int, msg string, err error) {
 	log.WithError(err).Warn(msg)
 	c.JSON(status, gin.H{"error": msg})
 }
 
+// conditionalHeaders are never forwarded upstream: content is addressed by
+// digest, so they are evaluated locally instead.
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"}
+
+// upstreamHeaders copies the client headers worth forwarding upstream,
+// dropping conditional headers and any extra ones named in drop.
+func upstreamHeaders(hdr http.Header, drop ...string) http.Header {
+	out := hdr.Clone()
+	for _, k := range conditionalHeaders {
+		out.Del(k)
+	}
+	for _, k := range drop {
+		out.Del(k)
+	}
+	return out
+}
+
+// etagMatches reports whether the request's If-None-Match names d.
+func etagMatches(r *http.Request, d digest.Digest) bool {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || strings.Trim(tag, `"`) == d.String() {
+			return true
+		}
+	}
+	return false
+}
+
 func repoKeyFromContext(c *gin.Context) (string, bool) {
 	v, ok := c.Get("RepoKey")
 	if !ok {
@@ -312,11 +725,14 @@ func repoKeyFromContext(c *gin.Context) (string, bool) {
 	return key, ok
 }
 
+// dockerHubURL is the remote URL of Docker Hub.
+const dockerHubURL = "https://registry-1.docker.io"
+
 // normalizeName applies registry-specific normalization rules.
 // For Docker Hub (registry-1.docker.io), unscoped names are prefixed with "library/".
 // For all other registries, the name is returned unchanged.
 func normalizeName(remoteURL, name string) string {
-	if remoteURL == "https://registry-1.docker.io" {
+	if remoteURL == dockerHubURL {
 		// If name already contains a slash, leave it alone
 		if strings.Contains(name, "/") {
 			return name
@@ -326,44 +742,22 @@ func normalizeName(remoteURL, name string) string {
 	return name
 }
 
-func newDefaultTransport() *http.Transport {
-	tr := http.DefaultTransport.(*http.Transport).Clone()
-	tr.MaxIdleConns = 500
-	tr.MaxIdleConnsPerHost = 500
-	tr.IdleConnTimeout = 90 * time.Second
-	tr.TLSHandshakeTimeout = 10 * time.Second
-	tr.ExpectContinueTimeout = 1 * time.Second
-	tr.ResponseHeaderTimeout = 15 * time.Second
-	tr.DisableCompression = true // blobs are already compressed
-	return tr
-}
-
-func newTracedRegistryClient(remoteURL string, traceUpstream bool, cfg *configstore.RepoConfig) *oci.RegistryClient {
-	base := newDefaultTransport()
-	var rt http.RoundTripper = base
-	if cfg.Username != "" {
-		rt = &oci.BasicAuthRoundTripper{
-			Username: cfg.Username,
-			Password: cfg.Password,
-			Base:     rt,
-		}
-	}
-	rt = oci.NewTokenRoundTripper(true, oci.WithTransport(rt))
-	if traceUpstream {
-		rt = &trace.TracingRoundTripper{Base: rt}
-	}
-	return oci.NewRegistryClient(remoteURL, rt)
+func (h *DockerRemoteHandler) GetTagListWithParams(c *gin.Context, repoKey, name string) {
+	c.Set("RepoKey", repoKey)
+	c.Set("SubPath", name+"/tags/list")
+	h.GetTagList(c)
 }
 
-func (h *DockerRemoteHandler) GetTagListWithParams(c *gin.Context, name string) {
+// GetTagList handles tags/list for remote repositories.
+func (h *DockerRemoteHandler) GetTagList(c *gin.Context) {
 	repoKey, ok := repoKeyFromContext(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing repoKey"})
+		writeRegistryError(c, http.StatusBadRequest, codeNameInvalid, "invalid or missing repoKey")
 		return
 	}
 	cfg, ok := h.store.Get(repoKey)
 	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "unknown repoKey: repository configuration not found"})
+		writeUnknownRepo(c)
 		return
 	}
 	log.Infof("Using repo config: %s", cfg.String())
@@ -373,15 +767,39 @@ func (h *DockerRemoteHandler) GetTagListWithParams(c *gin.Context, name string)
 
 	url, err := oci.ParseOCIURL(reqURL)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OCI URL"})
+		writeRegistryError(c, http.StatusBadRequest, codeNameInvalid, "invalid OCI URL")
 		return
 	}
-	client := newTracedRegistryClient(cfg.RemoteURL, h.traceEnable, &cfg)
+	mode := cfg.TagList
+	if source := c.Query("source"); source != "" {
+		if mode, err = configstore.ParseTagListMode(source); err != nil {
+			writeRegistryError(c, http.StatusBadRequest, codeUnsupported, "invalid tag list source")
+			return
+		}
+	}
+	if cfg.Offline {
+		mode = configstore.TagListCached
+	}
+	client := h.clients.Get(&cfg)
 	normalizedName := normalizeName(cfg.RemoteURL, url.Name.Rest())
 
+	if mode != configstore.TagListUpstream {
+		tags, err := h.listTags(c.Request.Context(), &cfg, client, normalizedName, mode, upstreamHeaders(c.Request.Header))
+		if err != nil {
+			writeUpstreamError(c, err, codeNameUnknown, "failed to get tag list from upstream")
+			return
+		}
+		if len(tags) == 0 {
+			writeRegistryError(c, http.StatusNotFound, codeNameUnknown, "repository name not known to registry")
+			return
+		}
+		writeTagList(c, repoKey+"/"+url.Name.Rest(), tags)
+		return
+	}
+
 	resp, err := client.GetTagList(c.Request.Context(), normalizedName, c.Request.Header)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to get tag list from upstream"})
+		writeUpstreamError(c, err, codeNameUnknown, "failed to get tag list from upstream")
 		return
 	}
 	defer func() {
@@ -389,6 +807,10 @@ func (h *DockerRemoteHandler) GetTagListWithParams(c *gin.Context, name string)
 			log.Warnf("failed to close response body: %v", cerr)
 		}
 	}()
+	if resp.StatusCode != http.StatusOK {
+		writeUpstreamError(c, newUpstreamError(resp, fmt.Errorf("tag list failed: %s", resp.Status)), codeNameUnknown, "failed to get tag list from upstream")
+		return
+	}
 
 	c.Status(resp.StatusCode)
 	for k, v := range resp.Header {
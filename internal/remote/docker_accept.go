@@ -0,0 +1,174 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// schema1Warning is sent with schema 1 manifests a remote is allowed to
+// serve; docker prints Warning headers of registry responses.
+const schema1Warning = `299 - "Docker Image manifest version 2, schema 1 is deprecated and will stop being served"`
+
+var (
+	// errNotAcceptable means the manifest exists, but not in a media type
+	// the client accepts.
+	errNotAcceptable = errors.New("manifest not available in an accepted media type")
+	// errSchema1 refuses a deprecated schema 1 manifest.
+	errSchema1 = errors.New("docker schema 1 manifests are deprecated and not served")
+)
+
+// acceptedTypes returns the manifest media types a request accepts, most
+// preferred first; none means any.
+func acceptedTypes(hdr http.Header) []string {
+	return oci.ParseAccept(hdr.Values("Accept"))
+}
+
+// canonicalHeaders asks upstream for whatever manifest kind a tag points to,
+// index or single manifest, regardless of what the client accepts. A tag's
+// current resolution is always fetched this way, so one client's narrow
+// Accept list does not change what the tag resolves to for the others.
+func canonicalHeaders(hdr http.Header) http.Header {
+	out := hdr.Clone()
+	if out == nil {
+		out = http.Header{}
+	}
+	out.Set("Accept", oci.ManifestAccept)
+	return out
+}
+
+// checkSchema1 refuses schema 1 manifests unless cfg allows them.
+func checkSchema1(cfg *configstore.RepoConfig, desc manifests.Descriptor) error {
+	if !oci.IsSchema1(desc.MediaType) {
+		return nil
+	}
+	if !cfg.AllowSchema1 {
+		return errSchema1
+	}
+	log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "digest": desc.Digest, "event": "schema1"}).
+		Warn("Serving deprecated schema 1 manifest")
+	return nil
+}
+
+// resolveTagVariant resolves a tag for a client that does not accept the
+// media type of its current resolution, e.g. an old client accepting only
+// Docker schema 2 manifests for a tag pointing at an index. Variants are
+// cached per media type and revalidated like tags. While a move of the tag
+// awaits approval, a variant is only served from cache.
+func (h *DockerRemoteHandler) resolveTagVariant(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name, tag string, accept []string, hdr http.Header) (manifests.Descriptor, []byte, error) {
+	logger := log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name, "tag": tag})
+
+	var stale manifests.Descriptor
+	var staleData []byte
+	for _, mediaType := range accept {
+		if strings.Contains(mediaType, "*") {
+			continue
+		}
+		link, found, err := h.manifests.GetTagVariant(cfg.RepoKey, name, tag, mediaType)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to read cached tag variant")
+			continue
+		}
+		if !found {
+			continue
+		}
+		desc, data, err := h.manifests.Get(ctx, link.Digest)
+		if err != nil {
+			continue
+		}
+		if cfg.Offline || time.Since(link.Checked) < cfg.TagTTL {
+			logger.WithField("digest", desc.Digest).Debug("Tag variant served from cache")
+			return desc, data, nil
+		}
+		stale, staleData = desc, data
+		break
+	}
+	if cfg.Offline {
+		return manifests.Descriptor{}, nil, errNotAcceptable
+	}
+	if cfg.PinTags {
+		if _, pending, err := h.manifests.GetPending(cfg.RepoKey, name, tag); err == nil && pending {
+			if staleData != nil {
+				return stale, staleData, nil
+			}
+			return manifests.Descriptor{}, nil, errNotAcceptable
+		}
+	}
+
+	if staleData != nil {
+		current, err := h.headTagDigest(ctx, client, name, tag, hdr)
+		switch {
+		case err == nil && current == stale.Digest:
+			if err := h.manifests.PutTagVariant(cfg.RepoKey, name, tag, stale.MediaType, current); err != nil {
+				logger.WithError(err).Warn("Failed to refresh cached tag variant")
+			}
+			return stale, staleData, nil
+		case upstreamUnavailable(err):
+			logger.WithError(err).Warn("Upstream unavailable, serving stale tag variant")
+			return stale, staleData, nil
+		case h.clients.Constrained(cfg):
+			logger.Warn("Upstream rate limit nearly reached, serving stale tag variant")
+			return stale, staleData, nil
+		}
+	}
+
+	desc, data, err := h.fetchManifest(ctx, client, name, oci.Reference{Tag: tag}, hdr)
+	if err != nil {
+		if staleData != nil && upstreamUnavailable(err) {
+			logger.WithError(err).Warn("Upstream unavailable, serving stale tag variant")
+			return stale, staleData, nil
+		}
+		return manifests.Descriptor{}, nil, err
+	}
+	if !oci.Acceptable(accept, desc.MediaType) {
+		return manifests.Descriptor{}, nil, errNotAcceptable
+	}
+	if err := h.manifests.PutTagVariant(cfg.RepoKey, name, tag, desc.MediaType, desc.Digest); err != nil {
+		logger.WithError(err).Warn("Failed to cache tag variant")
+	}
+	logger.WithFields(log.Fields{"digest": desc.Digest, "mediaType": desc.MediaType}).Debug("Tag variant fetched from upstream")
+	return desc, data, nil
+}
+
+// requestedByDigest reports whether the manifest request in c names a digest
+// rather than a tag.
+func requestedByDigest(c *gin.Context) bool {
+	_, err := digest.Parse(path.Base(c.Request.URL.Path))
+	return err == nil
+}
+
+// writeNotAcceptable answers a manifest request whose Accept list excludes
+// the manifest. A tag is unknown in the accepted formats, as registries
+// report it; content addressed by digest cannot be negotiated and is 406.
+func writeNotAcceptable(c *gin.Context) {
+	if requestedByDigest(c) {
+		writeRegistryError(c, http.StatusNotAcceptable, codeManifestUnknown, errNotAcceptable.Error())
+		return
+	}
+	writeRegistryError(c, http.StatusNotFound, codeManifestUnknown, errNotAcceptable.Error())
+}
+
+// writeManifestRefused answers a manifest lookup that failed because of the
+// client's Accept list or a refused schema 1 manifest. It reports whether
+// err was such a failure.
+func writeManifestRefused(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, errNotAcceptable):
+		writeNotAcceptable(c)
+	case errors.Is(err, errSchema1):
+		writeRegistryError(c, http.StatusUnsupportedMediaType, codeManifestInvalid, errSchema1.Error())
+	default:
+		return false
+	}
+	return true
+}
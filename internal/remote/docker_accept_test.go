@@ -0,0 +1,111 @@
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestAcceptNegotiation(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	amd64 := reg.addImage(t, "amd64")
+	amd64.Platform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+	data, err := json.Marshal(v1.Index{MediaType: v1.MediaTypeImageIndex, Manifests: []v1.Descriptor{amd64}})
+	assert.NoError(t, err)
+	index := reg.addManifest(v1.MediaTypeImageIndex, data, "latest")
+	// Like Docker Hub, upstream answers clients that cannot take an index
+	// with the linux/amd64 image.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/manifests/latest") && !strings.Contains(r.Header.Get("Accept"), v1.MediaTypeImageIndex) {
+			r.URL.Path = "/v2/org/app/manifests/" + amd64.Digest.String()
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		TagTTL:      time.Hour,
+	})
+	defer h.Close()
+	single := http.Header{"Accept": {v1.MediaTypeImageManifest}}
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/latest", nil, single)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, amd64.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, v1.MediaTypeImageManifest, w.Header().Get("Content-Type"))
+
+	// The narrow request did not change what the tag resolves to.
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/latest", nil, http.Header{"Accept": {oci.ManifestAccept}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, index.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/latest", nil, nil)
+	assert.Equal(t, index.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+
+	// Both are cached per media type.
+	hits := reg.count("/v2/org/app/manifests/latest") + reg.count("/v2/org/app/manifests/"+amd64.Digest.String())
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/latest", nil, single)
+	assert.Equal(t, amd64.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+	w = serve(r, http.MethodHead, "/v2/hub/org/app/manifests/latest", nil, single)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, amd64.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, hits, reg.count("/v2/org/app/manifests/latest")+reg.count("/v2/org/app/manifests/"+amd64.Digest.String()))
+
+	// Nothing upstream fits: unknown for a tag, not acceptable for a digest.
+	list := http.Header{"Accept": {oci.MediaTypeDockerManifestList}}
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/latest", nil, list)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "MANIFEST_UNKNOWN", registryErrorCode(t, w))
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/"+index.Digest.String(), nil, single)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/"+index.Digest.String(), nil, http.Header{"Accept": {"*/*"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSchema1Manifests(t *testing.T) {
+	reg := newTestRegistry("org/legacy")
+	reg.addManifest(oci.MediaTypeDockerSchema1Signed, []byte(`{"schemaVersion":1,"name":"org/legacy","tag":"old","signatures":[]}`), "old")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	defer h.Close()
+	h.store.Add(configstore.RepoConfig{
+		RepoKey:      "legacy",
+		PackageType:  configstore.PackageTypeDocker,
+		RemoteURL:    upstream.URL,
+		AllowSchema1: true,
+	})
+	h.store.Add(configstore.RepoConfig{
+		RepoKey:     "internal",
+		PackageType: configstore.PackageTypeDocker,
+		Kind:        configstore.RepoKindHosted,
+	})
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/legacy/manifests/old", nil, nil)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, "MANIFEST_INVALID", registryErrorCode(t, w))
+
+	w = serve(r, http.MethodGet, "/v2/legacy/org/legacy/manifests/old", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, oci.MediaTypeDockerSchema1Signed, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Warning"), "deprecated")
+
+	w = serve(r, http.MethodPut, "/v2/internal/org/legacy/manifests/old",
+		[]byte(`{"schemaVersion":1,"name":"org/legacy","tag":"old"}`),
+		http.Header{"Content-Type": {oci.MediaTypeDockerSchema1}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "MANIFEST_INVALID", registryErrorCode(t, w))
+}
@@ -0,0 +1,165 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	log "github.com/sirupsen/logrus"
+)
+
+// GetCatalog answers GET /v2/_catalog with every repository that has cached or
+// pushed manifests, across all docker repoKeys. Names carry their repoKey
+// prefix so they can be pulled through gobinrepo as listed.
+func (h *DockerRemoteHandler) GetCatalog(c *gin.Context) {
+	var repoKeys []string
+	for _, cfg := range h.store.List() {
+		if cfg.PackageType == configstore.PackageTypeDocker {
+			repoKeys = append(repoKeys, cfg.RepoKey)
+		}
+	}
+	sort.Strings(repoKeys)
+	h.writeCatalog(c, repoKeys)
+}
+
+// writeCatalog lists the repositories of repoKeys as a paginated catalog.
+func (h *DockerRemoteHandler) writeCatalog(c *gin.Context, repoKeys []string) {
+	repos := []string{}
+	seen := map[string]bool{}
+	for _, repoKey := range repoKeys {
+		// A virtual repository holds nothing itself; it lists what its
+		// members hold, under its own key.
+		sources := []string{repoKey}
+		if cfg, ok := h.store.Get(repoKey); ok && cfg.IsVirtual() {
+			sources = nil
+			for _, m := range h.members(&cfg) {
+				sources = append(sources, m.RepoKey)
+			}
+		}
+		for _, source := range sources {
+			names, err := h.manifests.Repositories(source)
+			if err != nil {
+				writeInternalError(c, "failed to list repositories", err)
+				return
+			}
+			for _, name := range names {
+				if repo := repoKey + "/" + name; !seen[repo] {
+					seen[repo] = true
+					repos = append(repos, repo)
+				}
+			}
+		}
+	}
+	sort.Strings(repos)
+	page, ok := paginate(c, repos)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"repositories": page})
+}
+
+// paginate applies the n and last query parameters of the distribution spec to
+// sorted entries and sets the Link header when more entries follow. It writes
+// a 400 and returns false when n is not a number.
+func paginate(c *gin.Context, entries []string) ([]string, bool) {
+	if last := c.Query("last"); last != "" {
+		entries = entries[sort.Search(len(entries), func(i int) bool { return entries[i] > last }):]
+	}
+	raw := c.Query("n")
+	if raw == "" {
+		return entries, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		writeRegistryError(c, http.StatusBadRequest, codePaginationNumberInvalid, "invalid pagination parameter n")
+		return nil, false
+	}
+	if n >= len(entries) {
+		return entries, true
+	}
+	page := entries[:n]
+	if n > 0 {
+		q := url.Values{"n": {raw}, "last": {page[n-1]}}
+		c.Header("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, c.Request.URL.Path, q.Encode()))
+	}
+	return page, true
+}
+
+// writeTagList answers tags/list from a sorted list of tags.
+func writeTagList(c *gin.Context, name string, tags []string) {
+	page, ok := paginate(c, tags)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "tags": page})
+}
+
+// listTags returns the tags of a remote repository for a cached or merged
+// tag list; an offline remote lists its cached tags. A nil result means the
+// repository is unknown.
+func (h *DockerRemoteHandler) listTags(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name string, mode configstore.TagListMode, hdr http.Header) ([]string, error) {
+	cached, err := h.manifests.Tags(cfg.RepoKey, name)
+	if err != nil {
+		return nil, err
+	}
+	if mode == configstore.TagListCached || cfg.Offline {
+		return cached, nil
+	}
+
+	upstream, err := fetchTags(ctx, client, name, hdr)
+	if err != nil {
+		if upstreamUnavailable(err) {
+			log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name}).WithError(err).
+				Warn("Upstream unavailable, listing cached tags")
+			return cached, nil
+		}
+		var ue *upstreamError
+		if errors.As(err, &ue) && ue.StatusCode == http.StatusNotFound {
+			return cached, nil
+		}
+		return nil, err
+	}
+	seen := make(map[string]bool, len(upstream)+len(cached))
+	tags := make([]string, 0, len(upstream)+len(cached))
+	for _, list := range [][]string{upstream, cached} {
+		for _, tag := range list {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// fetchTags reads the full tag list of a repository from upstream.
+func fetchTags(ctx context.Context, client *oci.RegistryClient, name string, hdr http.Header) ([]string, error) {
+	resp, err := client.GetTagList(ctx, name, hdr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Warnf("failed to close response body: %v", cerr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newUpstreamError(resp, fmt.Errorf("tag list failed: %s", resp.Status))
+	}
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid tag list: %w", err)
+	}
+	return list.Tags, nil
+}
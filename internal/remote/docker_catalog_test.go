@@ -0,0 +1,82 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	upstream := httptest.NewServer(&fakeRegistry{})
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	h.store.Add(configstore.RepoConfig{RepoKey: "internal", PackageType: configstore.PackageTypeDocker, Kind: configstore.RepoKindHosted})
+	h.store.Add(configstore.RepoConfig{RepoKey: "charts", PackageType: configstore.PackageTypeHelm})
+	assert.NoError(t, h.manifests.PutTag("internal", "team/app", "v1", digest.FromString(testManifest)))
+
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/v2/hub/org/app/manifests/latest", nil, nil).Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/v2/hub/org/lib/manifests/"+digest.FromString(testManifest).String(), nil, nil).Code)
+
+	w := serve(r, http.MethodGet, "/v2/_catalog", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"repositories":["hub/org/app","hub/org/lib","internal/team/app"]}`, w.Body.String())
+
+	w = serve(r, http.MethodGet, "/v2/_catalog?n=2", nil, nil)
+	assert.JSONEq(t, `{"repositories":["hub/org/app","hub/org/lib"]}`, w.Body.String())
+	assert.Equal(t, `</v2/_catalog?last=hub%2Forg%2Flib&n=2>; rel="next"`, w.Header().Get("Link"))
+
+	w = serve(r, http.MethodGet, "/v2/_catalog?n=2&last=hub/org/lib", nil, nil)
+	assert.JSONEq(t, `{"repositories":["internal/team/app"]}`, w.Body.String())
+	assert.Empty(t, w.Header().Get("Link"))
+
+	w = serve(r, http.MethodGet, "/v2/hub/_catalog", nil, nil)
+	assert.JSONEq(t, `{"repositories":["hub/org/app","hub/org/lib"]}`, w.Body.String())
+	w = serve(r, http.MethodGet, "/v2/internal/_catalog", nil, nil)
+	assert.JSONEq(t, `{"repositories":["internal/team/app"]}`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, serve(r, http.MethodGet, "/v2/_catalog?n=x", nil, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/v2/nope/_catalog", nil, nil).Code)
+}
+
+func TestTagList_CachedAndMerge(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/org/app/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"org/app","tags":["v2","latest"]}`))
+	})
+	upstream := httptest.NewServer(mux)
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		TagList:     configstore.TagListMerge,
+	})
+	assert.NoError(t, h.manifests.PutTag("hub", "org/app", "v1", digest.FromString(testManifest)))
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/tags/list", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"hub/org/app","tags":["latest","v1","v2"]}`, w.Body.String())
+
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/tags/list?source=cached", nil, nil)
+	assert.JSONEq(t, `{"name":"hub/org/app","tags":["v1"]}`, w.Body.String())
+
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/tags/list?n=1&last=latest", nil, nil)
+	assert.JSONEq(t, `{"name":"hub/org/app","tags":["v1"]}`, w.Body.String())
+	assert.Contains(t, w.Header().Get("Link"), "last=v1")
+
+	// Unknown to both the cache and upstream.
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/v2/hub/org/other/tags/list", nil, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(r, http.MethodGet, "/v2/hub/org/app/tags/list?source=x", nil, nil).Code)
+
+	upstream.Close()
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/tags/list", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"hub/org/app","tags":["v1"]}`, w.Body.String())
+}
@@ -0,0 +1,180 @@
+package remote
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	"github.com/martencassel/gobinrepo/internal/util/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+func newDefaultTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConns = 500
+	tr.MaxIdleConnsPerHost = 500
+	tr.IdleConnTimeout = 90 * time.Second
+	tr.TLSHandshakeTimeout = 10 * time.Second
+	tr.ExpectContinueTimeout = 1 * time.Second
+	tr.ResponseHeaderTimeout = 15 * time.Second
+	tr.DisableCompression = true // blobs are already compressed
+	return tr
+}
+
+// clientSettings are the parts of a remote's config a registry client is built
+// from; a change to any of them replaces the client.
+type clientSettings struct {
+	remoteURL      string
+	credentials    []oci.Credential
+	offline        bool
+	cacheRedirects bool
+}
+
+// settingsFor returns the client settings of cfg. A credential from the
+// remote's docker config or credential helper goes first; offline remotes do
+// not look it up, as helpers may call out to the registry's cloud.
+func (p *clientPool) settingsFor(cfg *configstore.RepoConfig) clientSettings {
+	s := clientSettingsFor(cfg)
+	if cfg.Offline {
+		return s
+	}
+	if cred, ok := p.externalCredential(cfg); ok {
+		s.credentials = append([]oci.Credential{cred}, s.credentials...)
+	}
+	return s
+}
+
+func clientSettingsFor(cfg *configstore.RepoConfig) clientSettings {
+	var creds []oci.Credential
+	if cfg.Username != "" {
+		creds = append(creds, oci.Credential{Username: cfg.Username, Password: cfg.Password})
+	}
+	for _, c := range cfg.Credentials {
+		creds = append(creds, oci.Credential{Username: c.Username, Password: c.Password})
+	}
+	return clientSettings{
+		remoteURL:      cfg.RemoteURL,
+		credentials:    creds,
+		offline:        cfg.Offline,
+		cacheRedirects: cfg.CacheRedirects,
+	}
+}
+
+func (s clientSettings) equal(o clientSettings) bool {
+	return s.remoteURL == o.remoteURL && s.offline == o.offline && s.cacheRedirects == o.cacheRedirects &&
+		slices.Equal(s.credentials, o.credentials)
+}
+
+type pooledClient struct {
+	settings clientSettings
+	client   *oci.RegistryClient
+	tokens   *oci.TokenRoundTripper
+	creds    *oci.CredentialPool
+}
+
+// clientPool keeps one registry client per remote so bearer tokens obtained
+// for one request are reused by the next. All clients share one transport and
+// therefore one connection pool.
+type clientPool struct {
+	traceEnable bool
+	base        *http.Transport
+
+	mu       sync.Mutex
+	clients  map[string]*pooledClient
+	external map[string]*externalCredential
+}
+
+func newClientPool(traceEnable bool) *clientPool {
+	return &clientPool{
+		traceEnable: traceEnable,
+		base:        newDefaultTransport(),
+		clients:     make(map[string]*pooledClient),
+		external:    make(map[string]*externalCredential),
+	}
+}
+
+// Get returns the client for the remote described by cfg, building it on first
+// use or when the remote's URL or credentials have changed, including when a
+// credential from a docker config or credential helper is refreshed.
+func (p *clientPool) Get(cfg *configstore.RepoConfig) *oci.RegistryClient {
+	return p.get(cfg).client
+}
+
+// Credentials returns the credentials of the remote described by cfg, with
+// the rate limits upstream last reported for them.
+func (p *clientPool) Credentials(cfg *configstore.RepoConfig) *oci.CredentialPool {
+	return p.get(cfg).creds
+}
+
+// Constrained reports whether the remote described by cfg is throttled
+// upstream or down to its RateLimitReserve.
+func (p *clientPool) Constrained(cfg *configstore.RepoConfig) bool {
+	return p.Credentials(cfg).Constrained(cfg.RateLimitReserve)
+}
+
+func (p *clientPool) get(cfg *configstore.RepoConfig) *pooledClient {
+	settings := p.settingsFor(cfg)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pc, ok := p.clients[cfg.RepoKey]; ok {
+		if pc.settings.equal(settings) {
+			return pc
+		}
+		log.WithField("repoKey", cfg.RepoKey).Info("Remote config changed, rebuilding registry client")
+		pc.tokens.Close()
+	}
+	pc := p.build(settings)
+	p.clients[cfg.RepoKey] = pc
+	return pc
+}
+
+func (p *clientPool) build(s clientSettings) *pooledClient {
+	var base http.RoundTripper = p.base
+	if s.offline {
+		base = offlineTransport{}
+	}
+	// The token round tripper picks the credential of each request, and
+	// the basic auth round tripper below it sends that one.
+	creds := oci.NewCredentialPool(s.credentials...)
+	rt := base
+	if len(s.credentials) > 0 {
+		rt = &oci.BasicAuthRoundTripper{Base: rt}
+	}
+	tokens := oci.NewTokenRoundTripper(p.traceEnable,
+		oci.WithHTTPClient(&http.Client{Timeout: 30 * time.Second, Transport: base}),
+		oci.WithTransport(rt),
+		oci.WithCredentials(creds),
+	)
+	rt = tokens
+	// Redirects to storage hosts bypass the token and basic auth round
+	// trippers, so credentials stay with the registry.
+	redirects := base
+	if p.traceEnable {
+		rt = &trace.TracingRoundTripper{Base: rt}
+		redirects = &trace.TracingRoundTripper{Base: redirects}
+	}
+	opts := []oci.RegistryClientOption{oci.WithRedirectTransport(redirects)}
+	if s.cacheRedirects {
+		opts = append(opts, oci.WithRedirectCache())
+	}
+	return &pooledClient{
+		settings: s,
+		client:   oci.NewRegistryClient(s.remoteURL, rt, opts...),
+		tokens:   tokens,
+		creds:    creds,
+	}
+}
+
+// Close stops every client's token cache and drops idle connections.
+func (p *clientPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, pc := range p.clients {
+		pc.tokens.Close()
+		delete(p.clients, key)
+	}
+	p.base.CloseIdleConnections()
+}
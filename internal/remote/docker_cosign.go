@@ -0,0 +1,301 @@
+package remote
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// cosignSignatureAnnotation carries the base64 signature of a layer of a
+	// cosign signature manifest; the layer itself is the signed payload.
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// cosignArtifactType marks signatures stored as OCI referrers.
+	cosignArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	cosignPayloadType  = "cosign container image signature"
+	// maxSignaturePayload bounds a signed payload read from the cache.
+	maxSignaturePayload = 1 << 20
+)
+
+// signatureError explains why a manifest failed its remote's signature
+// policy.
+type signatureError struct {
+	Digest digest.Digest
+	Reason string
+}
+
+func (e *signatureError) Error() string {
+	return fmt.Sprintf("manifest %s: %s", e.Digest, e.Reason)
+}
+
+// writeDenied answers a manifest refused by a signature policy with a DENIED
+// error carrying the reason. It reports whether err was such a refusal.
+func writeDenied(c *gin.Context, err error) bool {
+	var se *signatureError
+	if !errors.As(err, &se) {
+		return false
+	}
+	log.WithError(err).Warn("Manifest refused by signature policy")
+	writeRegistryError(c, http.StatusForbidden, "DENIED", se.Error())
+	return true
+}
+
+// cosignTag is the tag cosign stores the signatures of d under.
+func cosignTag(d digest.Digest) string {
+	return referrersTag(d) + ".sig"
+}
+
+// CheckCosignKeys loads the cosign keys of every remote, so that a missing
+// or malformed key file is reported at startup rather than on first pull.
+func (h *DockerRemoteHandler) CheckCosignKeys() error {
+	for _, cfg := range h.store.List() {
+		if _, err := h.cosignKeys(&cfg); err != nil {
+			return fmt.Errorf("%s: %w", cfg.RepoKey, err)
+		}
+	}
+	return nil
+}
+
+func (h *DockerRemoteHandler) cosignKeys(cfg *configstore.RepoConfig) ([]crypto.PublicKey, error) {
+	keys := make([]crypto.PublicKey, 0, len(cfg.CosignKeys))
+	for _, path := range cfg.CosignKeys {
+		if key, ok := h.keys.Load(path); ok {
+			keys = append(keys, key)
+			continue
+		}
+		key, err := loadCosignKey(path)
+		if err != nil {
+			return nil, err
+		}
+		h.keys.Store(path, key)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// loadCosignKey reads a PEM public key as written by cosign generate-key-pair.
+func loadCosignKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read cosign key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM public key", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported key type %T", path, key)
+}
+
+// verifyManifest enforces the signature policy of cfg on manifest desc. A
+// manifest passes when a cosign signature of its digest, found through the
+// .sig tag or the Referrers API, verifies offline against one of the keys.
+// The images of a verified index pass with it, as cosign signs the index.
+func (h *DockerRemoteHandler) verifyManifest(ctx context.Context, cfg *configstore.RepoConfig, name string, desc manifests.Descriptor, data []byte) error {
+	if len(cfg.CosignKeys) == 0 {
+		return nil
+	}
+	verifiedKey := cfg.RepoKey + "@" + desc.Digest.String()
+	if _, ok := h.verified.Load(verifiedKey); !ok {
+		keys, err := h.cosignKeys(cfg)
+		if err != nil {
+			return err
+		}
+		client := h.clients.Get(cfg)
+		sigs, err := h.cosignSignatures(ctx, cfg, client, name, desc.Digest)
+		if err != nil {
+			return fmt.Errorf("signature lookup for %s: %w", desc.Digest, err)
+		}
+		if err := h.verifySignatures(ctx, cfg, client, name, desc.Digest, sigs, keys); err != nil {
+			return err
+		}
+		h.verified.Store(verifiedKey, struct{}{})
+		log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name, "digest": desc.Digest}).Info("Manifest signature verified")
+	}
+
+	// data is nil when only the descriptor is known, e.g. for HEAD.
+	if data != nil && isIndex(desc.MediaType) {
+		var index v1.Index
+		if err := json.Unmarshal(data, &index); err == nil {
+			for _, m := range index.Manifests {
+				h.verified.Store(cfg.RepoKey+"@"+m.Digest.String(), struct{}{})
+			}
+		}
+	}
+	return nil
+}
+
+// cosignSignatures returns the signature manifests of d, from the .sig tag
+// and from referrers of the cosign artifact type. Both are cached like any
+// other manifest, so verification keeps working offline.
+func (h *DockerRemoteHandler) cosignSignatures(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name string, d digest.Digest) ([][]byte, error) {
+	hdr := http.Header{"Accept": {oci.ManifestAccept}}
+	var sigs [][]byte
+	_, data, err := h.resolveTag(ctx, cfg, client, name, cosignTag(d), hdr)
+	switch {
+	case err == nil:
+		sigs = append(sigs, data)
+	case !memberMiss(err):
+		return nil, err
+	}
+
+	index, err := h.remoteReferrers(ctx, cfg, name, d)
+	switch {
+	case err == nil:
+	case memberMiss(err):
+		return sigs, nil
+	default:
+		return nil, err
+	}
+	for _, m := range index.Manifests {
+		if m.ArtifactType != cosignArtifactType {
+			continue
+		}
+		_, data, err := h.manifests.Get(ctx, m.Digest)
+		if errors.Is(err, manifests.ErrNotFound) {
+			_, data, err = h.fetchManifest(ctx, client, name, oci.Reference{Digest: m.Digest.String()}, hdr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, data)
+	}
+	return sigs, nil
+}
+
+// verifySignatures checks the layers of the signature manifests sigs: each
+// is a simple signing payload naming d, signed by the layer's annotation.
+func (h *DockerRemoteHandler) verifySignatures(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name string, d digest.Digest, sigs [][]byte, keys []crypto.PublicKey) error {
+	var reasons []string
+	addReason := func(reason string) {
+		for _, r := range reasons {
+			if r == reason {
+				return
+			}
+		}
+		reasons = append(reasons, reason)
+	}
+	for _, data := range sigs {
+		var m v1.Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			addReason("invalid signature manifest")
+			continue
+		}
+		for _, layer := range m.Layers {
+			sig := layer.Annotations[cosignSignatureAnnotation]
+			if sig == "" {
+				continue
+			}
+			payload, err := h.signaturePayload(ctx, cfg, client, name, layer.Digest)
+			if err != nil {
+				return fmt.Errorf("signature payload %s: %w", layer.Digest, err)
+			}
+			if err := checkCosignPayload(payload, d); err != nil {
+				addReason(err.Error())
+				continue
+			}
+			if verifyCosignSignature(keys, payload, sig) {
+				return nil
+			}
+			addReason("signature does not verify against any configured key")
+		}
+	}
+	if len(reasons) == 0 {
+		return &signatureError{Digest: d, Reason: "no cosign signature found"}
+	}
+	return &signatureError{Digest: d, Reason: strings.Join(reasons, "; ")}
+}
+
+func (h *DockerRemoteHandler) signaturePayload(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name string, d digest.Digest) ([]byte, error) {
+	if err := h.cacheBlob(ctx, cfg, client, name, d); err != nil {
+		return nil, err
+	}
+	rc, err := h.blobs.Get(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rc.Close(); cerr != nil {
+			log.Warnf("failed to close signature payload: %v", cerr)
+		}
+	}()
+	return io.ReadAll(io.LimitReader(rc, maxSignaturePayload))
+}
+
+// checkCosignPayload makes sure a signed payload vouches for manifest d; a
+// valid signature over another image proves nothing about this one.
+func checkCosignPayload(payload []byte, d digest.Digest) error {
+	var p struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return errors.New("invalid signature payload")
+	}
+	if p.Critical.Type != cosignPayloadType {
+		return fmt.Errorf("unexpected signature payload type %q", p.Critical.Type)
+	}
+	if p.Critical.Image.DockerManifestDigest != d.String() {
+		return fmt.Errorf("signature is for %s", p.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+// verifyCosignSignature reports whether the base64 signature sig over payload
+// verifies against one of keys, using the schemes cosign signs with.
+func verifyCosignSignature(keys []crypto.PublicKey, payload []byte, sig string) bool {
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(payload)
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, hash[:], raw) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], raw) == nil {
+				return true
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, payload, raw) {
+				return true
+			}
+		}
+	}
+	return false
+}
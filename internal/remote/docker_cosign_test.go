@@ -0,0 +1,116 @@
+package remote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCosignKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644))
+	return path
+}
+
+// signImage stores a cosign signature of img under its .sig tag.
+func signImage(t *testing.T, reg *testRegistry, key *ecdsa.PrivateKey, img v1.Descriptor) {
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"org/app"},"image":{"docker-manifest-digest":"` +
+		img.Digest.String() + `"},"type":"cosign container image signature"},"optional":null}`)
+	hash := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	assert.NoError(t, err)
+	layer := reg.addBlob(payload)
+	layer.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	layer.Annotations = map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+	config := reg.addBlob([]byte(`{}`))
+	config.MediaType = v1.MediaTypeImageConfig
+	data, err := json.Marshal(v1.Manifest{MediaType: v1.MediaTypeImageManifest, Config: config, Layers: []v1.Descriptor{layer}})
+	assert.NoError(t, err)
+	reg.addManifest(v1.MediaTypeImageManifest, data, cosignTag(img.Digest))
+}
+
+func TestCosignPolicy(t *testing.T) {
+	trusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	reg := newTestRegistry("org/app")
+	signed := reg.addImage(t, "signed", "signed")
+	signImage(t, reg, trusted, signed)
+	reg.addImage(t, "unsigned", "unsigned")
+	forged := reg.addImage(t, "forged", "forged")
+	signImage(t, reg, other, forged)
+
+	child := reg.addImage(t, "child")
+	child.Platform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+	index, err := json.Marshal(v1.Index{MediaType: v1.MediaTypeImageIndex, Manifests: []v1.Descriptor{child}})
+	assert.NoError(t, err)
+	multi := reg.addManifest(v1.MediaTypeImageIndex, index, "multi")
+	signImage(t, reg, trusted, multi)
+
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		CosignKeys:  []string{writeCosignKey(t, trusted)},
+	})
+	defer h.Close()
+	assert.NoError(t, h.CheckCosignKeys())
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/signed", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(r, http.MethodHead, "/v2/hub/org/app/manifests/signed", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/unsigned", nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "DENIED", registryErrorCode(t, w))
+	assert.Contains(t, w.Body.String(), "no cosign signature found")
+	w = serve(r, http.MethodHead, "/v2/hub/org/app/manifests/unsigned", nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/forged", nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "does not verify")
+	// Digest pulls of a cached manifest are checked too.
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/"+forged.Digest.String(), nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// The images of a signed index pass with it.
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/multi", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/"+child.Digest.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCheckCosignKeys(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.pub")
+	assert.NoError(t, os.WriteFile(bad, []byte("not a key"), 0o644))
+	_, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   "http://127.0.0.1:1",
+		CosignKeys:  []string{bad},
+	})
+	defer h.Close()
+	assert.ErrorContains(t, h.CheckCosignKeys(), "no PEM public key")
+}
@@ -0,0 +1,126 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultCredentialTTL is how long a credential from a docker config or
+	// credential helper is used when the remote sets no CredentialTTL.
+	defaultCredentialTTL = 10 * time.Minute
+	// credentialRetry is how long a failed lookup is remembered before the
+	// docker config or helper is asked again.
+	credentialRetry = 30 * time.Second
+	// credentialHelperTimeout bounds one run of a credential helper.
+	credentialHelperTimeout = 30 * time.Second
+)
+
+// credentialSource is where a remote's external credential comes from; a
+// change to it discards the cached credential.
+type credentialSource struct {
+	remoteURL string
+	config    string
+	helper    string
+}
+
+func credentialSourceFor(cfg *configstore.RepoConfig) credentialSource {
+	return credentialSource{remoteURL: cfg.RemoteURL, config: cfg.DockerConfig, helper: cfg.CredentialHelper}
+}
+
+// CheckDockerConfigs reads the docker config of every remote, so that a
+// malformed file stops startup instead of failing pulls. A missing file is
+// only logged: the remote pulls anonymously until it appears, as on a host
+// without a docker login.
+func (h *DockerRemoteHandler) CheckDockerConfigs() error {
+	for _, cfg := range h.store.List() {
+		if cfg.DockerConfig == "" {
+			continue
+		}
+		_, err := oci.LoadDockerConfig(cfg.DockerConfig)
+		if errors.Is(err, os.ErrNotExist) {
+			log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "dockerConfig": cfg.DockerConfig}).
+				Warn("Docker config not found, pulling anonymously")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", cfg.RepoKey, err)
+		}
+	}
+	return nil
+}
+
+// externalCredential is the credential last read for a remote from its docker
+// config or credential helper.
+type externalCredential struct {
+	mu      sync.Mutex
+	source  credentialSource
+	cred    oci.Credential
+	found   bool
+	expires time.Time
+}
+
+// externalCredential returns the credential cfg takes from a docker config
+// or credential helper, reading it again once it has expired. When a read
+// fails the previous credential, if any, stays in use until the next try.
+func (p *clientPool) externalCredential(cfg *configstore.RepoConfig) (oci.Credential, bool) {
+	if cfg.DockerConfig == "" && cfg.CredentialHelper == "" {
+		return oci.Credential{}, false
+	}
+	source := credentialSourceFor(cfg)
+	p.mu.Lock()
+	ec, ok := p.external[cfg.RepoKey]
+	if !ok || ec.source != source {
+		ec = &externalCredential{source: source}
+		p.external[cfg.RepoKey] = ec
+	}
+	p.mu.Unlock()
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if time.Now().Before(ec.expires) {
+		return ec.cred, ec.found
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	cred, found, err := resolveCredential(ctx, cfg)
+	logger := log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "dockerConfig": cfg.DockerConfig, "credentialHelper": cfg.CredentialHelper})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read upstream credential")
+		ec.expires = time.Now().Add(credentialRetry)
+		return ec.cred, ec.found
+	}
+	if !found {
+		logger.Warn("No upstream credential found, pulling without it")
+	}
+	ttl := cfg.CredentialTTL
+	if ttl <= 0 {
+		ttl = defaultCredentialTTL
+	}
+	ec.cred, ec.found, ec.expires = cred, found, time.Now().Add(ttl)
+	return ec.cred, ec.found
+}
+
+// resolveCredential asks the credential helper of cfg, or else looks the
+// remote up in its docker config. A missing docker config holds nothing.
+func resolveCredential(ctx context.Context, cfg *configstore.RepoConfig) (oci.Credential, bool, error) {
+	if cfg.CredentialHelper != "" {
+		return oci.HelperCredential(ctx, cfg.CredentialHelper, oci.CredentialServerURL(cfg.RemoteURL))
+	}
+	dc, err := oci.LoadDockerConfig(cfg.DockerConfig)
+	if errors.Is(err, os.ErrNotExist) {
+		return oci.Credential{}, false, nil
+	}
+	if err != nil {
+		return oci.Credential{}, false, err
+	}
+	return dc.Credential(ctx, cfg.RemoteURL)
+}
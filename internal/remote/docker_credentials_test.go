@@ -0,0 +1,93 @@
+package remote
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeDockerConfig(t *testing.T, path, host, username, password string) {
+	t.Helper()
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	data := `{"auths":{"` + host + `":{"auth":"` + auth + `"}}}`
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	reg.addImage(t, "v1", "1.0")
+	var password atomic.Value
+	password.Store("first")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != password.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeDockerConfig(t, path, upstream.Listener.Addr().String(), "alice", "first")
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:       "private",
+		PackageType:   configstore.PackageTypeDocker,
+		RemoteURL:     upstream.URL,
+		DockerConfig:  path,
+		CredentialTTL: time.Millisecond,
+	})
+	defer h.Close()
+	assert.NoError(t, h.CheckDockerConfigs())
+
+	w := serve(r, http.MethodGet, "/v2/private/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The password is rotated; once the credential expires the new one is
+	// read from the config.
+	password.Store("second")
+	writeDockerConfig(t, path, upstream.Listener.Addr().String(), "alice", "second")
+	time.Sleep(5 * time.Millisecond)
+	w = serve(r, http.MethodGet, "/v2/private/org/app/tags/list", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A config that cannot be read keeps the last credential in use.
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	time.Sleep(5 * time.Millisecond)
+	w = serve(r, http.MethodGet, "/v2/private/org/app/tags/list", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Error(t, h.CheckDockerConfigs())
+}
+
+func TestMissingDockerConfigPullsAnonymously(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	reg.addImage(t, "v1", "1.0")
+	var authorized atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			authorized.Store(true)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:      "public",
+		PackageType:  configstore.PackageTypeDocker,
+		RemoteURL:    upstream.URL,
+		DockerConfig: filepath.Join(t.TempDir(), "missing", "config.json"),
+	})
+	defer h.Close()
+	assert.NoError(t, h.CheckDockerConfigs())
+
+	w := serve(r, http.MethodGet, "/v2/public/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, authorized.Load())
+}
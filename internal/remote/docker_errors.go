@@ -0,0 +1,149 @@
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	log "github.com/sirupsen/logrus"
+)
+
+// Error codes of the distribution spec, plus UNKNOWN and UNAVAILABLE from the
+// reference registry for failures the spec does not name.
+const (
+	codeBlobUnknown         = "BLOB_UNKNOWN"
+	codeBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	codeBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	codeDigestInvalid       = "DIGEST_INVALID"
+	codeManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	codeManifestInvalid     = "MANIFEST_INVALID"
+	codeManifestUnknown     = "MANIFEST_UNKNOWN"
+	codeNameInvalid         = "NAME_INVALID"
+	codeNameUnknown         = "NAME_UNKNOWN"
+	// codePaginationNumberInvalid is the reference registry's code for a bad n.
+	codePaginationNumberInvalid = "PAGINATION_NUMBER_INVALID"
+	codeSizeInvalid             = "SIZE_INVALID"
+	codeTagInvalid              = "TAG_INVALID"
+	codeUnauthorized            = "UNAUTHORIZED"
+	codeDenied                  = "DENIED"
+	codeUnsupported             = "UNSUPPORTED"
+	codeTooManyRequests         = "TOOMANYREQUESTS"
+	codeUnknown                 = "UNKNOWN"
+	codeUnavailable             = "UNAVAILABLE"
+)
+
+// maxUpstreamErrorBody bounds how much of an upstream error body is read.
+const maxUpstreamErrorBody = 64 << 10
+
+// maxUpstreamMessage bounds an upstream error message passed on as detail.
+const maxUpstreamMessage = 512
+
+// registryErrorEntry is one entry of an error body of the distribution spec.
+type registryErrorEntry struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Detail  any    `json:"detail,omitempty"`
+}
+
+// upstreamDetail is the detail of an error upstream answered: its status and
+// the codes and messages of its error body. Upstream detail fields are
+// dropped, as they may carry anything.
+type upstreamDetail struct {
+	Status int                  `json:"upstreamStatus"`
+	Errors []registryErrorEntry `json:"upstreamErrors,omitempty"`
+}
+
+// newUpstreamError describes a non-success upstream response. The error body
+// is read if it is still open; the caller closes it.
+func newUpstreamError(resp *http.Response, err error) *upstreamError {
+	ue := &upstreamError{StatusCode: resp.StatusCode, Err: err, RetryAfter: oci.RetryAfter(resp)}
+	if resp.Body == nil {
+		return ue
+	}
+	data, rerr := io.ReadAll(io.LimitReader(resp.Body, maxUpstreamErrorBody))
+	if rerr != nil {
+		return ue
+	}
+	var body struct {
+		Errors []registryErrorEntry `json:"errors"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		return ue
+	}
+	for _, e := range body.Errors {
+		if len(e.Message) > maxUpstreamMessage {
+			e.Message = e.Message[:maxUpstreamMessage]
+		}
+		ue.Errors = append(ue.Errors, registryErrorEntry{Code: e.Code, Message: e.Message})
+	}
+	return ue
+}
+
+// writeRegistryError writes an error body in the format of the distribution
+// spec, e.g. code MANIFEST_UNKNOWN.
+func writeRegistryError(c *gin.Context, status int, code, message string) {
+	writeRegistryErrors(c, status, registryErrorEntry{Code: code, Message: message})
+}
+
+func writeRegistryErrors(c *gin.Context, status int, errs ...registryErrorEntry) {
+	if c.Request.Method == http.MethodHead {
+		c.Status(status)
+		return
+	}
+	c.JSON(status, gin.H{"errors": errs})
+}
+
+// writeInternalError logs err and answers 500 UNKNOWN with msg; err itself
+// is not passed on.
+func writeInternalError(c *gin.Context, msg string, err error) {
+	log.WithError(err).Warn(msg)
+	writeRegistryError(c, http.StatusInternalServerError, codeUnknown, msg)
+}
+
+// writeUnknownRepo answers a request for a repoKey without configuration.
+func writeUnknownRepo(c *gin.Context) {
+	writeRegistryError(c, http.StatusNotFound, codeNameUnknown, "unknown repoKey: repository configuration not found")
+}
+
+// writeUpstreamError answers a request that failed upstream. What upstream
+// answered with authority keeps its status: 401, 403 and 429 with their
+// codes and 404 with unknown, e.g. MANIFEST_UNKNOWN, unless upstream named
+// the repository itself unknown. Anything else is 502. The upstream status
+// and error messages go along as detail.
+func writeUpstreamError(c *gin.Context, err error, unknown, msg string) {
+	var ue *upstreamError
+	if !errors.As(err, &ue) {
+		log.WithError(err).Warn(msg)
+		writeRegistryError(c, http.StatusBadGateway, codeUnavailable, msg)
+		return
+	}
+	entry := registryErrorEntry{
+		Message: msg,
+		Detail:  upstreamDetail{Status: ue.StatusCode, Errors: ue.Errors},
+	}
+	status := ue.StatusCode
+	switch ue.StatusCode {
+	case http.StatusUnauthorized:
+		entry.Code, entry.Message = codeUnauthorized, "authentication required by upstream"
+	case http.StatusForbidden:
+		entry.Code, entry.Message = codeDenied, "access denied by upstream"
+	case http.StatusNotFound:
+		entry.Code, entry.Message = unknown, "not found upstream"
+		if len(ue.Errors) > 0 && ue.Errors[0].Code == codeNameUnknown {
+			entry.Code = codeNameUnknown
+		}
+	case http.StatusTooManyRequests:
+		entry.Code, entry.Message = codeTooManyRequests, "upstream rate limit reached"
+		if ue.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(ue.RetryAfter.Seconds())))
+		}
+	default:
+		status, entry.Code = http.StatusBadGateway, codeUnavailable
+	}
+	log.WithError(err).WithField("upstreamStatus", ue.StatusCode).Warn(msg)
+	writeRegistryErrors(c, status, entry)
+}
@@ -0,0 +1,86 @@
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamErrorMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"missing tag", http.StatusNotFound, "", http.StatusNotFound, "MANIFEST_UNKNOWN"},
+		{"missing repository", http.StatusNotFound, `{"errors":[{"code":"NAME_UNKNOWN","message":"repository name not known to registry"}]}`, http.StatusNotFound, "NAME_UNKNOWN"},
+		{"unauthorized", http.StatusUnauthorized, "", http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"forbidden", http.StatusForbidden, "", http.StatusForbidden, "DENIED"},
+		{"rate limited", http.StatusTooManyRequests, "", http.StatusTooManyRequests, "TOOMANYREQUESTS"},
+		{"server error", http.StatusInternalServerError, "", http.StatusBadGateway, "UNAVAILABLE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer upstream.Close()
+			r, h := newTestDockerHandler(t, configstore.RepoConfig{
+				RepoKey:     "hub",
+				PackageType: configstore.PackageTypeDocker,
+				RemoteURL:   upstream.URL,
+			})
+			defer h.Close()
+
+			w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			var body struct {
+				Errors []struct {
+					Code   string `json:"code"`
+					Detail struct {
+						UpstreamStatus int `json:"upstreamStatus"`
+					} `json:"detail"`
+				} `json:"errors"`
+			}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if assert.Len(t, body.Errors, 1) {
+				assert.Equal(t, tt.wantCode, body.Errors[0].Code)
+				assert.Equal(t, tt.status, body.Errors[0].Detail.UpstreamStatus)
+			}
+
+			w = serve(r, http.MethodHead, "/v2/hub/org/app/manifests/1.0", nil, nil)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Empty(t, w.Body.String())
+		})
+	}
+}
+
+func TestRegistryErrorBodies(t *testing.T) {
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   "http://127.0.0.1:1",
+	})
+	defer h.Close()
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/bad:tag", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "TAG_INVALID", registryErrorCode(t, w))
+	w = serve(r, http.MethodPut, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "UNSUPPORTED", registryErrorCode(t, w))
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/blobs/sha256:nothex", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "DIGEST_INVALID", registryErrorCode(t, w))
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "UNAVAILABLE", registryErrorCode(t, w))
+}
@@ -0,0 +1,268 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// headTimeout bounds how long an existence check waits on upstream.
+const headTimeout = 10 * time.Second
+
+func (h *DockerRemoteHandler) HeadManifestWithParams(c *gin.Context, repoKey, name, ref string) {
+	c.Set("RepoKey", repoKey)
+	c.Set("SubPath", name+"/manifests/"+ref)
+	h.HeadManifest(c)
+}
+
+func (h *DockerRemoteHandler) HeadBlobWithParams(c *gin.Context, repoKey, name, digest string) {
+	c.Set("RepoKey", repoKey)
+	c.Set("SubPath", name+"/blobs/"+digest)
+	h.HeadBlob(c)
+}
+
+// HeadManifest answers manifest existence checks from the local store when
+// possible and otherwise asks upstream with HEAD, never transferring a body.
+func (h *DockerRemoteHandler) HeadManifest(c *gin.Context) {
+	repoKey, ok := repoKeyFromContext(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	cfg, ok := h.store.Get(repoKey)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	url, err := oci.ParseOCIURL(c.Request.URL.String())
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	ref := url.Reference
+	if ref.IsTag() && !oci.IsValidTag(ref.Tag) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	ctx := c.Request.Context()
+	normalizedName := normalizeName(cfg.RemoteURL, url.Name.Rest())
+	logger := log.WithFields(log.Fields{"repoKey": repoKey, "name": normalizedName, "ref": ref.String()})
+	// writeHead answers with desc once it passes the signature policy. A
+	// tag whose resolution the client does not accept is answered with the
+	// variant it would pull.
+	writeHead := func(desc manifests.Descriptor) {
+		if accept := acceptedTypes(c.Request.Header); ref.IsTag() && !oci.Acceptable(accept, desc.MediaType) {
+			variant, _, err := h.resolveTagVariant(ctx, &cfg, h.clients.Get(&cfg), normalizedName, ref.Tag, accept, upstreamHeaders(c.Request.Header))
+			if err != nil {
+				if !writeManifestRefused(c, err) {
+					writeUpstreamError(c, err, codeManifestUnknown, "manifest HEAD failed upstream")
+				}
+				return
+			}
+			desc = variant
+		}
+		if err := checkSchema1(&cfg, desc); err != nil {
+			writeManifestRefused(c, err)
+			return
+		}
+		if err := h.verifyManifest(ctx, &cfg, normalizedName, desc, nil); err != nil {
+			var se *signatureError
+			if errors.As(err, &se) {
+				logger.WithError(err).Warn("Manifest refused by signature policy")
+				c.Status(http.StatusForbidden)
+				return
+			}
+			logger.WithError(err).Warn("Manifest signature check failed")
+			c.Status(http.StatusBadGateway)
+			return
+		}
+		writeManifest(c, desc, nil)
+	}
+
+	var stale *manifests.Descriptor
+	switch {
+	case ref.IsDigest():
+		desc, err := h.manifests.Stat(ctx, digest.Digest(ref.Digest))
+		if err == nil {
+			writeHead(desc)
+			logger.Debug("Manifest HEAD served from local store")
+			return
+		}
+	default:
+		link, found, err := h.manifests.GetTag(repoKey, normalizedName, ref.Tag)
+		if err == nil && found {
+			if desc, err := h.manifests.Stat(ctx, link.Digest); err == nil {
+				if cfg.Offline || time.Since(link.Checked) < cfg.TagTTL {
+					writeHead(desc)
+					logger.Debug("Manifest HEAD served from cached tag")
+					return
+				}
+				stale = &desc
+			}
+		}
+	}
+
+	// Tags are checked in every media type, as resolveTag does, so the
+	// digest compares against the tag's current resolution.
+	hdr := upstreamHeaders(c.Request.Header)
+	if ref.IsTag() {
+		hdr = canonicalHeaders(hdr)
+	}
+	client := h.clients.Get(&cfg)
+	desc, err := h.headUpstreamManifest(ctx, client, normalizedName, ref, hdr)
+	if err != nil {
+		switch {
+		case stale != nil && upstreamUnavailable(err):
+			logger.WithError(err).Warn("Upstream unavailable, serving stale tag")
+			writeHead(*stale)
+		case errors.Is(err, errOffline):
+			c.Status(http.StatusNotFound)
+		default:
+			writeUpstreamError(c, err, codeManifestUnknown, "manifest HEAD failed upstream")
+		}
+		return
+	}
+	if ref.IsTag() {
+		if pinned := h.trackTag(&cfg, normalizedName, ref.Tag, desc.Digest); pinned != desc.Digest {
+			if stale != nil && stale.Digest == pinned {
+				desc = *stale
+			} else if desc, err = h.manifests.Stat(ctx, pinned); err != nil {
+				desc, err = h.headUpstreamManifest(ctx, client, normalizedName, oci.Reference{Digest: pinned.String()}, hdr)
+				if err != nil {
+					logger.WithError(err).Warn("Pinned manifest HEAD failed upstream")
+					c.Status(http.StatusBadGateway)
+					return
+				}
+			}
+		}
+	}
+	if stale != nil && stale.Digest == desc.Digest {
+		if err := h.manifests.PutTag(repoKey, normalizedName, ref.Tag, desc.Digest); err != nil {
+			logger.WithError(err).Warn("Failed to refresh cached tag")
+		}
+	}
+	writeHead(desc)
+}
+
+// headUpstreamManifest describes an upstream manifest from its HEAD response.
+// Registries that leave Docker-Content-Digest out of HEAD responses are asked
+// with GET instead, and the manifest is hashed and cached as on a pull.
+func (h *DockerRemoteHandler) headUpstreamManifest(ctx context.Context, client *oci.RegistryClient, name string, ref oci.Reference, hdr http.Header) (manifests.Descriptor, error) {
+	headCtx, cancel := context.WithTimeout(ctx, tagRevalidateTimeout)
+	defer cancel()
+	resp, err := client.HeadManifest(headCtx, name, ref.String(), hdr)
+	if err != nil {
+		return manifests.Descriptor{}, err
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Warnf("failed to close response body: %v", cerr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return manifests.Descriptor{}, newUpstreamError(resp, fmt.Errorf("manifest head failed: %s", resp.Status))
+	}
+	d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil && ref.IsDigest() {
+		d, err = digest.Digest(ref.Digest), nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"name": name, "ref": ref.String()}).Debug("Upstream HEAD returned no digest, falling back to GET")
+		desc, _, err := h.fetchManifest(ctx, client, name, ref, hdr)
+		return desc, err
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = mt
+	}
+	return manifests.Descriptor{
+		MediaType: mediaType,
+		Digest:    d,
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// HeadBlob answers blob existence checks from the local store when possible
+// and otherwise asks upstream with HEAD.
+func (h *DockerRemoteHandler) HeadBlob(c *gin.Context) {
+	repoKey, ok := repoKeyFromContext(c)
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	cfg, ok := h.store.Get(repoKey)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	ociURL, d, err := oci.ParseDigestURL(c.Request.URL.String(), "registry-1.docker.io")
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	ctx := c.Request.Context()
+
+	size, err := h.blobs.Stat(ctx, d)
+	if err == nil {
+		writeBlobHeaders(c, d, size)
+		c.Status(http.StatusOK)
+		return
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		log.WithError(err).Warnf("failed to stat blob %s", d)
+	}
+
+	size, err = h.headUpstreamBlob(ctx, &cfg, normalizeName(cfg.RemoteURL, ociURL.Name.Rest()), d, upstreamHeaders(c.Request.Header, "Range", "If-Range"))
+	if err != nil {
+		if errors.Is(err, errOffline) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		writeUpstreamError(c, err, codeBlobUnknown, "blob HEAD failed upstream")
+		return
+	}
+	writeBlobHeaders(c, d, size)
+	c.Status(http.StatusOK)
+}
+
+func (h *DockerRemoteHandler) headUpstreamBlob(ctx context.Context, cfg *configstore.RepoConfig, name string, d digest.Digest, hdr http.Header) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, headTimeout)
+	defer cancel()
+	client := h.clients.Get(cfg)
+	resp, err := client.HeadBlob(ctx, name, d.String(), hdr)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Warnf("failed to close response body: %v", cerr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return 0, newUpstreamError(resp, fmt.Errorf("blob head failed (%s): %s", d, resp.Status))
+	}
+	return resp.ContentLength, nil
+}
+
+// writeBlobHeaders sets the headers describing a blob of known size.
+func writeBlobHeaders(c *gin.Context, d digest.Digest, size int64) {
+	c.Header("Content-Type", "application/octet-stream")
+	if size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+	}
+	c.Header("Docker-Content-Digest", d.String())
+	c.Header("ETag", fmt.Sprintf(`"%s"`, d))
+	c.Header("Accept-Ranges", "bytes")
+}
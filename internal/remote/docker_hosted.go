@@ -0,0 +1,540 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	"github.com/martencassel/gobinrepo/internal/util/uploads"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleHostedV2 serves the distribution API for a hosted repository: pulls
+// come from the local stores, pushes are written into them.
+func (h *DockerRemoteHandler) handleHostedV2(c *gin.Context, cfg *configstore.RepoConfig, rest string) {
+	method := c.Request.Method
+	switch {
+	case strings.Contains(rest, "/blobs/uploads"):
+		parts := strings.SplitN(rest, "/blobs/uploads", 2)
+		name, id := parts[0], strings.Trim(parts[1], "/")
+		if !validHostedName(c, name) {
+			return
+		}
+		switch {
+		case method == http.MethodPost && id == "":
+			h.startUpload(c, cfg, name)
+		case id == "":
+			writeRegistryError(c, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+		default:
+			h.handleUpload(c, cfg, name, id)
+		}
+	case strings.Contains(rest, "/referrers/"):
+		parts := strings.SplitN(rest, "/referrers/", 2)
+		if method != http.MethodGet {
+			writeRegistryError(c, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+			return
+		}
+		if !validHostedName(c, parts[0]) {
+			return
+		}
+		h.GetReferrers(c, cfg, parts[0], parts[1])
+	case strings.Contains(rest, "/manifests/"):
+		parts := strings.SplitN(rest, "/manifests/", 2)
+		if !validHostedName(c, parts[0]) {
+			return
+		}
+		switch method {
+		case http.MethodGet, http.MethodHead:
+			h.getHostedManifest(c, cfg, parts[0], parts[1])
+		case http.MethodPut:
+			h.putHostedManifest(c, cfg, parts[0], parts[1])
+		case http.MethodDelete:
+			h.deleteHostedManifest(c, cfg, parts[0], parts[1])
+		default:
+			writeRegistryError(c, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+		}
+	case strings.Contains(rest, "/blobs/"):
+		parts := strings.SplitN(rest, "/blobs/", 2)
+		if !validHostedName(c, parts[0]) {
+			return
+		}
+		d, err := digest.Parse(parts[1])
+		if err != nil {
+			writeRegistryError(c, http.StatusBadRequest, codeDigestInvalid, "invalid digest")
+			return
+		}
+		switch method {
+		case http.MethodGet, http.MethodHead:
+			h.getHostedBlob(c, cfg, parts[0], d)
+		case http.MethodDelete:
+			h.deleteHostedBlob(c, cfg, parts[0], d)
+		default:
+			writeRegistryError(c, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+		}
+	case strings.HasSuffix(rest, "/tags/list"):
+		name := strings.TrimSuffix(rest, "/tags/list")
+		if method != http.MethodGet {
+			writeRegistryError(c, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+			return
+		}
+		if !validHostedName(c, name) {
+			return
+		}
+		h.hostedTagList(c, cfg, name)
+	default:
+		writeRegistryError(c, http.StatusNotFound, codeUnsupported, "unsupported v2 path")
+	}
+}
+
+func validHostedName(c *gin.Context, name string) bool {
+	if _, err := oci.ParseRepositoryName(name); err != nil || name == "" {
+		writeRegistryError(c, http.StatusBadRequest, codeNameInvalid, "invalid repository name")
+		return false
+	}
+	return true
+}
+
+// getHostedManifest serves a manifest linked into the repository by push.
+func (h *DockerRemoteHandler) getHostedManifest(c *gin.Context, cfg *configstore.RepoConfig, name, ref string) {
+	if _, err := digest.Parse(ref); err != nil && !oci.IsValidTag(ref) {
+		writeRegistryError(c, http.StatusBadRequest, codeTagInvalid, "invalid tag")
+		return
+	}
+	desc, data, err := h.hostedManifest(c.Request.Context(), cfg.RepoKey, name, ref)
+	if errors.Is(err, manifests.ErrNotFound) {
+		if subject, ok := subjectOfReferrersTag(ref); ok {
+			// Clients using the tag fallback scheme get the same index the
+			// Referrers API would return.
+			h.serveReferrersTag(c, cfg.RepoKey, name, subject)
+			return
+		}
+		writeRegistryError(c, http.StatusNotFound, codeManifestUnknown, "manifest unknown to registry")
+		return
+	}
+	if err != nil {
+		writeInternalError(c, "failed to read manifest", err)
+		return
+	}
+	writeManifest(c, desc, data)
+}
+
+// hostedManifest looks up a tag or digest in repository name of a hosted
+// repoKey. It returns manifests.ErrNotFound when ref is not linked there.
+func (h *DockerRemoteHandler) hostedManifest(ctx context.Context, repoKey, name, ref string) (manifests.Descriptor, []byte, error) {
+	d, err := digest.Parse(ref)
+	if err == nil {
+		linked, err := h.manifests.HasRevision(repoKey, name, d)
+		if err != nil {
+			return manifests.Descriptor{}, nil, err
+		}
+		if !linked {
+			return manifests.Descriptor{}, nil, manifests.ErrNotFound
+		}
+	} else {
+		link, found, err := h.manifests.GetTag(repoKey, name, ref)
+		if err != nil {
+			return manifests.Descriptor{}, nil, err
+		}
+		if !found {
+			return manifests.Descriptor{}, nil, manifests.ErrNotFound
+		}
+		d = link.Digest
+	}
+	return h.manifests.Get(ctx, d)
+}
+
+// manifestRefs lists what a manifest points to, so a push can be rejected
+// before it references content the repository does not have.
+type manifestRefs struct {
+	Config *struct {
+		Digest digest.Digest `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Digest digest.Digest `json:"digest"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest digest.Digest `json:"digest"`
+	} `json:"manifests"`
+}
+
+// putHostedManifest stores a pushed manifest, links it into the repository
+// and, when ref is a tag, points the tag at it.
+func (h *DockerRemoteHandler) putHostedManifest(c *gin.Context, cfg *configstore.RepoConfig, name, ref string) {
+	ctx := c.Request.Context()
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, manifests.MaxManifestSize+1))
+	if err != nil {
+		log.WithError(err).Warn("failed to read manifest")
+		writeRegistryError(c, http.StatusBadRequest, codeManifestInvalid, "failed to read manifest")
+		return
+	}
+	if len(data) > manifests.MaxManifestSize {
+		writeRegistryError(c, http.StatusRequestEntityTooLarge, codeSizeInvalid, "manifest too large")
+		return
+	}
+	tag := ""
+	if want, err := digest.Parse(ref); err == nil {
+		if got := want.Algorithm().FromBytes(data); got != want {
+			writeRegistryError(c, http.StatusBadRequest, codeDigestInvalid, "manifest digest does not match reference")
+			return
+		}
+	} else if oci.IsValidTag(ref) {
+		tag = ref
+	} else {
+		writeRegistryError(c, http.StatusBadRequest, codeTagInvalid, "invalid tag")
+		return
+	}
+
+	var refs manifestRefs
+	if err := json.Unmarshal(data, &refs); err != nil {
+		writeRegistryError(c, http.StatusBadRequest, codeManifestInvalid, "manifest is not valid JSON")
+		return
+	}
+	if missing, err := h.missingManifestRefs(cfg.RepoKey, name, refs); err != nil {
+		writeInternalError(c, "failed to check manifest references", err)
+		return
+	} else if missing != "" {
+		writeRegistryErrors(c, http.StatusBadRequest, registryErrorEntry{
+			Code:    codeManifestBlobUnknown,
+			Message: "manifest references unknown content",
+			Detail:  gin.H{"digest": missing},
+		})
+		return
+	}
+
+	mediaType := manifestMediaType(c.GetHeader("Content-Type"), data)
+	if oci.IsSchema1(mediaType) && !cfg.AllowSchema1 {
+		writeRegistryError(c, http.StatusBadRequest, codeManifestInvalid, errSchema1.Error())
+		return
+	}
+	desc, err := h.manifests.Put(ctx, mediaType, data)
+	if err != nil {
+		writeInternalError(c, "failed to store manifest", err)
+		return
+	}
+	if err := h.manifests.PutRevision(cfg.RepoKey, name, desc.Digest); err != nil {
+		writeInternalError(c, "failed to link manifest", err)
+		return
+	}
+	if tag != "" {
+		if err := h.manifests.PutTag(cfg.RepoKey, name, tag, desc.Digest); err != nil {
+			writeInternalError(c, "failed to tag manifest", err)
+			return
+		}
+	}
+	if subject, ok := manifestSubject(data); ok {
+		if err := h.manifests.PutReferrer(cfg.RepoKey, name, subject, desc.Digest); err != nil {
+			writeInternalError(c, "failed to record referrer", err)
+			return
+		}
+		c.Header("OCI-Subject", subject.String())
+	}
+	log.WithFields(log.Fields{
+		"repoKey": cfg.RepoKey,
+		"name":    name,
+		"ref":     ref,
+		"digest":  desc.Digest,
+		"size":    desc.Size,
+	}).Info("Manifest pushed")
+	h.inventoryImage(cfg, name, desc, data)
+
+	c.Header("Location", fmt.Sprintf("/v2/%s/%s/manifests/%s", cfg.RepoKey, name, desc.Digest))
+	c.Header("Docker-Content-Digest", desc.Digest.String())
+	c.Status(http.StatusCreated)
+}
+
+// missingManifestRefs returns the first blob or child manifest refs names
+// that is not linked into the repository, or "" if all are present.
+func (h *DockerRemoteHandler) missingManifestRefs(repoKey, name string, refs manifestRefs) (digest.Digest, error) {
+	var layers []digest.Digest
+	if refs.Config != nil {
+		layers = append(layers, refs.Config.Digest)
+	}
+	for _, l := range refs.Layers {
+		layers = append(layers, l.Digest)
+	}
+	for _, d := range layers {
+		ok, err := h.manifests.HasLayer(repoKey, name, d)
+		if err != nil || !ok {
+			return d, err
+		}
+	}
+	for _, m := range refs.Manifests {
+		ok, err := h.manifests.HasRevision(repoKey, name, m.Digest)
+		if err != nil || !ok {
+			return m.Digest, err
+		}
+	}
+	return "", nil
+}
+
+// deleteHostedManifest unlinks a manifest by digest, removing the tags that
+// point to it, or removes a single tag.
+func (h *DockerRemoteHandler) deleteHostedManifest(c *gin.Context, cfg *configstore.RepoConfig, name, ref string) {
+	var found bool
+	var err error
+	if d, perr := digest.Parse(ref); perr == nil {
+		found, err = h.manifests.DeleteRevision(cfg.RepoKey, name, d)
+	} else {
+		_, found, err = h.manifests.GetTag(cfg.RepoKey, name, ref)
+		if err == nil && found {
+			err = h.manifests.DeleteTag(cfg.RepoKey, name, ref)
+		}
+	}
+	if err != nil {
+		writeInternalError(c, "failed to delete manifest", err)
+		return
+	}
+	if !found {
+		writeRegistryError(c, http.StatusNotFound, codeManifestUnknown, "manifest unknown to registry")
+		return
+	}
+	log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name, "ref": ref}).Info("Manifest deleted")
+	c.Status(http.StatusAccepted)
+}
+
+// getHostedBlob serves a blob linked into the repository.
+func (h *DockerRemoteHandler) getHostedBlob(c *gin.Context, cfg *configstore.RepoConfig, name string, d digest.Digest) {
+	linked, err := h.manifests.HasLayer(cfg.RepoKey, name, d)
+	if err != nil {
+		writeInternalError(c, "failed to read blob", err)
+		return
+	}
+	if !linked {
+		writeRegistryError(c, http.StatusNotFound, codeBlobUnknown, "blob unknown to registry")
+		return
+	}
+	if c.Request.Method == http.MethodHead {
+		size, err := h.blobs.Stat(c.Request.Context(), d)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, os.ErrNotExist) {
+				status = http.StatusNotFound
+			}
+			c.Status(status)
+			return
+		}
+		writeBlobHeaders(c, d, size)
+		c.Status(http.StatusOK)
+		return
+	}
+	h.serveCachedBlob(&blobRequest{
+		Ctx:    c.Request.Context(),
+		Gin:    c,
+		Digest: d,
+		Start:  time.Now(),
+		Logger: log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name}),
+	})
+}
+
+// deleteHostedBlob unlinks a blob from the repository. The content stays in
+// the blob store, where other repositories and the cache may share it.
+func (h *DockerRemoteHandler) deleteHostedBlob(c *gin.Context, cfg *configstore.RepoConfig, name string, d digest.Digest) {
+	found, err := h.manifests.DeleteLayer(cfg.RepoKey, name, d)
+	if err != nil {
+		writeInternalError(c, "failed to delete blob", err)
+		return
+	}
+	if !found {
+		writeRegistryError(c, http.StatusNotFound, codeBlobUnknown, "blob unknown to registry")
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+func (h *DockerRemoteHandler) hostedTagList(c *gin.Context, cfg *configstore.RepoConfig, name string) {
+	tags, err := h.manifests.Tags(cfg.RepoKey, name)
+	if err != nil {
+		writeInternalError(c, "failed to list tags", err)
+		return
+	}
+	if len(tags) == 0 {
+		writeRegistryError(c, http.StatusNotFound, codeNameUnknown, "repository name not known to registry")
+		return
+	}
+	writeTagList(c, cfg.RepoKey+"/"+name, tags)
+}
+
+// startUpload handles POST .../blobs/uploads/: a cross-repository mount, a
+// monolithic upload when a digest is given, or the start of a chunked upload.
+func (h *DockerRemoteHandler) startUpload(c *gin.Context, cfg *configstore.RepoConfig, name string) {
+	if mount := c.Query("mount"); mount != "" {
+		d, err := digest.Parse(mount)
+		if err != nil {
+			writeRegistryError(c, http.StatusBadRequest, codeDigestInvalid, "invalid digest")
+			return
+		}
+		mounted, err := h.mountBlob(c, cfg, name, d, c.Query("from"))
+		if err != nil {
+			writeInternalError(c, "failed to mount blob", err)
+			return
+		}
+		if mounted {
+			writeBlobCreated(c, cfg.RepoKey, name, d)
+			return
+		}
+		// The spec falls back to a regular upload when the mount is not possible.
+	}
+
+	u, err := h.uploads.Start(cfg.RepoKey, name)
+	if err != nil {
+		writeInternalError(c, "failed to start upload", err)
+		return
+	}
+	if dgst := c.Query("digest"); dgst != "" {
+		if _, err := u.Append(0, c.Request.Body); err != nil {
+			h.uploads.Cancel(u)
+			writeInternalError(c, "failed to receive blob", err)
+			return
+		}
+		h.commitUpload(c, cfg, u, dgst)
+		return
+	}
+	writeUploadHeaders(c, cfg.RepoKey, u, 0)
+	c.Status(http.StatusAccepted)
+}
+
+// mountBlob links d into name from the repository from, given as the client
+// sees it (repoKey/name). A hosted source must have the blob linked; a remote
+// source needs it cached.
+func (h *DockerRemoteHandler) mountBlob(c *gin.Context, cfg *configstore.RepoConfig, name string, d digest.Digest, from string) (bool, error) {
+	fromKey, fromName, ok := strings.Cut(from, "/")
+	if !ok {
+		return false, nil
+	}
+	src, ok := h.store.Get(fromKey)
+	if !ok || src.PackageType != configstore.PackageTypeDocker {
+		return false, nil
+	}
+	var available bool
+	var err error
+	if src.IsHosted() {
+		available, err = h.manifests.HasLayer(fromKey, fromName, d)
+	} else {
+		available, err = h.blobs.Exists(c.Request.Context(), d)
+	}
+	if err != nil || !available {
+		return false, err
+	}
+	if err := h.manifests.PutLayer(cfg.RepoKey, name, d); err != nil {
+		return false, err
+	}
+	log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name, "digest": d, "from": from}).Info("Blob mounted")
+	return true, nil
+}
+
+// handleUpload serves requests on an upload in progress.
+func (h *DockerRemoteHandler) handleUpload(c *gin.Context, cfg *configstore.RepoConfig, name, id string) {
+	u, err := h.uploads.Get(cfg.RepoKey, name, id)
+	if err != nil {
+		writeRegistryError(c, http.StatusNotFound, codeBlobUploadUnknown, "blob upload unknown to registry")
+		return
+	}
+	switch c.Request.Method {
+	case http.MethodGet:
+		writeUploadHeaders(c, cfg.RepoKey, u, u.Size())
+		c.Status(http.StatusNoContent)
+	case http.MethodPatch:
+		size, ok := h.appendChunk(c, u)
+		if !ok {
+			return
+		}
+		writeUploadHeaders(c, cfg.RepoKey, u, size)
+		c.Status(http.StatusAccepted)
+	case http.MethodPut:
+		if c.Request.ContentLength != 0 {
+			if _, ok := h.appendChunk(c, u); !ok {
+				return
+			}
+		}
+		h.commitUpload(c, cfg, u, c.Query("digest"))
+	case http.MethodDelete:
+		h.uploads.Cancel(u)
+		c.Status(http.StatusNoContent)
+	default:
+		writeRegistryError(c, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+	}
+}
+
+// appendChunk writes the request body to the upload, honoring Content-Range
+// when the client sends one.
+func (h *DockerRemoteHandler) appendChunk(c *gin.Context, u *uploads.Upload) (int64, bool) {
+	offset := int64(-1)
+	if cr := c.GetHeader("Content-Range"); cr != "" {
+		first, _, _ := strings.Cut(strings.TrimPrefix(cr, "bytes "), "-")
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			writeRegistryError(c, http.StatusBadRequest, codeBlobUploadInvalid, "invalid Content-Range")
+			return 0, false
+		}
+		offset = start
+	}
+	size, err := u.Append(offset, c.Request.Body)
+	if errors.Is(err, uploads.ErrOutOfOrder) {
+		writeUploadHeaders(c, u.RepoKey, u, size)
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return 0, false
+	}
+	if err != nil {
+		writeInternalError(c, "failed to receive chunk", err)
+		return 0, false
+	}
+	return size, true
+}
+
+// commitUpload finishes an upload under the digest the client named.
+func (h *DockerRemoteHandler) commitUpload(c *gin.Context, cfg *configstore.RepoConfig, u *uploads.Upload, dgst string) {
+	d, err := digest.Parse(dgst)
+	if err != nil {
+		h.uploads.Cancel(u)
+		writeRegistryError(c, http.StatusBadRequest, codeDigestInvalid, "invalid digest")
+		return
+	}
+	size, err := h.uploads.Commit(c.Request.Context(), u, h.fills, d)
+	if errors.Is(err, blobs.ErrDigestMismatch) {
+		writeRegistryError(c, http.StatusBadRequest, codeDigestInvalid, "digest did not match content")
+		return
+	}
+	if err != nil {
+		writeInternalError(c, "failed to store blob", err)
+		return
+	}
+	if err := h.manifests.PutLayer(cfg.RepoKey, u.Name, d); err != nil {
+		writeInternalError(c, "failed to link blob", err)
+		return
+	}
+	log.WithFields(log.Fields{
+		"repoKey":  cfg.RepoKey,
+		"name":     u.Name,
+		"digest":   d,
+		"size":     size,
+		"duration": time.Since(u.Started).Round(time.Millisecond),
+	}).Info("Blob pushed")
+	writeBlobCreated(c, cfg.RepoKey, u.Name, d)
+}
+
+func writeUploadHeaders(c *gin.Context, repoKey string, u *uploads.Upload, size int64) {
+	c.Header("Location", fmt.Sprintf("/v2/%s/%s/blobs/uploads/%s", repoKey, u.Name, u.ID))
+	c.Header("Docker-Upload-UUID", u.ID)
+	c.Header("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	c.Header("Content-Length", "0")
+}
+
+func writeBlobCreated(c *gin.Context, repoKey, name string, d digest.Digest) {
+	c.Header("Location", fmt.Sprintf("/v2/%s/%s/blobs/%s", repoKey, name, d))
+	c.Header("Docker-Content-Digest", d.String())
+	c.Header("Content-Length", "0")
+	c.Status(http.StatusCreated)
+}
@@ -0,0 +1,154 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHostedHandler(t *testing.T) (*gin.Engine, *DockerRemoteHandler) {
+	return newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "internal",
+		PackageType: configstore.PackageTypeDocker,
+		Kind:        configstore.RepoKindHosted,
+	})
+}
+
+func serve(r *gin.Engine, method, target string, body []byte, hdr http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// pushBlob uploads content in two chunks and returns its digest.
+func pushBlob(t *testing.T, r *gin.Engine, name string, content []byte) digest.Digest {
+	d := digest.FromBytes(content)
+	w := serve(r, http.MethodPost, "/v2/internal/"+name+"/blobs/uploads/", nil, nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	loc := w.Header().Get("Location")
+	assert.Equal(t, "0-0", w.Header().Get("Range"))
+
+	half := len(content) / 2
+	w = serve(r, http.MethodPatch, loc, content[:half], http.Header{"Content-Range": {fmt.Sprintf("0-%d", half-1)}})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, fmt.Sprintf("0-%d", half-1), w.Header().Get("Range"))
+
+	w = serve(r, http.MethodPut, loc+"?digest="+d.String(), content[half:], nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, d.String(), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, "/v2/internal/"+name+"/blobs/"+d.String(), w.Header().Get("Location"))
+	return d
+}
+
+func TestHosted_PushPull(t *testing.T) {
+	r, _ := newTestHostedHandler(t)
+
+	layer := pushBlob(t, r, "team/app", []byte("layer content of some length"))
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	configDigest := digest.FromBytes(config)
+	// Monolithic upload.
+	w := serve(r, http.MethodPost, "/v2/internal/team/app/blobs/uploads/?digest="+configDigest.String(), config, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	manifest, err := json.Marshal(v1.Manifest{
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(config))},
+		Layers:    []v1.Descriptor{{MediaType: v1.MediaTypeImageLayerGzip, Digest: layer, Size: 28}},
+	})
+	assert.NoError(t, err)
+	manifestDigest := digest.FromBytes(manifest)
+	w = serve(r, http.MethodPut, "/v2/internal/team/app/manifests/v1", manifest, http.Header{"Content-Type": {v1.MediaTypeImageManifest}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, manifestDigest.String(), w.Header().Get("Docker-Content-Digest"))
+
+	w = serve(r, http.MethodGet, "/v2/internal/team/app/manifests/v1", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, string(manifest), w.Body.String())
+	assert.Equal(t, v1.MediaTypeImageManifest, w.Header().Get("Content-Type"))
+
+	w = serve(r, http.MethodGet, "/v2/internal/team/app/blobs/"+layer.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "layer content of some length", w.Body.String())
+	w = serve(r, http.MethodHead, "/v2/internal/team/app/blobs/"+configDigest.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(r, http.MethodGet, "/v2/internal/team/app/tags/list", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"internal/team/app","tags":["v1"]}`, w.Body.String())
+
+	// Blobs are scoped to the repository they were pushed to.
+	w = serve(r, http.MethodGet, "/v2/internal/other/blobs/"+layer.String(), nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Deleting by digest removes the tag as well.
+	w = serve(r, http.MethodDelete, "/v2/internal/team/app/manifests/"+manifestDigest.String(), nil, nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = serve(r, http.MethodGet, "/v2/internal/team/app/manifests/v1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(r, http.MethodDelete, "/v2/internal/team/app/blobs/"+layer.String(), nil, nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = serve(r, http.MethodGet, "/v2/internal/team/app/blobs/"+layer.String(), nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHosted_Mount(t *testing.T) {
+	r, _ := newTestHostedHandler(t)
+	layer := pushBlob(t, r, "base", []byte("shared base layer"))
+
+	w := serve(r, http.MethodPost, "/v2/internal/app/blobs/uploads/?mount="+layer.String()+"&from=internal/base", nil, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/v2/internal/app/blobs/"+layer.String(), w.Header().Get("Location"))
+	w = serve(r, http.MethodGet, "/v2/internal/app/blobs/"+layer.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A mount that cannot be satisfied starts a regular upload.
+	w = serve(r, http.MethodPost, "/v2/internal/app/blobs/uploads/?mount="+digest.FromString("nope").String()+"&from=internal/base", nil, nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "/v2/internal/app/blobs/uploads/"))
+}
+
+func TestHosted_Rejects(t *testing.T) {
+	r, h := newTestHostedHandler(t)
+
+	// Wrong digest on commit.
+	w := serve(r, http.MethodPost, "/v2/internal/app/blobs/uploads/?digest="+digest.FromString("other").String(), []byte("content"), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	exists, err := h.blobs.Exists(t.Context(), digest.FromString("other"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Chunks must arrive in order.
+	w = serve(r, http.MethodPost, "/v2/internal/app/blobs/uploads/", nil, nil)
+	loc := w.Header().Get("Location")
+	w = serve(r, http.MethodPatch, loc, []byte("abc"), http.Header{"Content-Range": {"5-7"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	assert.Equal(t, "0-0", w.Header().Get("Range"))
+	w = serve(r, http.MethodDelete, loc, nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(r, http.MethodGet, loc, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Manifests may only reference content present in the repository.
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":%q},"layers":[]}`, digest.FromString("missing"))
+	w = serve(r, http.MethodPut, "/v2/internal/app/manifests/v1", []byte(manifest), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Remote repositories stay read-only.
+	h.store.Add(configstore.RepoConfig{RepoKey: "hub", PackageType: configstore.PackageTypeDocker, RemoteURL: "http://127.0.0.1:1"})
+	w = serve(r, http.MethodPost, "/v2/hub/app/blobs/uploads/", nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,227 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxConfigSize bounds the image configs inspection reads; configs are
+// small, even with long histories.
+const maxConfigSize = 8 << 20
+
+// imageInspection is the answer of GET /api/docker/:repoKey/inspect/*ref.
+type imageInspection struct {
+	RepoKey   string            `json:"repoKey"`
+	Name      string            `json:"name"`
+	Reference string            `json:"reference"`
+	Digest    digest.Digest     `json:"digest"`
+	MediaType string            `json:"mediaType"`
+	Platforms []inspectPlatform `json:"platforms,omitempty"`
+	Images    []inspectedImage  `json:"images"`
+}
+
+// inspectPlatform is one image listed by an index.
+type inspectPlatform struct {
+	Platform string        `json:"platform"`
+	Digest   digest.Digest `json:"digest"`
+}
+
+// inspectedImage describes one single-platform image. Size is the
+// compressed size of its layers, what a pull transfers; CachedSize is the
+// part of it already in the local store.
+type inspectedImage struct {
+	Digest     digest.Digest  `json:"digest"`
+	MediaType  string         `json:"mediaType"`
+	Platform   string         `json:"platform,omitempty"`
+	Created    *time.Time     `json:"created,omitempty"`
+	Config     *inspectConfig `json:"config,omitempty"`
+	History    []v1.History   `json:"history,omitempty"`
+	Layers     []inspectLayer `json:"layers"`
+	Size       int64          `json:"size"`
+	CachedSize int64          `json:"cachedSize"`
+	Cached     bool           `json:"cached"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// inspectConfig is the runtime configuration of an image.
+type inspectConfig struct {
+	Env        []string          `json:"env,omitempty"`
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	Cmd        []string          `json:"cmd,omitempty"`
+	WorkingDir string            `json:"workingDir,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+type inspectLayer struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+	Cached    bool          `json:"cached"`
+}
+
+// imageAccept asks for any current manifest kind when resolving images for
+// the /api/docker endpoints.
+func imageAccept() http.Header {
+	return http.Header{"Accept": {oci.ManifestAccept}}
+}
+
+// resolveImageRequest resolves the image named by the repoKey and ref
+// parameters of an /api/docker request, as a pull would, and returns the
+// repository holding it. On failure it answers the request and returns
+// false.
+func (h *DockerRemoteHandler) resolveImageRequest(c *gin.Context) (imageRef, configstore.RepoConfig, manifests.Descriptor, []byte, bool) {
+	ref, err := parseImageRef(c.Param("repoKey") + "/" + strings.TrimPrefix(c.Param("ref"), "/"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return imageRef{}, configstore.RepoConfig{}, manifests.Descriptor{}, nil, false
+	}
+	cfg, ok := h.store.Get(ref.RepoKey)
+	if !ok || cfg.PackageType != configstore.PackageTypeDocker {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%q: unknown docker repoKey", ref.RepoKey)})
+		return imageRef{}, configstore.RepoConfig{}, manifests.Descriptor{}, nil, false
+	}
+	member, desc, data, err := h.resolveManifest(c.Request.Context(), &cfg, ref.Name, ref.Ref, imageAccept())
+	var se *signatureError
+	switch {
+	case errors.As(err, &se):
+		writeError(c, http.StatusForbidden, se.Error(), err)
+	case memberMiss(err):
+		writeError(c, http.StatusNotFound, "image not found", err)
+	case err != nil:
+		writeError(c, http.StatusBadGateway, "failed to resolve image", err)
+	default:
+		return ref, member, desc, data, true
+	}
+	return imageRef{}, configstore.RepoConfig{}, manifests.Descriptor{}, nil, false
+}
+
+// InspectImage answers GET /api/docker/:repoKey/inspect/<name>[:tag|@digest]
+// with what an image is made of and how much of it is cached, without
+// pulling its layers. The manifest and config are resolved as a pull would,
+// so they end up cached. For an index every platform image is inspected, or
+// those matching the platform query parameters.
+func (h *DockerRemoteHandler) InspectImage(c *gin.Context) {
+	ref, member, desc, data, ok := h.resolveImageRequest(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	out := imageInspection{
+		RepoKey:   ref.RepoKey,
+		Name:      ref.Name,
+		Reference: ref.Ref,
+		Digest:    desc.Digest,
+		MediaType: desc.MediaType,
+		Images:    []inspectedImage{},
+	}
+	if !isIndex(desc.MediaType) {
+		out.Images = append(out.Images, h.inspectManifest(ctx, &member, ref.Name, desc, data))
+		c.JSON(http.StatusOK, out)
+		return
+	}
+
+	var index v1.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		writeError(c, http.StatusBadGateway, "invalid image index", err)
+		return
+	}
+	platforms := c.QueryArray("platform")
+	for _, m := range index.Manifests {
+		platform := ""
+		if m.Platform != nil {
+			platform = platformString(m.Platform)
+		}
+		out.Platforms = append(out.Platforms, inspectPlatform{Platform: platform, Digest: m.Digest})
+		if !wantPlatform(platforms, m.Platform) {
+			continue
+		}
+		d, mdata, err := h.memberManifest(ctx, &member, ref.Name, m.Digest.String(), imageAccept())
+		if err != nil {
+			out.Images = append(out.Images, inspectedImage{Digest: m.Digest, MediaType: m.MediaType, Platform: platform, Layers: []inspectLayer{}, Error: err.Error()})
+			continue
+		}
+		img := h.inspectManifest(ctx, &member, ref.Name, d, mdata)
+		img.Platform = platform
+		out.Images = append(out.Images, img)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// inspectManifest describes image manifest desc of cfg. A config that cannot
+// be read is reported in the image's Error; the layers are still listed.
+func (h *DockerRemoteHandler) inspectManifest(ctx context.Context, cfg *configstore.RepoConfig, name string, desc manifests.Descriptor, data []byte) inspectedImage {
+	img := inspectedImage{Digest: desc.Digest, MediaType: desc.MediaType, Layers: []inspectLayer{}}
+	var m oci.OCIManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		img.Error = fmt.Sprintf("invalid manifest: %v", err)
+		return img
+	}
+	img.Cached = true
+	for _, l := range m.Layers {
+		d := digest.Digest(l.Digest)
+		cached, _ := h.blobs.Exists(ctx, d)
+		img.Layers = append(img.Layers, inspectLayer{Digest: d, MediaType: l.MediaType, Size: l.Size, Cached: cached})
+		img.Size += l.Size
+		if cached {
+			img.CachedSize += l.Size
+		} else {
+			img.Cached = false
+		}
+	}
+
+	config, err := h.imageConfig(ctx, cfg, name, m.Config)
+	if err != nil {
+		img.Error = fmt.Sprintf("config %s: %v", m.Config.Digest, err)
+		return img
+	}
+	img.Created = config.Created
+	img.History = config.History
+	img.Config = &inspectConfig{
+		Env:        config.Config.Env,
+		Entrypoint: config.Config.Entrypoint,
+		Cmd:        config.Config.Cmd,
+		WorkingDir: config.Config.WorkingDir,
+		User:       config.Config.User,
+		Labels:     config.Config.Labels,
+	}
+	return img
+}
+
+// imageConfig reads the config blob d of an image from the local store,
+// caching it from upstream first when cfg is a remote.
+func (h *DockerRemoteHandler) imageConfig(ctx context.Context, cfg *configstore.RepoConfig, name string, d oci.Descriptor) (v1.Image, error) {
+	var config v1.Image
+	dgst, err := digest.Parse(d.Digest)
+	if err != nil {
+		return config, err
+	}
+	if !cfg.IsHosted() {
+		client := h.clients.Get(cfg)
+		if err := h.cacheBlob(ctx, cfg, client, normalizeName(cfg.RemoteURL, name), dgst); err != nil {
+			return config, err
+		}
+	}
+	rc, err := h.blobs.Get(ctx, dgst)
+	if err != nil {
+		return config, err
+	}
+	defer func() { _ = rc.Close() }()
+	if err := json.NewDecoder(io.LimitReader(rc, maxConfigSize)).Decode(&config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
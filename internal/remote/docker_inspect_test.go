@@ -0,0 +1,95 @@
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectImage(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	configData, err := json.Marshal(v1.Image{
+		Platform: v1.Platform{OS: "linux", Architecture: "amd64"},
+		Config: v1.ImageConfig{
+			Env:        []string{"PATH=/bin"},
+			Entrypoint: []string{"/app"},
+			Labels:     map[string]string{"team": "platform"},
+		},
+		History: []v1.History{{CreatedBy: "COPY app /app"}},
+	})
+	require.NoError(t, err)
+	config := reg.addBlob(configData)
+	config.MediaType = v1.MediaTypeImageConfig
+	base, app := reg.addBlob([]byte("base layer")), reg.addBlob([]byte("app layer"))
+	data, err := json.Marshal(v1.Manifest{
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []v1.Descriptor{base, app},
+	})
+	require.NoError(t, err)
+	amd64 := reg.addManifest(v1.MediaTypeImageManifest, data)
+	amd64.Platform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := reg.addImage(t, "arm64")
+	arm64.Platform = &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	index, err := json.Marshal(v1.Index{
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: []v1.Descriptor{amd64, arm64},
+	})
+	require.NoError(t, err)
+	indexDesc := reg.addManifest(v1.MediaTypeImageIndex, index, "1.0")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	inspect := func() imageInspection {
+		w := serve(r, http.MethodGet, "/api/docker/hub/inspect/org/app:1.0?platform=linux/amd64", nil, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var out imageInspection
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &out))
+		return out
+	}
+
+	out := inspect()
+	assert.Equal(t, indexDesc.Digest, out.Digest)
+	assert.Equal(t, []inspectPlatform{
+		{Platform: "linux/amd64", Digest: amd64.Digest},
+		{Platform: "linux/arm64/v8", Digest: arm64.Digest},
+	}, out.Platforms)
+	require.Len(t, out.Images, 1)
+	img := out.Images[0]
+	assert.Equal(t, amd64.Digest, img.Digest)
+	assert.Equal(t, "linux/amd64", img.Platform)
+	require.NotNil(t, img.Config)
+	assert.Equal(t, []string{"PATH=/bin"}, img.Config.Env)
+	assert.Equal(t, []string{"/app"}, img.Config.Entrypoint)
+	assert.Equal(t, "platform", img.Config.Labels["team"])
+	assert.Equal(t, "COPY app /app", img.History[0].CreatedBy)
+	require.Len(t, img.Layers, 2)
+	assert.Equal(t, base.Size+app.Size, img.Size)
+	assert.Zero(t, img.CachedSize)
+	assert.False(t, img.Cached)
+	assert.Zero(t, reg.count("/v2/org/app/blobs/"+base.Digest.String()), "layers are not pulled")
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/blobs/"+base.Digest.String(), nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	img = inspect().Images[0]
+	assert.True(t, img.Layers[0].Cached)
+	assert.False(t, img.Layers[1].Cached)
+	assert.Equal(t, base.Size, img.CachedSize)
+	assert.False(t, img.Cached)
+
+	w = serve(r, http.MethodGet, "/api/docker/nope/inspect/org/app:1.0", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(r, http.MethodGet, "/api/docker/hub/inspect/org/app:missing", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,361 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPrefetchConcurrency bounds how many blobs all prefetch jobs download
+// at once.
+const DefaultPrefetchConcurrency = 4
+
+// maxPrefetchJobs is how many jobs are kept for status queries; the oldest
+// finished jobs are forgotten first.
+const maxPrefetchJobs = 100
+
+// Job states.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+type prefetchRequest struct {
+	Images []string `json:"images"`
+	// Platforms selects the images of an index to fetch, as os/arch[/variant].
+	// Empty means the repository's prefetch platforms, or every platform.
+	Platforms []string `json:"platforms"`
+}
+
+// imageRef is a parsed repoKey/name:tag or repoKey/name@digest reference.
+type imageRef struct {
+	RepoKey string
+	Name    string
+	Ref     string
+}
+
+func parseImageRef(s string) (imageRef, error) {
+	repoKey, rest, ok := strings.Cut(s, "/")
+	if !ok || repoKey == "" || rest == "" {
+		return imageRef{}, fmt.Errorf("%q: expected repoKey/name[:tag|@digest]", s)
+	}
+	name, ref := rest, "latest"
+	if i := strings.Index(rest, "@"); i >= 0 {
+		name, ref = rest[:i], rest[i+1:]
+		if _, err := digest.Parse(ref); err != nil {
+			return imageRef{}, fmt.Errorf("%q: invalid digest: %w", s, err)
+		}
+	} else if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		name, ref = rest[:i], rest[i+1:]
+		if !oci.IsValidTag(ref) {
+			return imageRef{}, fmt.Errorf("%q: invalid tag", s)
+		}
+	}
+	if _, err := oci.ParseRepositoryName(name); err != nil {
+		return imageRef{}, fmt.Errorf("%q: invalid name: %w", s, err)
+	}
+	return imageRef{RepoKey: repoKey, Name: name, Ref: ref}, nil
+}
+
+// prefetchImage is the progress of one requested reference.
+type prefetchImage struct {
+	Ref    string        `json:"ref"`
+	Digest digest.Digest `json:"digest,omitempty"`
+	State  string        `json:"state"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// prefetchJob is a background download of a list of images. Its fields are
+// guarded by the owning prefetchJobs' mutex.
+type prefetchJob struct {
+	ID         string          `json:"id"`
+	State      string          `json:"state"`
+	Created    time.Time       `json:"created"`
+	Finished   *time.Time      `json:"finished,omitempty"`
+	Images     []prefetchImage `json:"images"`
+	BlobsTotal int             `json:"blobsTotal"`
+	BlobsDone  int             `json:"blobsDone"`
+	BytesTotal int64           `json:"bytesTotal"`
+	BytesDone  int64           `json:"bytesDone"`
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+// prefetchJobs tracks prefetch jobs and the download slots they share.
+type prefetchJobs struct {
+	sem chan struct{}
+
+	mu    sync.Mutex
+	jobs  map[string]*prefetchJob
+	order []string
+}
+
+func newPrefetchJobs(concurrency int) *prefetchJobs {
+	return &prefetchJobs{
+		sem:  make(chan struct{}, concurrency),
+		jobs: make(map[string]*prefetchJob),
+	}
+}
+
+func (p *prefetchJobs) add(refs []string) *prefetchJob {
+	job := &prefetchJob{
+		ID:      uuid.NewString(),
+		State:   jobQueued,
+		Created: time.Now().UTC(),
+		Images:  make([]prefetchImage, len(refs)),
+	}
+	for i, ref := range refs {
+		job.Images[i] = prefetchImage{Ref: ref, State: jobQueued}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs[job.ID] = job
+	p.order = append(p.order, job.ID)
+	for i := 0; len(p.order) > maxPrefetchJobs && i < len(p.order); {
+		old := p.jobs[p.order[i]]
+		if old.State == jobDone || old.State == jobFailed {
+			delete(p.jobs, old.ID)
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			continue
+		}
+		i++
+	}
+	return job
+}
+
+// snapshot returns the job encoded as JSON.
+func (p *prefetchJobs) snapshot(id string) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	data, err := json.Marshal(job)
+	return data, err == nil
+}
+
+func (p *prefetchJobs) update(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn()
+}
+
+// acquire takes a download slot, or fails when ctx ends first.
+func (p *prefetchJobs) acquire(ctx context.Context) error {
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *prefetchJobs) release() { <-p.sem }
+
+// StartPrefetch answers POST /api/prefetch: it validates the references and
+// starts a background job downloading them, returning the job.
+func (h *DockerRemoteHandler) StartPrefetch(c *gin.Context) {
+	var body prefetchRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if len(body.Images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no images given"})
+		return
+	}
+	refs := make([]imageRef, len(body.Images))
+	for i, s := range body.Images {
+		ref, err := parseImageRef(s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if cfg, ok := h.store.Get(ref.RepoKey); !ok || cfg.PackageType != configstore.PackageTypeDocker {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q: unknown docker repoKey", s)})
+			return
+		}
+		refs[i] = ref
+	}
+	job := h.jobs.add(body.Images)
+	h.goBackground(func(ctx context.Context) {
+		h.runPrefetch(ctx, job, refs, body.Platforms)
+	})
+	log.WithFields(log.Fields{"job": job.ID, "images": len(refs)}).Info("Prefetch job started")
+
+	data, _ := h.jobs.snapshot(job.ID)
+	c.Header("Location", "/api/prefetch/"+job.ID)
+	c.Data(http.StatusAccepted, "application/json", data)
+}
+
+// GetPrefetch answers GET /api/prefetch/:id with the job's progress.
+func (h *DockerRemoteHandler) GetPrefetch(c *gin.Context) {
+	data, ok := h.jobs.snapshot(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown prefetch job"})
+		return
+	}
+	c.Data(http.StatusOK, "application/json", data)
+}
+
+func (h *DockerRemoteHandler) runPrefetch(ctx context.Context, job *prefetchJob, refs []imageRef, platforms []string) {
+	h.jobs.update(func() { job.State = jobRunning })
+	logger := log.WithField("job", job.ID)
+	failed := false
+	for i, ref := range refs {
+		h.jobs.update(func() { job.Images[i].State = jobRunning })
+		err := h.prefetchRef(ctx, job, i, ref, platforms)
+		h.jobs.update(func() {
+			job.Images[i].State = jobDone
+			if err != nil {
+				failed = true
+				job.Images[i].State = jobFailed
+				job.Images[i].Error = err.Error()
+				job.Errors = append(job.Errors, fmt.Sprintf("%s: %v", job.Images[i].Ref, err))
+			}
+		})
+		if err != nil {
+			logger.WithError(err).WithField("ref", job.Images[i].Ref).Warn("Prefetch failed")
+		}
+	}
+	h.jobs.update(func() {
+		now := time.Now().UTC()
+		job.Finished = &now
+		job.State = jobDone
+		if failed {
+			job.State = jobFailed
+		}
+		logger.WithFields(log.Fields{"state": job.State, "blobs": job.BlobsDone, "bytes": job.BytesDone}).Info("Prefetch job finished")
+	})
+}
+
+// prefetchRef resolves one reference and downloads its images. For an index,
+// the images of the selected platforms are fetched.
+func (h *DockerRemoteHandler) prefetchRef(ctx context.Context, job *prefetchJob, i int, ref imageRef, platforms []string) error {
+	cfg, ok := h.store.Get(ref.RepoKey)
+	if !ok {
+		return fmt.Errorf("unknown repoKey %s", ref.RepoKey)
+	}
+	hdr := http.Header{"Accept": {oci.ManifestAccept}}
+	member, desc, data, err := h.resolveManifest(ctx, &cfg, ref.Name, ref.Ref, hdr)
+	if err != nil {
+		return err
+	}
+	h.jobs.update(func() { job.Images[i].Digest = desc.Digest })
+	if !isIndex(desc.MediaType) {
+		return h.prefetchManifest(ctx, job, &member, ref.Name, desc.Digest, data)
+	}
+
+	var index v1.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("invalid index %s: %w", desc.Digest, err)
+	}
+	if len(platforms) == 0 {
+		platforms = member.PrefetchPlatforms
+	}
+	var errs []error
+	for _, m := range index.Manifests {
+		if !wantPlatform(platforms, m.Platform) {
+			continue
+		}
+		if err := h.prefetchManifest(ctx, job, &member, ref.Name, m.Digest, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// wantPlatform selects the images of an index to prefetch. Without platforms,
+// every image with a real platform is wanted; attestations are listed as
+// unknown/unknown.
+func wantPlatform(platforms []string, p *v1.Platform) bool {
+	if len(platforms) == 0 {
+		return p == nil || p.OS != "unknown"
+	}
+	for _, spec := range platforms {
+		if platformMatches(spec, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefetchManifest downloads image manifest d of cfg, unless data already
+// holds it, and then its config and layers on the shared download slots.
+func (h *DockerRemoteHandler) prefetchManifest(ctx context.Context, job *prefetchJob, cfg *configstore.RepoConfig, name string, d digest.Digest, data []byte) error {
+	if data == nil {
+		var err error
+		_, data, err = h.memberManifest(ctx, cfg, name, d.String(), http.Header{"Accept": {oci.ManifestAccept}})
+		if err != nil {
+			return fmt.Errorf("manifest %s: %w", d, err)
+		}
+	}
+	var m v1.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("manifest %s: %w", d, err)
+	}
+	blobs := append([]v1.Descriptor{m.Config}, m.Layers...)
+	h.jobs.update(func() {
+		for _, b := range blobs {
+			job.BlobsTotal++
+			job.BytesTotal += b.Size
+		}
+	})
+
+	var client *oci.RegistryClient
+	normalizedName := name
+	if !cfg.IsHosted() {
+		client = h.clients.Get(cfg)
+		normalizedName = normalizeName(cfg.RemoteURL, name)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, b := range blobs {
+		if err := h.jobs.acquire(ctx); err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer h.jobs.release()
+			var err error
+			if client != nil {
+				err = h.cacheBlob(ctx, cfg, client, normalizedName, b.Digest)
+			} else if exists, serr := h.blobs.Exists(ctx, b.Digest); serr != nil || !exists {
+				err = errors.Join(serr, manifests.ErrNotFound)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("blob %s: %w", b.Digest, err))
+				mu.Unlock()
+				return
+			}
+			h.jobs.update(func() {
+				job.BlobsDone++
+				job.BytesDone += b.Size
+			})
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitPrefetch(t *testing.T, r *gin.Engine, id string) prefetchJob {
+	var job prefetchJob
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w := serve(r, http.MethodGet, "/api/prefetch/"+id, nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+		if job.Finished != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("prefetch job %s did not finish", id)
+	return job
+}
+
+func TestPrefetchJob(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	amd64 := reg.addImage(t, "amd64")
+	amd64.Platform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := reg.addImage(t, "arm64")
+	arm64.Platform = &v1.Platform{OS: "linux", Architecture: "arm64"}
+	index, err := json.Marshal(v1.Index{MediaType: v1.MediaTypeImageIndex, Manifests: []v1.Descriptor{amd64, arm64}})
+	assert.NoError(t, err)
+	reg.addManifest(v1.MediaTypeImageIndex, index, "1.0")
+	single := reg.addImage(t, "single")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	defer h.Close()
+
+	body, _ := json.Marshal(prefetchRequest{
+		Images:    []string{"hub/org/app:1.0", "hub/org/app@" + single.Digest.String()},
+		Platforms: []string{"linux/arm64"},
+	})
+	w := serve(r, http.MethodPost, "/api/prefetch", body, nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var started prefetchJob
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &started))
+	assert.Equal(t, "/api/prefetch/"+started.ID, w.Header().Get("Location"))
+
+	job := waitPrefetch(t, r, started.ID)
+	assert.Equal(t, jobDone, job.State)
+	assert.Empty(t, job.Errors)
+	assert.Equal(t, 4, job.BlobsTotal)
+	assert.Equal(t, 4, job.BlobsDone)
+	assert.Equal(t, job.BytesTotal, job.BytesDone)
+	assert.Equal(t, single.Digest, job.Images[1].Digest)
+
+	for _, img := range []v1.Descriptor{arm64, single} {
+		_, _, err := h.manifests.Get(t.Context(), img.Digest)
+		assert.NoError(t, err)
+	}
+	assert.Zero(t, reg.count("/v2/org/app/manifests/"+amd64.Digest.String()))
+
+	// A missing image fails the job but not its neighbours.
+	reg.addImage(t, "latest", "latest")
+	body, _ = json.Marshal(prefetchRequest{Images: []string{"hub/org/app:missing", "hub/org/app"}})
+	w = serve(r, http.MethodPost, "/api/prefetch", body, nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &started))
+	job = waitPrefetch(t, r, started.ID)
+	assert.Equal(t, jobFailed, job.State)
+	assert.Equal(t, jobFailed, job.Images[0].State)
+	assert.Equal(t, jobDone, job.Images[1].State)
+}
+
+func TestPrefetchJob_Rejects(t *testing.T) {
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{RepoKey: "hub", PackageType: configstore.PackageTypeDocker})
+	for _, body := range []string{`{"images":[]}`, `{"images":["nokey"]}`, `{"images":["other/app:1"]}`, `{"images":["hub/app:bad tag"]}`, `nope`} {
+		w := serve(r, http.MethodPost, "/api/prefetch", bytes.NewBufferString(body).Bytes(), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/api/prefetch/unknown", nil, nil).Code)
+}
+
+func TestParseImageRef(t *testing.T) {
+	ref, err := parseImageRef("hub/library/nginx")
+	assert.NoError(t, err)
+	assert.Equal(t, imageRef{RepoKey: "hub", Name: "library/nginx", Ref: "latest"}, ref)
+	ref, err = parseImageRef("hub/org/app:1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2", ref.Ref)
+	_, err = parseImageRef("hub/org/app@sha256:nothex")
+	assert.Error(t, err)
+}
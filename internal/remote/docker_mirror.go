@@ -0,0 +1,89 @@
+package remote
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	log "github.com/sirupsen/logrus"
+)
+
+// dockerHubNamespace is the ns containerd sends for Docker Hub images.
+const dockerHubNamespace = "docker.io"
+
+// SetMirror makes repoKey serve requests whose first path component is not a
+// configured repoKey, so gobinrepo can be listed in dockerd's registry-mirrors.
+// It must be called before the handler serves requests.
+func (h *DockerRemoteHandler) SetMirror(repoKey string) {
+	h.mirror = repoKey
+}
+
+// routeFor returns the repoKey that serves a request whose first path
+// component is first, when first is not a repoKey itself. The containerd ns
+// query parameter is matched against each remote's Namespaces, then the Host
+// header against its Hosts. Docker Hub requests fall back to the mirror.
+func (h *DockerRemoteHandler) routeFor(r *http.Request, first string) (string, bool) {
+	if _, ok := h.store.Get(first); ok {
+		return "", false
+	}
+	ns := strings.ToLower(r.URL.Query().Get("ns"))
+	host := strings.ToLower(r.Host)
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	if ns != "" {
+		if repoKey, ok := h.routeMatching(func(cfg configstore.RepoConfig) []string { return cfg.Namespaces }, ns); ok {
+			return repoKey, true
+		}
+	}
+	if repoKey, ok := h.routeMatching(func(cfg configstore.RepoConfig) []string { return cfg.Hosts }, host); ok {
+		return repoKey, true
+	}
+	if ns != "" && ns != dockerHubNamespace {
+		// Another registry's images must not be looked up on Docker Hub.
+		return "", false
+	}
+	return h.mirrorRepoKey()
+}
+
+// routeMatching finds the docker repository listing value in field.
+func (h *DockerRemoteHandler) routeMatching(field func(configstore.RepoConfig) []string, value string) (string, bool) {
+	cfgs := h.store.List()
+	slices.SortFunc(cfgs, func(a, b configstore.RepoConfig) int { return strings.Compare(a.RepoKey, b.RepoKey) })
+	for _, cfg := range cfgs {
+		if cfg.PackageType != configstore.PackageTypeDocker {
+			continue
+		}
+		if slices.ContainsFunc(field(cfg), func(v string) bool { return strings.EqualFold(v, value) }) {
+			return cfg.RepoKey, true
+		}
+	}
+	return "", false
+}
+
+// mirrorRepoKey returns the configured mirror or, without one, the single
+// docker remote for Docker Hub, since that is what docker daemons ask
+// mirrors for.
+func (h *DockerRemoteHandler) mirrorRepoKey() (string, bool) {
+	if h.mirror != "" {
+		if _, ok := h.store.Get(h.mirror); ok {
+			return h.mirror, true
+		}
+		log.WithField("mirror", h.mirror).Warn("Configured docker mirror is not a repository")
+		return "", false
+	}
+	var hub string
+	for _, cfg := range h.store.List() {
+		if cfg.PackageType != configstore.PackageTypeDocker || cfg.Kind != configstore.RepoKindRemote || cfg.RemoteURL != dockerHubURL {
+			continue
+		}
+		if hub != "" {
+			// Ambiguous; a mirror has to be configured.
+			return "", false
+		}
+		hub = cfg.RepoKey
+	}
+	return hub, hub != ""
+}
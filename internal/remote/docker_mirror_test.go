@@ -0,0 +1,93 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirror_UnprefixedPaths(t *testing.T) {
+	fake := &fakeRegistry{}
+	upstream := httptest.NewServer(fake)
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+
+	// Without a mirror an unknown first component is an unknown repoKey.
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/v2/library/nginx/manifests/latest", nil, nil).Code)
+
+	h.SetMirror("hub")
+	w := serve(r, http.MethodGet, "/v2/library/nginx/manifests/latest", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testManifest, w.Body.String())
+	_, found, err := h.manifests.GetTag("hub", "library/nginx", "latest")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	// Single-component names too.
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodHead, "/v2/nginx/manifests/latest", nil, nil).Code)
+	// Prefixed paths keep working.
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/v2/hub/library/nginx/manifests/latest", nil, nil).Code)
+}
+
+func TestMirror_DetectsDockerHub(t *testing.T) {
+	_, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "dockerhub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   dockerHubURL,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest", nil)
+	mirror, ok := h.routeFor(req, "library")
+	assert.True(t, ok)
+	assert.Equal(t, "dockerhub", mirror)
+	_, ok = h.routeFor(req, "dockerhub")
+	assert.False(t, ok)
+
+	// Two candidates need an explicit choice.
+	h.store.Add(configstore.RepoConfig{RepoKey: "hub2", PackageType: configstore.PackageTypeDocker, RemoteURL: dockerHubURL})
+	_, ok = h.routeFor(req, "library")
+	assert.False(t, ok)
+	h.SetMirror("hub2")
+	mirror, ok = h.routeFor(req, "library")
+	assert.True(t, ok)
+	assert.Equal(t, "hub2", mirror)
+}
+
+func TestRoute_NamespaceAndHost(t *testing.T) {
+	fake := &fakeRegistry{}
+	upstream := httptest.NewServer(fake)
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "ghcr",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		Namespaces:  []string{"ghcr.io"},
+		Hosts:       []string{"ghcr.mirror.corp"},
+	})
+	h.store.Add(configstore.RepoConfig{RepoKey: "dockerhub", PackageType: configstore.PackageTypeDocker, RemoteURL: dockerHubURL})
+
+	w := serve(r, http.MethodGet, "/v2/org/app/manifests/latest?ns=ghcr.io", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, found, err := h.manifests.GetTag("ghcr", "org/app", "latest")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	req := httptest.NewRequest(http.MethodGet, "http://ghcr.mirror.corp:5000/v2/org/app/manifests/latest", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Unknown registries are not looked up on the Docker Hub mirror...
+	_, ok := h.routeFor(httptest.NewRequest(http.MethodGet, "/v2/org/app/manifests/latest?ns=quay.io", nil), "org")
+	assert.False(t, ok)
+	// ...but Docker Hub images are.
+	repoKey, ok := h.routeFor(httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest?ns=docker.io", nil), "library")
+	assert.True(t, ok)
+	assert.Equal(t, "dockerhub", repoKey)
+}
@@ -0,0 +1,31 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+)
+
+// errOffline is returned in place of any upstream request of an offline
+// remote.
+var errOffline = errors.New("remote is offline")
+
+// offlineTransport fails every request without touching the network.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errOffline
+}
+
+// writeOffline answers a lookup that failed because cfg is offline: what is
+// not cached does not exist. It reports whether err was such a failure.
+func writeOffline(c *gin.Context, cfg *configstore.RepoConfig, err error, code string) bool {
+	if !errors.Is(err, errOffline) {
+		return false
+	}
+	writeRegistryError(c, http.StatusNotFound, code, fmt.Sprintf("not cached and %s is offline", cfg.RepoKey))
+	return true
+}
@@ -0,0 +1,100 @@
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func registryErrorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var body struct {
+		Errors []struct {
+			Code string `json:"code"`
+		} `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if !assert.Len(t, body.Errors, 1) {
+		return ""
+	}
+	return body.Errors[0].Code
+}
+
+func TestOfflineRemote(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	img := reg.addImage(t, "v1", "1.0")
+	reg.addImage(t, "v2", "2.0")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	cfg := configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	}
+	r, h := newTestDockerHandler(t, cfg)
+	defer h.Close()
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	layer := reg.addBlob([]byte("layer v1"))
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/blobs/"+layer.Digest.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	cfg.Offline = true
+	h.store.Add(cfg)
+	hits := reg.count("/v2/org/app/manifests/1.0")
+
+	// The tag resolves from its cached state although TagTTL is zero.
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, img.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+	w = serve(r, http.MethodHead, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/blobs/"+layer.Digest.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/tags/list", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"hub/org/app","tags":["1.0"]}`, w.Body.String())
+
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/2.0", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "MANIFEST_UNKNOWN", registryErrorCode(t, w))
+	w = serve(r, http.MethodHead, "/v2/hub/org/app/manifests/2.0", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/blobs/"+digest.FromString("missing").String(), nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "BLOB_UNKNOWN", registryErrorCode(t, w))
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/referrers/"+img.Digest.String(), nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "UNAVAILABLE", registryErrorCode(t, w))
+
+	assert.Equal(t, hits, reg.count("/v2/org/app/manifests/1.0"))
+	assert.Zero(t, reg.count("/v2/org/app/manifests/2.0"))
+	assert.Zero(t, reg.count("/v2/org/app/tags/list"))
+}
+
+func TestOfflineVirtualSkipsMember(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	reg.addImage(t, "v1", "1.0")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "all",
+		PackageType: configstore.PackageTypeDocker,
+		Kind:        configstore.RepoKindVirtual,
+		Members:     []string{"cut", "hub"},
+	})
+	defer h.Close()
+	h.store.Add(configstore.RepoConfig{RepoKey: "cut", PackageType: configstore.PackageTypeDocker, RemoteURL: "http://127.0.0.1:1", Offline: true})
+	h.store.Add(configstore.RepoConfig{RepoKey: "hub", PackageType: configstore.PackageTypeDocker, RemoteURL: upstream.URL})
+
+	w := serve(r, http.MethodGet, "/v2/all/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, v1.MediaTypeImageManifest, w.Header().Get("Content-Type"))
+}
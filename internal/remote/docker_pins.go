@@ -0,0 +1,192 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// pendingPin is a tag move awaiting approval, as listed by /api/pins.
+type pendingPin struct {
+	RepoKey string `json:"repoKey"`
+	manifests.MovedTag
+}
+
+// approvePinRequest is the body of POST /api/pins/approve.
+type approvePinRequest struct {
+	RepoKey string `json:"repoKey"`
+	Name    string `json:"name"`
+	Tag     string `json:"tag"`
+}
+
+// trackTag compares the digest upstream resolves tag to with the one pinned
+// for it, the first seen unless a move was approved since, and returns the
+// digest to serve. A move is logged as a tag_moved event. Without PinTags the
+// pin follows upstream; with it the new digest is recorded as pending and the
+// pinned one is kept.
+func (h *DockerRemoteHandler) trackTag(cfg *configstore.RepoConfig, name, tag string, upstream digest.Digest) digest.Digest {
+	// Tags derived from digests hold signatures and referrers, which are
+	// expected to change as artifacts are attached.
+	if strings.HasPrefix(tag, upstream.Algorithm().String()+"-") {
+		return upstream
+	}
+	logger := log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name, "tag": tag})
+	pin, found, err := h.manifests.GetPin(cfg.RepoKey, name, tag)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read pinned tag")
+		return upstream
+	}
+	if !found || pin.Digest == upstream {
+		if !found {
+			if err := h.manifests.PutPin(cfg.RepoKey, name, tag, upstream); err != nil {
+				logger.WithError(err).Warn("Failed to pin tag")
+			}
+		}
+		if cfg.PinTags {
+			if err := h.manifests.DeletePending(cfg.RepoKey, name, tag); err != nil {
+				logger.WithError(err).Warn("Failed to clear pending tag move")
+			}
+		}
+		return upstream
+	}
+
+	event := logger.WithFields(log.Fields{
+		"event":  "tag_moved",
+		"old":    pin.Digest,
+		"new":    upstream,
+		"pinned": cfg.PinTags,
+	})
+	if !cfg.PinTags {
+		event.Info("Tag moved upstream")
+		if err := h.manifests.PutPin(cfg.RepoKey, name, tag, upstream); err != nil {
+			logger.WithError(err).Warn("Failed to pin tag")
+		}
+		return upstream
+	}
+	pending, found, err := h.manifests.GetPending(cfg.RepoKey, name, tag)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read pending tag move")
+	}
+	if !found || pending.Digest != upstream {
+		event.Warn("Tag moved upstream, serving pinned digest until approved")
+		if err := h.manifests.PutPending(cfg.RepoKey, name, tag, upstream); err != nil {
+			logger.WithError(err).Warn("Failed to record pending tag move")
+		}
+	}
+	return pin.Digest
+}
+
+// pinnedManifest returns manifest d of a pinned tag, from the local store or
+// else from upstream by digest.
+func (h *DockerRemoteHandler) pinnedManifest(ctx context.Context, client *oci.RegistryClient, name string, d digest.Digest, hdr http.Header) (manifests.Descriptor, []byte, error) {
+	desc, data, err := h.manifests.Get(ctx, d)
+	if errors.Is(err, manifests.ErrNotFound) {
+		return h.fetchManifest(ctx, client, name, oci.Reference{Digest: d.String()}, hdr)
+	}
+	return desc, data, err
+}
+
+// ListPins answers GET /api/pins with the tag moves awaiting approval, of
+// every docker remote or only of the one in the repoKey query parameter.
+func (h *DockerRemoteHandler) ListPins(c *gin.Context) {
+	repoKey := c.Query("repoKey")
+	if repoKey != "" {
+		if cfg, ok := h.store.Get(repoKey); !ok || cfg.PackageType != configstore.PackageTypeDocker {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown docker repoKey"})
+			return
+		}
+	}
+	pins := []pendingPin{}
+	for _, cfg := range h.store.List() {
+		if cfg.PackageType != configstore.PackageTypeDocker || cfg.IsHosted() || cfg.IsVirtual() {
+			continue
+		}
+		if repoKey != "" && cfg.RepoKey != repoKey {
+			continue
+		}
+		moved, err := h.manifests.MovedTags(cfg.RepoKey)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "failed to list pinned tags", err)
+			return
+		}
+		for _, m := range moved {
+			pins = append(pins, pendingPin{RepoKey: cfg.RepoKey, MovedTag: m})
+		}
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		a, b := pins[i], pins[j]
+		if a.RepoKey != b.RepoKey {
+			return a.RepoKey < b.RepoKey
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Tag < b.Tag
+	})
+	c.JSON(http.StatusOK, gin.H{"pins": pins})
+}
+
+// ApprovePin answers POST /api/pins/approve: the pending digest of the tag
+// becomes its pinned digest and is served from then on.
+func (h *DockerRemoteHandler) ApprovePin(c *gin.Context) {
+	var body approvePinRequest
+	if err := c.ShouldBindJSON(&body); err != nil || body.RepoKey == "" || body.Name == "" || body.Tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "repoKey, name and tag are required"})
+		return
+	}
+	cfg, ok := h.store.Get(body.RepoKey)
+	if !ok || cfg.PackageType != configstore.PackageTypeDocker {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown docker repoKey"})
+		return
+	}
+	name := normalizeName(cfg.RemoteURL, body.Name)
+	pending, found, err := h.manifests.GetPending(cfg.RepoKey, name, body.Tag)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "failed to read pending tag move", err)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no pending move for tag"})
+		return
+	}
+	pin, _, err := h.manifests.GetPin(cfg.RepoKey, name, body.Tag)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "failed to read pinned tag", err)
+		return
+	}
+	if err := h.manifests.PutPin(cfg.RepoKey, name, body.Tag, pending.Digest); err != nil {
+		writeError(c, http.StatusInternalServerError, "failed to pin tag", err)
+		return
+	}
+	if err := h.manifests.PutTag(cfg.RepoKey, name, body.Tag, pending.Digest); err != nil {
+		writeError(c, http.StatusInternalServerError, "failed to update tag", err)
+		return
+	}
+	if err := h.manifests.DeletePending(cfg.RepoKey, name, body.Tag); err != nil {
+		writeError(c, http.StatusInternalServerError, "failed to clear pending tag move", err)
+		return
+	}
+	log.WithFields(log.Fields{
+		"event":   "tag_move_approved",
+		"repoKey": cfg.RepoKey,
+		"name":    name,
+		"tag":     body.Tag,
+		"old":     pin.Digest,
+		"new":     pending.Digest,
+	}).Info("Tag move approved")
+	c.JSON(http.StatusOK, gin.H{
+		"repoKey": cfg.RepoKey,
+		"name":    name,
+		"tag":     body.Tag,
+		"pinned":  pending.Digest,
+	})
+}
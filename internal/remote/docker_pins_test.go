@@ -0,0 +1,95 @@
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinTags(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	old := reg.addImage(t, "v1", "1.0")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		PinTags:     true,
+	})
+	defer h.Close()
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, old.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+
+	// Upstream moves the tag: the pinned digest is still served.
+	moved := reg.addImage(t, "v1-rebuild", "1.0")
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodGet} {
+		w = serve(r, method, "/v2/hub/org/app/manifests/1.0", nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code, method)
+		assert.Equal(t, old.Digest.String(), w.Header().Get("Docker-Content-Digest"), method)
+	}
+
+	w = serve(r, http.MethodGet, "/api/pins", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Pins []pendingPin `json:"pins"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	if assert.Len(t, list.Pins, 1) {
+		assert.Equal(t, "hub", list.Pins[0].RepoKey)
+		assert.Equal(t, "org/app", list.Pins[0].Name)
+		assert.Equal(t, "1.0", list.Pins[0].Tag)
+		assert.Equal(t, old.Digest, list.Pins[0].Pinned)
+		assert.Equal(t, moved.Digest, list.Pins[0].Pending)
+	}
+
+	w = serve(r, http.MethodPost, "/api/pins/approve", []byte(`{"repoKey":"hub","name":"org/app","tag":"1.0"}`), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, moved.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+	w = serve(r, http.MethodGet, "/api/pins", nil, nil)
+	assert.JSONEq(t, `{"pins":[]}`, w.Body.String())
+
+	w = serve(r, http.MethodPost, "/api/pins/approve", []byte(`{"repoKey":"hub","name":"org/app","tag":"1.0"}`), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(r, http.MethodPost, "/api/pins/approve", []byte(`{"repoKey":"hub"}`), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestTagMovedWithoutPinning(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	old := reg.addImage(t, "v1", "1.0")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	defer h.Close()
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	moved := reg.addImage(t, "v1-rebuild", "1.0")
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, moved.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+
+	// The pin follows upstream, so the move is reported once.
+	pin, found, err := h.manifests.GetPin("hub", "org/app", "1.0")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, moved.Digest, pin.Digest)
+	assert.NotEqual(t, old.Digest, pin.Digest)
+	_, found, err = h.manifests.GetPending("hub", "org/app", "1.0")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
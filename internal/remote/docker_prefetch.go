@@ -0,0 +1,118 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// isIndex reports whether mediaType is a multi-platform manifest.
+func isIndex(mediaType string) bool {
+	return mediaType == v1.MediaTypeImageIndex || mediaType == oci.MediaTypeDockerManifestList
+}
+
+// platformMatches reports whether p satisfies spec, written os/arch or
+// os/arch/variant. A spec without a variant matches every variant.
+func platformMatches(spec string, p *v1.Platform) bool {
+	if p == nil {
+		return false
+	}
+	parts := strings.Split(spec, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return false
+	}
+	if parts[0] != p.OS || parts[1] != p.Architecture {
+		return false
+	}
+	return len(parts) == 2 || parts[2] == p.Variant
+}
+
+// prefetchPlatforms warms the cache with the images of an index for the
+// remote's PrefetchPlatforms, in the background, so the first pull on another
+// architecture is served locally. An index already being prefetched is
+// skipped.
+func (h *DockerRemoteHandler) prefetchPlatforms(cfg *configstore.RepoConfig, name string, desc manifests.Descriptor, data []byte) {
+	if len(cfg.PrefetchPlatforms) == 0 || cfg.Offline || !isIndex(desc.MediaType) {
+		return
+	}
+	if h.clients.Constrained(cfg) {
+		log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "digest": desc.Digest}).Info("Upstream rate limit nearly reached, platform prefetch skipped")
+		return
+	}
+	var index v1.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return
+	}
+	var wanted []v1.Descriptor
+	for _, m := range index.Manifests {
+		for _, spec := range cfg.PrefetchPlatforms {
+			if platformMatches(spec, m.Platform) {
+				wanted = append(wanted, m)
+				break
+			}
+		}
+	}
+	if len(wanted) == 0 {
+		return
+	}
+	key := cfg.RepoKey + "@" + desc.Digest.String()
+	if _, running := h.prefetching.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	cfgCopy := *cfg
+	h.goBackground(func(ctx context.Context) {
+		defer h.prefetching.Delete(key)
+		client := h.clients.Get(&cfgCopy)
+		logger := log.WithFields(log.Fields{"repoKey": cfgCopy.RepoKey, "name": name, "index": desc.Digest})
+		for _, m := range wanted {
+			if err := h.cacheImage(ctx, &cfgCopy, client, name, m.Digest); err != nil {
+				logger.WithError(err).WithField("digest", m.Digest).Warn("Failed to prefetch platform image")
+				continue
+			}
+			logger.WithFields(log.Fields{"digest": m.Digest, "platform": platformString(m.Platform)}).Info("Platform image prefetched")
+		}
+	})
+}
+
+func platformString(p *v1.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// cacheImage makes sure manifest d and the config and layers it references
+// are in the local stores, downloading what is missing from upstream.
+func (h *DockerRemoteHandler) cacheImage(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name string, d digest.Digest) error {
+	_, data, err := h.manifests.Get(ctx, d)
+	if errors.Is(err, manifests.ErrNotFound) {
+		_, data, err = h.fetchManifest(ctx, client, name, oci.Reference{Digest: d.String()}, http.Header{"Accept": {oci.ManifestAccept}})
+	}
+	if err != nil {
+		return fmt.Errorf("manifest %s: %w", d, err)
+	}
+	var m v1.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("manifest %s: %w", d, err)
+	}
+	for _, blob := range append([]v1.Descriptor{m.Config}, m.Layers...) {
+		if blob.Digest == "" {
+			continue
+		}
+		if err := h.cacheBlob(ctx, cfg, client, name, blob.Digest); err != nil {
+			return fmt.Errorf("blob %s: %w", blob.Digest, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+type testObject struct {
+	mediaType string
+	data      []byte
+}
+
+// testRegistry serves manifests by tag or digest, blobs by digest and the tag
+// list for a single repository name, counting requests per path.
+type testRegistry struct {
+	name string
+
+	mu        sync.Mutex
+	manifests map[string]testObject
+	blobs     map[string][]byte
+	hits      map[string]int
+}
+
+func newTestRegistry(name string) *testRegistry {
+	return &testRegistry{
+		name:      name,
+		manifests: map[string]testObject{},
+		blobs:     map[string][]byte{},
+		hits:      map[string]int{},
+	}
+}
+
+func (r *testRegistry) addBlob(data []byte) v1.Descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := digest.FromBytes(data)
+	r.blobs[d.String()] = data
+	return v1.Descriptor{MediaType: v1.MediaTypeImageLayer, Digest: d, Size: int64(len(data))}
+}
+
+func (r *testRegistry) addManifest(mediaType string, data []byte, tags ...string) v1.Descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := digest.FromBytes(data)
+	obj := testObject{mediaType: mediaType, data: data}
+	r.manifests[d.String()] = obj
+	for _, tag := range tags {
+		r.manifests[tag] = obj
+	}
+	return v1.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+// addImage stores a single-layer image whose content is derived from seed.
+func (r *testRegistry) addImage(t *testing.T, seed string, tags ...string) v1.Descriptor {
+	config := r.addBlob([]byte(`{"seed":"` + seed + `"}`))
+	config.MediaType = v1.MediaTypeImageConfig
+	layer := r.addBlob([]byte("layer " + seed))
+	data, err := json.Marshal(v1.Manifest{
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []v1.Descriptor{layer},
+	})
+	assert.NoError(t, err)
+	return r.addManifest(v1.MediaTypeImageManifest, data, tags...)
+}
+
+func (r *testRegistry) count(path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hits[path]
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.hits[req.URL.Path]++
+	prefix := "/v2/" + r.name + "/"
+	rest := strings.TrimPrefix(req.URL.Path, prefix)
+	var obj testObject
+	var found bool
+	switch {
+	case !strings.HasPrefix(req.URL.Path, prefix):
+	case strings.HasPrefix(rest, "manifests/"):
+		obj, found = r.manifests[strings.TrimPrefix(rest, "manifests/")]
+	case strings.HasPrefix(rest, "blobs/"):
+		var data []byte
+		data, found = r.blobs[strings.TrimPrefix(rest, "blobs/")]
+		obj = testObject{mediaType: "application/octet-stream", data: data}
+	case rest == "tags/list":
+		tags := []string{}
+		for ref := range r.manifests {
+			if _, err := digest.Parse(ref); err != nil {
+				tags = append(tags, ref)
+			}
+		}
+		sort.Strings(tags)
+		data, _ := json.Marshal(map[string]any{"name": r.name, "tags": tags})
+		obj, found = testObject{mediaType: "application/json", data: data}, true
+	}
+	r.mu.Unlock()
+	if !found {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", obj.mediaType)
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(obj.data).String())
+	w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+	if req.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(obj.data)
+}
+
+func TestPrefetchPlatforms(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	amd64 := reg.addImage(t, "amd64")
+	amd64.Platform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := reg.addImage(t, "arm64")
+	arm64.Platform = &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	s390x := reg.addImage(t, "s390x")
+	s390x.Platform = &v1.Platform{OS: "linux", Architecture: "s390x"}
+	index, err := json.Marshal(v1.Index{
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: []v1.Descriptor{amd64, arm64, s390x},
+	})
+	assert.NoError(t, err)
+	reg.addManifest(v1.MediaTypeImageIndex, index, "latest")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:           "hub",
+		PackageType:       configstore.PackageTypeDocker,
+		RemoteURL:         upstream.URL,
+		PrefetchPlatforms: []string{"linux/amd64", "linux/arm64"},
+	})
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/latest", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	h.bgWG.Wait()
+
+	ctx := t.Context()
+	for _, img := range []v1.Descriptor{amd64, arm64} {
+		_, data, err := h.manifests.Get(ctx, img.Digest)
+		assert.NoError(t, err)
+		var m v1.Manifest
+		assert.NoError(t, json.Unmarshal(data, &m))
+		if !assert.Len(t, m.Layers, 1) {
+			continue
+		}
+		for _, blob := range []v1.Descriptor{m.Config, m.Layers[0]} {
+			exists, err := h.blobs.Exists(ctx, blob.Digest)
+			assert.NoError(t, err)
+			assert.True(t, exists, "blob of %s", img.Platform.Architecture)
+		}
+	}
+	assert.Zero(t, reg.count("/v2/org/app/manifests/"+s390x.Digest.String()))
+}
+
+func TestPlatformMatches(t *testing.T) {
+	armv7 := &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	assert.True(t, platformMatches("linux/arm", armv7))
+	assert.True(t, platformMatches("linux/arm/v7", armv7))
+	assert.False(t, platformMatches("linux/arm/v6", armv7))
+	assert.False(t, platformMatches("linux", armv7))
+	assert.False(t, platformMatches("linux/arm", nil))
+}
@@ -0,0 +1,117 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+	errMultipleRanges      = errors.New("multiple ranges are not supported")
+	// errIgnoredRange marks a Range header that must be ignored rather than
+	// refused: an unknown unit or malformed syntax (RFC 9110 §14.2).
+	errIgnoredRange = errors.New("range ignored")
+)
+
+// byteRange is a single satisfiable range within a blob.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// requestedRange returns the Range header a request wants honored, or "" when
+// the whole blob must be sent. An If-Range that does not match etag voids the
+// range, as does a date-valued If-Range since blobs carry no Last-Modified.
+func requestedRange(r *http.Request, etag string) string {
+	rng := r.Header.Get("Range")
+	if rng == "" {
+		return ""
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return ""
+	}
+	return rng
+}
+
+// selectRange decides how to answer a Range header for a blob of size bytes.
+// partial is false when the whole blob should be sent with 200.
+func selectRange(header string, size int64) (rng byteRange, partial bool, err error) {
+	if header == "" {
+		return byteRange{}, false, nil
+	}
+	rng, err = parseByteRange(header, size)
+	if errors.Is(err, errIgnoredRange) {
+		return byteRange{}, false, nil
+	}
+	if err != nil {
+		return byteRange{}, false, err
+	}
+	return rng, true, nil
+}
+
+// parseByteRange parses a single-range Range header against a blob of size bytes.
+func parseByteRange(header string, size int64) (byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return byteRange{}, errIgnoredRange
+	}
+	if strings.Contains(spec, ",") {
+		return byteRange{}, errMultipleRanges
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return byteRange{}, errIgnoredRange
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+	if first == "" {
+		// Suffix range: the final N bytes.
+		n, err := strconv.ParseUint(last, 10, 63)
+		if err != nil {
+			return byteRange{}, errIgnoredRange
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, errRangeNotSatisfiable
+		}
+		return byteRange{start: size - min(int64(n), size), length: min(int64(n), size)}, nil
+	}
+	start, err := strconv.ParseUint(first, 10, 63)
+	if err != nil {
+		return byteRange{}, errIgnoredRange
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseUint(last, 10, 63)
+		if err != nil || e < start {
+			return byteRange{}, errIgnoredRange
+		}
+		end = min(int64(e), size-1)
+	}
+	if int64(start) >= size {
+		return byteRange{}, errRangeNotSatisfiable
+	}
+	return byteRange{start: int64(start), length: end - int64(start) + 1}, nil
+}
+
+// writeRangeError answers a Range header that cannot be served.
+func writeRangeError(w http.ResponseWriter, size int64) {
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+}
+
+// seekTo positions r at offset, discarding bytes when r cannot seek.
+func seekTo(r io.Reader, offset int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(offset, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, offset)
+	return err
+}
@@ -0,0 +1,36 @@
+package remote
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+)
+
+// remoteRateLimits is one remote in the answer of /api/ratelimits.
+type remoteRateLimits struct {
+	RepoKey     string                 `json:"repoKey"`
+	Constrained bool                   `json:"constrained"`
+	Reserve     int                    `json:"reserve"`
+	Credentials []oci.CredentialStatus `json:"credentials"`
+}
+
+// GetRateLimits answers GET /api/ratelimits with the upstream rate limits
+// last seen for each credential of every docker remote.
+func (h *DockerRemoteHandler) GetRateLimits(c *gin.Context) {
+	out := []remoteRateLimits{}
+	for _, cfg := range h.store.List() {
+		if cfg.PackageType != configstore.PackageTypeDocker || cfg.IsHosted() || cfg.IsVirtual() {
+			continue
+		}
+		creds := h.clients.Credentials(&cfg)
+		out = append(out, remoteRateLimits{
+			RepoKey:     cfg.RepoKey,
+			Constrained: creds.Constrained(cfg.RateLimitReserve),
+			Reserve:     cfg.RateLimitReserve,
+			Credentials: creds.Status(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"remotes": out})
+}
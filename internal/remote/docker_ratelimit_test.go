@@ -0,0 +1,77 @@
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitedRemote(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	old := reg.addImage(t, "v1", "1.0")
+	reg.addImage(t, "v2", "2.0")
+	var remaining atomic.Int32
+	remaining.Store(10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			n := remaining.Load()
+			w.Header().Set("RateLimit-Limit", "100;w=21600")
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(n))+";w=21600")
+			if n == 0 {
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			remaining.Add(-1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:          "hub",
+		PackageType:      configstore.PackageTypeDocker,
+		RemoteURL:        upstream.URL,
+		RateLimitReserve: 5,
+	})
+	defer h.Close()
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Close to the limit, a moved tag is served from cache instead of pulled.
+	remaining.Store(3)
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/2.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	reg.addImage(t, "v1-rebuild", "1.0")
+	hits := reg.count("/v2/org/app/manifests/1.0")
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/1.0", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, old.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, hits+1, reg.count("/v2/org/app/manifests/1.0"), "only the HEAD reaches upstream")
+
+	w = serve(r, http.MethodGet, "/api/ratelimits", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var limits struct {
+		Remotes []remoteRateLimits `json:"remotes"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &limits))
+	if assert.Len(t, limits.Remotes, 1) && assert.Len(t, limits.Remotes[0].Credentials, 1) {
+		assert.True(t, limits.Remotes[0].Constrained)
+		assert.Equal(t, 100, limits.Remotes[0].Credentials[0].RateLimit.Limit)
+		assert.Equal(t, 3, limits.Remotes[0].Credentials[0].RateLimit.Remaining)
+	}
+
+	// Throttled with nothing cached: the 429 is passed on, not a 502.
+	remaining.Store(0)
+	reg.addImage(t, "v3", "3.0")
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/manifests/3.0", nil, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "TOOMANYREQUESTS", registryErrorCode(t, w))
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,309 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// referrersTag is the tag the OCI fallback scheme stores the referrers index
+// of subject under, for registries without the Referrers API.
+func referrersTag(subject digest.Digest) string {
+	return subject.Algorithm().String() + "-" + subject.Encoded()
+}
+
+// GetReferrers answers GET /v2/<name>/referrers/<digest>, optionally filtered
+// by the artifactType query parameter.
+func (h *DockerRemoteHandler) GetReferrers(c *gin.Context, cfg *configstore.RepoConfig, name, ref string) {
+	subject, err := digest.Parse(ref)
+	if err != nil {
+		writeRegistryError(c, http.StatusBadRequest, codeDigestInvalid, "invalid digest")
+		return
+	}
+	var index v1.Index
+	if cfg.IsHosted() {
+		index, err = h.hostedReferrers(c.Request.Context(), cfg.RepoKey, name, subject)
+	} else {
+		index, err = h.remoteReferrers(c.Request.Context(), cfg, normalizeName(cfg.RemoteURL, name), subject)
+	}
+	if errors.Is(err, errOffline) {
+		// An empty index would claim the subject has no referrers.
+		writeRegistryError(c, http.StatusServiceUnavailable, codeUnavailable,
+			fmt.Sprintf("referrers not cached and %s is offline", cfg.RepoKey))
+		return
+	}
+	if err != nil {
+		writeUpstreamError(c, err, codeManifestUnknown, "failed to get referrers")
+		return
+	}
+	if artifactType := c.Query("artifactType"); artifactType != "" {
+		index.Manifests = filterArtifactType(index.Manifests, artifactType)
+		c.Header("OCI-Filters-Applied", "artifactType")
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		writeInternalError(c, "failed to encode referrers", err)
+		return
+	}
+	c.Data(http.StatusOK, v1.MediaTypeImageIndex, data)
+}
+
+func filterArtifactType(descs []v1.Descriptor, artifactType string) []v1.Descriptor {
+	out := []v1.Descriptor{}
+	for _, d := range descs {
+		if d.ArtifactType == artifactType {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func emptyIndex() v1.Index {
+	return v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: []v1.Descriptor{},
+	}
+}
+
+// remoteReferrers returns the referrers index of subject, cached per digest
+// for the remote's TagTTL. Upstream is asked through the Referrers API first
+// and through the sha256-<hex> tag when it does not support it. The cached
+// index is served when upstream cannot answer.
+func (h *DockerRemoteHandler) remoteReferrers(ctx context.Context, cfg *configstore.RepoConfig, name string, subject digest.Digest) (v1.Index, error) {
+	logger := log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name, "subject": subject})
+
+	var stale *v1.Index
+	link, found, err := h.manifests.GetReferrersIndex(cfg.RepoKey, name, subject)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read cached referrers")
+	}
+	if found {
+		if _, data, err := h.manifests.Get(ctx, link.Digest); err == nil {
+			var index v1.Index
+			if err := json.Unmarshal(data, &index); err == nil {
+				if cfg.Offline || time.Since(link.Checked) < cfg.TagTTL {
+					return index, nil
+				}
+				stale = &index
+			}
+		}
+	}
+
+	client := h.clients.Get(cfg)
+	index, data, err := fetchReferrers(ctx, client, name, subject)
+	if err != nil {
+		if stale != nil && upstreamUnavailable(err) {
+			logger.WithError(err).Warn("Upstream unavailable, serving stale referrers")
+			return *stale, nil
+		}
+		return v1.Index{}, err
+	}
+	desc, err := h.manifests.Put(ctx, v1.MediaTypeImageIndex, data)
+	if err != nil {
+		return v1.Index{}, err
+	}
+	if err := h.manifests.PutReferrersIndex(cfg.RepoKey, name, subject, desc.Digest); err != nil {
+		logger.WithError(err).Warn("Failed to cache referrers")
+	}
+	if len(index.Manifests) > 0 {
+		cfgCopy := *cfg
+		h.goBackground(func(ctx context.Context) {
+			h.cacheReferrerContent(ctx, &cfgCopy, client, name, index.Manifests)
+		})
+	}
+	return index, nil
+}
+
+// fetchReferrers asks upstream for the referrers of subject. A registry that
+// answers 404 on the Referrers API is asked for the fallback tag; when neither
+// exists the subject has no referrers.
+func fetchReferrers(ctx context.Context, client *oci.RegistryClient, name string, subject digest.Digest) (v1.Index, []byte, error) {
+	resp, err := client.FetchReferrers(ctx, name, subject.String(), nil)
+	if err != nil {
+		return v1.Index{}, nil, err
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Warnf("failed to close response body: %v", cerr)
+		}
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err := io.ReadAll(io.LimitReader(resp.Body, manifests.MaxManifestSize+1))
+		if err != nil {
+			return v1.Index{}, nil, fmt.Errorf("read referrers: %w", err)
+		}
+		if len(data) > manifests.MaxManifestSize {
+			return v1.Index{}, nil, fmt.Errorf("referrers index exceeds %d bytes", manifests.MaxManifestSize)
+		}
+		return decodeIndex(data)
+	case http.StatusNotFound:
+	default:
+		return v1.Index{}, nil, newUpstreamError(resp, fmt.Errorf("referrers fetch failed: %s", resp.Status))
+	}
+
+	fallback, err := client.GetManifest(ctx, name, referrersTag(subject), http.Header{"Accept": {v1.MediaTypeImageIndex}})
+	if err != nil {
+		if fallback != nil {
+			defer func() { _ = fallback.Body.Close() }()
+			if fallback.StatusCode == http.StatusNotFound {
+				index := emptyIndex()
+				data, err := json.Marshal(index)
+				return index, data, err
+			}
+			return v1.Index{}, nil, newUpstreamError(fallback, err)
+		}
+		return v1.Index{}, nil, err
+	}
+	defer func() {
+		if cerr := fallback.Body.Close(); cerr != nil {
+			log.Warnf("failed to close response body: %v", cerr)
+		}
+	}()
+	data, err := io.ReadAll(io.LimitReader(fallback.Body, manifests.MaxManifestSize+1))
+	if err != nil {
+		return v1.Index{}, nil, fmt.Errorf("read referrers tag: %w", err)
+	}
+	if len(data) > manifests.MaxManifestSize {
+		return v1.Index{}, nil, fmt.Errorf("referrers index exceeds %d bytes", manifests.MaxManifestSize)
+	}
+	return decodeIndex(data)
+}
+
+func decodeIndex(data []byte) (v1.Index, []byte, error) {
+	var index v1.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return v1.Index{}, nil, fmt.Errorf("invalid referrers index: %w", err)
+	}
+	if index.Manifests == nil {
+		index.Manifests = []v1.Descriptor{}
+	}
+	return index, data, nil
+}
+
+// cacheReferrerContent pulls each referrer manifest and the blobs it
+// references into the local stores, so signatures and SBOMs stay available
+// next to the image they describe.
+func (h *DockerRemoteHandler) cacheReferrerContent(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name string, refs []v1.Descriptor) {
+	logger := log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name})
+	for _, ref := range refs {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := h.cacheImage(ctx, cfg, client, name, ref.Digest); err != nil {
+			logger.WithError(err).WithField("digest", ref.Digest).Warn("Failed to cache referrer")
+			continue
+		}
+		logger.WithField("digest", ref.Digest).Debug("Referrer cached")
+	}
+}
+
+// hostedReferrers builds the referrers index of subject from the manifests
+// pushed to a hosted repository with a matching subject field.
+func (h *DockerRemoteHandler) hostedReferrers(ctx context.Context, repoKey, name string, subject digest.Digest) (v1.Index, error) {
+	index := emptyIndex()
+	refs, err := h.manifests.Referrers(repoKey, name, subject)
+	if err != nil {
+		return v1.Index{}, err
+	}
+	for _, d := range refs {
+		linked, err := h.manifests.HasRevision(repoKey, name, d)
+		if err != nil {
+			return v1.Index{}, err
+		}
+		if !linked {
+			continue
+		}
+		desc, data, err := h.manifests.Get(ctx, d)
+		if errors.Is(err, manifests.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return v1.Index{}, err
+		}
+		index.Manifests = append(index.Manifests, referrerDescriptor(desc, data))
+	}
+	return index, nil
+}
+
+// referrerDescriptor describes a referring manifest as an entry of a
+// referrers index, carrying its artifact type and annotations.
+func referrerDescriptor(desc manifests.Descriptor, data []byte) v1.Descriptor {
+	var m struct {
+		ArtifactType string            `json:"artifactType"`
+		Config       v1.Descriptor     `json:"config"`
+		Annotations  map[string]string `json:"annotations"`
+	}
+	_ = json.Unmarshal(data, &m)
+	artifactType := m.ArtifactType
+	if artifactType == "" {
+		artifactType = m.Config.MediaType
+	}
+	return v1.Descriptor{
+		MediaType:    desc.MediaType,
+		Digest:       desc.Digest,
+		Size:         desc.Size,
+		ArtifactType: artifactType,
+		Annotations:  m.Annotations,
+	}
+}
+
+// manifestSubject returns the subject a manifest refers to, if any.
+func manifestSubject(data []byte) (digest.Digest, bool) {
+	var m struct {
+		Subject *v1.Descriptor `json:"subject"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil || m.Subject == nil || m.Subject.Digest.Validate() != nil {
+		return "", false
+	}
+	return m.Subject.Digest, true
+}
+
+// subjectOfReferrersTag parses a fallback tag of the form <alg>-<hex>.
+func subjectOfReferrersTag(tag string) (digest.Digest, bool) {
+	alg, hex, ok := strings.Cut(tag, "-")
+	if !ok {
+		return "", false
+	}
+	d := digest.NewDigestFromEncoded(digest.Algorithm(alg), hex)
+	return d, d.Validate() == nil
+}
+
+// serveReferrersTag answers a manifest request for the fallback tag of
+// subject with the referrers index built from pushed manifests.
+func (h *DockerRemoteHandler) serveReferrersTag(c *gin.Context, repoKey, name string, subject digest.Digest) {
+	index, err := h.hostedReferrers(c.Request.Context(), repoKey, name, subject)
+	if err != nil {
+		writeInternalError(c, "failed to get referrers", err)
+		return
+	}
+	if len(index.Manifests) == 0 {
+		writeRegistryError(c, http.StatusNotFound, codeManifestUnknown, "manifest unknown to registry")
+		return
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		writeInternalError(c, "failed to encode referrers", err)
+		return
+	}
+	writeManifest(c, manifests.Descriptor{
+		MediaType: v1.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}, data)
+}
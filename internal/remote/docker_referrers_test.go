@@ -0,0 +1,149 @@
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func testReferrersIndex(t *testing.T) []byte {
+	index := emptyIndex()
+	index.Manifests = []v1.Descriptor{
+		{MediaType: v1.MediaTypeImageManifest, Digest: digest.FromString("sig"), Size: 3, ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json"},
+		{MediaType: v1.MediaTypeImageManifest, Digest: digest.FromString("sbom"), Size: 4, ArtifactType: "application/spdx+json"},
+	}
+	data, err := json.Marshal(index)
+	assert.NoError(t, err)
+	return data
+}
+
+func decodeReferrers(t *testing.T, w *httptest.ResponseRecorder) v1.Index {
+	var index v1.Index
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &index))
+	return index
+}
+
+func TestGetReferrers_Remote(t *testing.T) {
+	subject := digest.FromString(testManifest)
+	body := testReferrersIndex(t)
+	var calls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/org/app/referrers/"+subject.String(), func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", v1.MediaTypeImageIndex)
+		_, _ = w.Write(body)
+	})
+	upstream := httptest.NewServer(mux)
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		TagTTL:      time.Hour,
+	})
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/referrers/"+subject.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, v1.MediaTypeImageIndex, w.Header().Get("Content-Type"))
+	assert.Len(t, decodeReferrers(t, w).Manifests, 2)
+
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/referrers/"+subject.String()+"?artifactType="+url.QueryEscape("application/spdx+json"), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "artifactType", w.Header().Get("OCI-Filters-Applied"))
+	index := decodeReferrers(t, w)
+	assert.Len(t, index.Manifests, 1)
+	assert.Equal(t, digest.FromString("sbom"), index.Manifests[0].Digest)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// The cached index outlives the upstream.
+	h.Close()
+	upstream.Close()
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/referrers/"+subject.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeReferrers(t, w).Manifests, 2)
+
+	w = serve(r, http.MethodGet, "/v2/hub/org/app/referrers/latest", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetReferrers_TagFallback(t *testing.T) {
+	subject := digest.FromString(testManifest)
+	body := testReferrersIndex(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/org/app/manifests/"+referrersTag(subject), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", v1.MediaTypeImageIndex)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(body).String())
+		_, _ = w.Write(body)
+	})
+	upstream := httptest.NewServer(mux)
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	defer h.Close()
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/referrers/"+subject.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeReferrers(t, w).Manifests, 2)
+
+	// Neither the API nor the tag exists: no referrers.
+	w = serve(r, http.MethodGet, "/v2/hub/org/other/referrers/"+subject.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, decodeReferrers(t, w).Manifests)
+}
+
+func TestGetReferrers_Hosted(t *testing.T) {
+	r, _ := newTestHostedHandler(t)
+
+	config := []byte(`{}`)
+	w := serve(r, http.MethodPost, "/v2/internal/app/blobs/uploads/?digest="+digest.FromBytes(config).String(), config, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	image, err := json.Marshal(v1.Manifest{
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: 2},
+		Layers:    []v1.Descriptor{},
+	})
+	assert.NoError(t, err)
+	w = serve(r, http.MethodPut, "/v2/internal/app/manifests/v1", image, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	subject := digest.FromBytes(image)
+
+	sig, err := json.Marshal(v1.Manifest{
+		MediaType:    v1.MediaTypeImageManifest,
+		ArtifactType: "application/example.sig",
+		Config:       v1.DescriptorEmptyJSON,
+		Layers:       []v1.Descriptor{},
+		Subject:      &v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject, Size: int64(len(image))},
+	})
+	assert.NoError(t, err)
+	w = serve(r, http.MethodPost, "/v2/internal/app/blobs/uploads/?digest="+v1.DescriptorEmptyJSON.Digest.String(), v1.DescriptorEmptyJSON.Data, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(r, http.MethodPut, "/v2/internal/app/manifests/"+digest.FromBytes(sig).String(), sig, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, subject.String(), w.Header().Get("OCI-Subject"))
+
+	w = serve(r, http.MethodGet, "/v2/internal/app/referrers/"+subject.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	index := decodeReferrers(t, w)
+	assert.Len(t, index.Manifests, 1)
+	assert.Equal(t, digest.FromBytes(sig), index.Manifests[0].Digest)
+	assert.Equal(t, "application/example.sig", index.Manifests[0].ArtifactType)
+
+	// The fallback tag resolves to the same index.
+	w = serve(r, http.MethodGet, "/v2/internal/app/manifests/"+referrersTag(subject), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, v1.MediaTypeImageIndex, w.Header().Get("Content-Type"))
+
+	w = serve(r, http.MethodHead, "/v2/internal/app/referrers/"+subject.String(), nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
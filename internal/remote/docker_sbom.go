@@ -0,0 +1,219 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	"github.com/martencassel/gobinrepo/internal/util/sbom"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// mediaTypeDockerImageConfig is the config media type of Docker schema 2
+// images.
+const mediaTypeDockerImageConfig = "application/vnd.docker.container.image.v1+json"
+
+// layersMissingError means an inventory cannot be built yet because some of
+// the image's layers are not cached.
+type layersMissingError struct {
+	Missing, Total int
+}
+
+func (e *layersMissingError) Error() string {
+	return fmt.Sprintf("%d of %d layers not cached", e.Missing, e.Total)
+}
+
+// containerImage decodes manifest data and reports whether it is a
+// container image, whose layers are filesystem archives, rather than an
+// artifact such as a signature or a Helm chart.
+func containerImage(data []byte) (oci.OCIManifest, bool) {
+	var m oci.OCIManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, false
+	}
+	return m, m.Config.MediaType == v1.MediaTypeImageConfig || m.Config.MediaType == mediaTypeDockerImageConfig
+}
+
+// inventoryImage records the package inventory of image manifest desc when
+// cfg has SBOM enabled, in the background. A remote caches the layers first;
+// a client pulling them at the same time shares the downloads.
+func (h *DockerRemoteHandler) inventoryImage(cfg *configstore.RepoConfig, name string, desc manifests.Descriptor, data []byte) {
+	if !cfg.SBOM || isIndex(desc.MediaType) || h.manifests.HasInventory(desc.Digest) {
+		return
+	}
+	if _, ok := containerImage(data); !ok {
+		return
+	}
+	if !cfg.IsHosted() && h.clients.Constrained(cfg) {
+		log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "digest": desc.Digest}).Info("Upstream rate limit nearly reached, package inventory skipped")
+		return
+	}
+	if _, running := h.inventorying.LoadOrStore(desc.Digest, struct{}{}); running {
+		return
+	}
+	cfgCopy := *cfg
+	h.goBackground(func(ctx context.Context) {
+		defer h.inventorying.Delete(desc.Digest)
+		logger := log.WithFields(log.Fields{"repoKey": cfgCopy.RepoKey, "name": name, "digest": desc.Digest})
+		if !cfgCopy.IsHosted() {
+			if err := h.cacheImage(ctx, &cfgCopy, h.clients.Get(&cfgCopy), name, desc.Digest); err != nil {
+				logger.WithError(err).Warn("Failed to cache image for package inventory")
+				return
+			}
+		}
+		inv, err := h.buildInventory(ctx, desc.Digest)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to build package inventory")
+			return
+		}
+		logger.WithField("packages", len(inv.Packages)).Info("Package inventory recorded")
+	})
+}
+
+// inventory returns the stored package inventory of image manifest d, or
+// builds it from the cached layers.
+func (h *DockerRemoteHandler) inventory(ctx context.Context, d digest.Digest) (*sbom.Inventory, error) {
+	raw, err := h.manifests.GetInventory(d)
+	if errors.Is(err, manifests.ErrNoInventory) {
+		return h.buildInventory(ctx, d)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var inv sbom.Inventory
+	if err := json.Unmarshal(raw, &inv); err != nil {
+		return nil, fmt.Errorf("stored inventory of %s: %w", d, err)
+	}
+	return &inv, nil
+}
+
+// buildInventory scans the cached layers of image manifest d for package
+// databases and stores the inventory next to the manifest.
+func (h *DockerRemoteHandler) buildInventory(ctx context.Context, d digest.Digest) (*sbom.Inventory, error) {
+	_, data, err := h.manifests.Get(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := containerImage(data)
+	if !ok {
+		return nil, fmt.Errorf("manifest %s is not a container image", d)
+	}
+	missing := 0
+	for _, l := range m.Layers {
+		if exists, err := h.blobs.Exists(ctx, digest.Digest(l.Digest)); err != nil {
+			return nil, err
+		} else if !exists {
+			missing++
+		}
+	}
+	if missing > 0 {
+		return nil, &layersMissingError{Missing: missing, Total: len(m.Layers)}
+	}
+
+	scanner := sbom.NewScanner()
+	for _, l := range m.Layers {
+		rc, err := h.blobs.Get(ctx, digest.Digest(l.Digest))
+		if err != nil {
+			return nil, err
+		}
+		err = scanner.AddLayer(rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", l.Digest, err)
+		}
+	}
+	inv := scanner.Inventory(d)
+	raw, err := json.Marshal(inv)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.manifests.PutInventory(d, raw); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// GetSBOM answers GET /api/docker/:repoKey/sbom/<name>[:tag|@digest] with
+// the packages installed in an image, as SPDX (the default), CycloneDX or
+// the stored inventory, chosen with the format query parameter. An index
+// needs a platform query parameter selecting one of its images. The
+// inventory is built from cached layers only; an image that is not fully
+// cached is a conflict until it is pulled or prefetched.
+func (h *DockerRemoteHandler) GetSBOM(c *gin.Context) {
+	format := c.DefaultQuery("format", "spdx")
+	if format != "spdx" && format != "cyclonedx" && format != "inventory" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q: format must be spdx, cyclonedx or inventory", format)})
+		return
+	}
+	ref, member, desc, data, ok := h.resolveImageRequest(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	if isIndex(desc.MediaType) {
+		var index v1.Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			writeError(c, http.StatusBadGateway, "invalid image index", err)
+			return
+		}
+		var wanted []v1.Descriptor
+		for _, m := range index.Manifests {
+			if wantPlatform(c.QueryArray("platform"), m.Platform) {
+				wanted = append(wanted, m)
+			}
+		}
+		if len(wanted) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("index matches %d images; select one with ?platform=os/arch", len(wanted))})
+			return
+		}
+		var err error
+		desc, data, err = h.memberManifest(ctx, &member, ref.Name, wanted[0].Digest.String(), imageAccept())
+		if err != nil {
+			writeError(c, http.StatusBadGateway, "failed to resolve platform image", err)
+			return
+		}
+	}
+	if _, ok := containerImage(data); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a container image"})
+		return
+	}
+
+	inv, err := h.inventory(ctx, desc.Digest)
+	var lm *layersMissingError
+	switch {
+	case errors.As(err, &lm):
+		writeError(c, http.StatusConflict, "image not fully cached: "+lm.Error(), err)
+		return
+	case err != nil:
+		writeError(c, http.StatusInternalServerError, "failed to build package inventory", err)
+		return
+	}
+
+	name := ref.RepoKey + "/" + ref.Name + "@" + desc.Digest.String()
+	switch format {
+	case "spdx":
+		doc, err := inv.SPDX(name)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "failed to render SPDX document", err)
+			return
+		}
+		c.Data(http.StatusOK, sbom.ContentTypeSPDX, doc)
+	case "cyclonedx":
+		doc, err := inv.CycloneDX(name)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "failed to render CycloneDX document", err)
+			return
+		}
+		c.Data(http.StatusOK, sbom.ContentTypeCycloneDX, doc)
+	default:
+		c.JSON(http.StatusOK, inv)
+	}
+}
@@ -0,0 +1,121 @@
+package remote
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/sbom"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addDebianImage stores an image whose single layer holds a dpkg database
+// listing libc6, and an index pointing at it for linux/amd64.
+func addDebianImage(t *testing.T, reg *testRegistry, tag string) (index, image v1.Descriptor) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, content := range map[string]string{
+		"etc/os-release":      "ID=debian\nVERSION_ID=\"12\"\n",
+		"var/lib/dpkg/status": "Package: libc6\nStatus: install ok installed\nArchitecture: amd64\nVersion: 2.36-9\n",
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+
+	config := reg.addBlob([]byte(`{"architecture":"amd64","os":"linux"}`))
+	config.MediaType = v1.MediaTypeImageConfig
+	layer := reg.addBlob(buf.Bytes())
+	data, err := json.Marshal(v1.Manifest{
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []v1.Descriptor{layer},
+	})
+	require.NoError(t, err)
+	image = reg.addManifest(v1.MediaTypeImageManifest, data)
+	image.Platform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+	attestation := reg.addImage(t, "attestation")
+	attestation.Platform = &v1.Platform{OS: "unknown", Architecture: "unknown"}
+	arm64 := reg.addImage(t, "arm64")
+	arm64.Platform = &v1.Platform{OS: "linux", Architecture: "arm64"}
+	indexData, err := json.Marshal(v1.Index{
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: []v1.Descriptor{image, arm64, attestation},
+	})
+	require.NoError(t, err)
+	return reg.addManifest(v1.MediaTypeImageIndex, indexData, tag), image
+}
+
+func TestGetSBOM(t *testing.T) {
+	reg := newTestRegistry("library/debian")
+	_, image := addDebianImage(t, reg, "12")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	w := serve(r, http.MethodGet, "/api/docker/hub/sbom/library/debian:12", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "index without a platform")
+	w = serve(r, http.MethodGet, "/api/docker/hub/sbom/library/debian:12?platform=linux/amd64", nil, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "layers not cached")
+
+	var m v1.Manifest
+	w = serve(r, http.MethodGet, "/v2/hub/library/debian/manifests/"+image.Digest.String(), nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+	w = serve(r, http.MethodGet, "/v2/hub/library/debian/blobs/"+m.Layers[0].Digest.String(), nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(r, http.MethodGet, "/api/docker/hub/sbom/library/debian:12?platform=linux/amd64&format=cyclonedx", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, sbom.ContentTypeCycloneDX, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "pkg:deb/debian/libc6@2.36-9?arch=amd64&distro=debian-12")
+
+	w = serve(r, http.MethodGet, "/api/docker/hub/sbom/library/debian@"+image.Digest.String()+"?format=inventory", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var inv sbom.Inventory
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &inv))
+	assert.Equal(t, image.Digest, inv.Image)
+	assert.Equal(t, []sbom.Package{{Type: sbom.TypeDeb, Name: "libc6", Version: "2.36-9", Architecture: "amd64"}}, inv.Packages)
+}
+
+func TestInventoryOnPull(t *testing.T) {
+	reg := newTestRegistry("library/debian")
+	_, image := addDebianImage(t, reg, "12")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		SBOM:        true,
+	})
+	w := serve(r, http.MethodGet, "/v2/hub/library/debian/manifests/12", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	h.bgWG.Wait()
+	assert.False(t, h.manifests.HasInventory(image.Digest), "indexes have no inventory")
+
+	w = serve(r, http.MethodGet, "/v2/hub/library/debian/manifests/"+image.Digest.String(), nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	h.bgWG.Wait()
+	require.True(t, h.manifests.HasInventory(image.Digest))
+
+	w = serve(r, http.MethodGet, "/api/docker/hub/sbom/library/debian:12?platform=linux/amd64", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, sbom.ContentTypeSPDX, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"name": "libc6"`)
+}
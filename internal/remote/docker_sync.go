@@ -0,0 +1,226 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultSyncInterval applies to sync rules without an interval.
+const defaultSyncInterval = time.Hour
+
+// tagSelector is a compiled SyncRule.
+type tagSelector struct {
+	rule   configstore.SyncRule
+	tags   *regexp.Regexp
+	semver *semver.Constraints
+}
+
+func compileSyncRule(rule configstore.SyncRule) (*tagSelector, error) {
+	if len(rule.Repositories) == 0 {
+		return nil, errors.New("no repositories")
+	}
+	sel := &tagSelector{rule: rule}
+	if rule.Tags != "" {
+		re, err := regexp.Compile(rule.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tags pattern: %w", err)
+		}
+		sel.tags = re
+	}
+	if rule.Semver != "" {
+		c, err := semver.NewConstraint(rule.Semver)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver constraint: %w", err)
+		}
+		sel.semver = c
+	}
+	return sel, nil
+}
+
+// selectTags returns the tags matching the rule, newest version first. Tags
+// that are not versions sort after all versions; with a semver constraint
+// they never match.
+func (s *tagSelector) selectTags(tags []string) []string {
+	type match struct {
+		tag     string
+		version *semver.Version
+	}
+	var matches []match
+	for _, tag := range tags {
+		if s.tags != nil && !s.tags.MatchString(tag) {
+			continue
+		}
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			v = nil
+		}
+		if s.semver != nil && (v == nil || !s.semver.Check(v)) {
+			continue
+		}
+		matches = append(matches, match{tag: tag, version: v})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i].version, matches[j].version
+		switch {
+		case a != nil && b != nil:
+			if !a.Equal(b) {
+				return a.GreaterThan(b)
+			}
+		case a != nil || b != nil:
+			return a != nil
+		}
+		return matches[i].tag > matches[j].tag
+	})
+	if s.rule.Max > 0 && len(matches) > s.rule.Max {
+		matches = matches[:s.rule.Max]
+	}
+	out := make([]string, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, m.tag)
+	}
+	return out
+}
+
+// StartSync validates the sync rules of every docker remote and runs each one
+// in the background, once right away and then every interval, until Close.
+func (h *DockerRemoteHandler) StartSync() error {
+	type job struct {
+		cfg configstore.RepoConfig
+		sel *tagSelector
+	}
+	var jobs []job
+	for _, cfg := range h.store.List() {
+		if cfg.PackageType != configstore.PackageTypeDocker || len(cfg.Sync) == 0 {
+			continue
+		}
+		if cfg.IsHosted() || cfg.IsVirtual() {
+			return fmt.Errorf("%s: sync rules need a remote repository", cfg.RepoKey)
+		}
+		if cfg.Offline {
+			log.WithField("repoKey", cfg.RepoKey).Info("Remote is offline, tag sync disabled")
+			continue
+		}
+		for i, rule := range cfg.Sync {
+			sel, err := compileSyncRule(rule)
+			if err != nil {
+				return fmt.Errorf("%s: sync rule %d: %w", cfg.RepoKey, i+1, err)
+			}
+			jobs = append(jobs, job{cfg: cfg, sel: sel})
+		}
+	}
+	for _, j := range jobs {
+		h.goBackground(func(ctx context.Context) { h.runSync(ctx, &j.cfg, j.sel) })
+	}
+	return nil
+}
+
+func (h *DockerRemoteHandler) runSync(ctx context.Context, cfg *configstore.RepoConfig, sel *tagSelector) {
+	interval := sel.rule.Interval
+	if interval <= 0 {
+		interval = defaultSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, repo := range sel.rule.Repositories {
+			if err := h.syncRepository(ctx, cfg, sel, repo); err != nil && ctx.Err() == nil {
+				log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": repo}).WithError(err).Warn("Tag sync failed")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncRepository lists the tags of repo upstream and pulls the selected ones
+// into the cache, logging tags that are new or moved since the last sync.
+func (h *DockerRemoteHandler) syncRepository(ctx context.Context, cfg *configstore.RepoConfig, sel *tagSelector, repo string) error {
+	name := normalizeName(cfg.RemoteURL, repo)
+	client := h.clients.Get(cfg)
+	logger := log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name})
+
+	tags, err := fetchTags(ctx, client, name, nil)
+	if err != nil {
+		return fmt.Errorf("list tags: %w", err)
+	}
+	wanted := sel.selectTags(tags)
+	var added, updated int
+	var errs []error
+	for i, tag := range wanted {
+		if h.clients.Constrained(cfg) {
+			logger.WithField("remaining", len(wanted)-i).Warn("Upstream rate limit nearly reached, tag sync paused")
+			break
+		}
+		old, found, err := h.manifests.GetTag(cfg.RepoKey, name, tag)
+		if err != nil {
+			logger.WithError(err).WithField("tag", tag).Warn("Failed to read cached tag")
+		}
+		d, err := h.syncTag(ctx, cfg, client, name, tag)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tag %s: %w", tag, err))
+			continue
+		}
+		switch {
+		case !found:
+			added++
+			logger.WithFields(log.Fields{"tag": tag, "digest": d}).Info("Sync pulled new tag")
+		case old.Digest != d:
+			updated++
+			logger.WithFields(log.Fields{"tag": tag, "old": old.Digest, "new": d}).Info("Sync pulled moved tag")
+		}
+	}
+	logger.WithFields(log.Fields{
+		"listed":  len(tags),
+		"matched": len(wanted),
+		"added":   added,
+		"updated": updated,
+		"failed":  len(errs),
+	}).Info("Tag sync finished")
+	return errors.Join(errs...)
+}
+
+// syncTag revalidates tag upstream regardless of the remote's TagTTL and
+// caches the image behind it, if it passes the signature policy. For an
+// index, the images of the remote's PrefetchPlatforms are cached, or all of
+// them when none are configured.
+func (h *DockerRemoteHandler) syncTag(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name, tag string) (digest.Digest, error) {
+	fresh := *cfg
+	fresh.TagTTL = 0
+	desc, data, err := h.resolveTag(ctx, &fresh, client, name, tag, http.Header{"Accept": {oci.ManifestAccept}})
+	if err != nil {
+		return "", err
+	}
+	if err := h.verifyManifest(ctx, cfg, name, desc, data); err != nil {
+		return "", err
+	}
+	if !isIndex(desc.MediaType) {
+		return desc.Digest, h.cacheImage(ctx, cfg, client, name, desc.Digest)
+	}
+	var index v1.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return "", fmt.Errorf("invalid index %s: %w", desc.Digest, err)
+	}
+	var errs []error
+	for _, m := range index.Manifests {
+		if wantPlatform(cfg.PrefetchPlatforms, m.Platform) {
+			errs = append(errs, h.cacheImage(ctx, cfg, client, name, m.Digest))
+		}
+	}
+	return desc.Digest, errors.Join(errs...)
+}
@@ -0,0 +1,80 @@
+package remote
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectTags(t *testing.T) {
+	tags := []string{"latest", "v2.7.0", "v2.10.0", "v2.8.1", "v2.10.0-rc1", "v2.9.3"}
+
+	sel, err := compileSyncRule(configstore.SyncRule{Repositories: []string{"app"}, Semver: ">=2.8", Max: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v2.10.0", "v2.9.3"}, sel.selectTags(tags))
+
+	sel, err = compileSyncRule(configstore.SyncRule{Repositories: []string{"app"}, Tags: `^v2\.(7|10)`})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v2.10.0", "v2.10.0-rc1", "v2.7.0"}, sel.selectTags(tags))
+
+	_, err = compileSyncRule(configstore.SyncRule{Repositories: []string{"app"}, Tags: "("})
+	assert.Error(t, err)
+	_, err = compileSyncRule(configstore.SyncRule{Repositories: []string{"app"}, Semver: "not a version"})
+	assert.Error(t, err)
+	_, err = compileSyncRule(configstore.SyncRule{Tags: ".*"})
+	assert.Error(t, err)
+}
+
+func TestSyncRepository(t *testing.T) {
+	reg := newTestRegistry("library/postgres")
+	for _, tag := range []string{"15.4", "16.0", "16.1", "latest"} {
+		reg.addImage(t, tag, tag)
+	}
+	old := reg.addImage(t, "16.2", "16.2")
+	upstream := httptest.NewServer(reg)
+	defer upstream.Close()
+
+	cfg := configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		TagTTL:      time.Hour,
+	}
+	_, h := newTestDockerHandler(t, cfg)
+	defer h.Close()
+	sel, err := compileSyncRule(configstore.SyncRule{Repositories: []string{"library/postgres"}, Tags: `^16\.`, Max: 2})
+	assert.NoError(t, err)
+
+	ctx := t.Context()
+	assert.NoError(t, h.syncRepository(ctx, &cfg, sel, "library/postgres"))
+	for tag, want := range map[string]bool{"16.2": true, "16.1": true, "16.0": false, "latest": false} {
+		_, found, err := h.manifests.GetTag("hub", "library/postgres", tag)
+		assert.NoError(t, err)
+		assert.Equal(t, want, found, tag)
+	}
+	exists, err := h.blobs.Exists(ctx, reg.addBlob([]byte("layer 16.2")).Digest)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// A moved tag is pulled again despite the TagTTL.
+	moved := reg.addImage(t, "16.2-rebuild", "16.2")
+	assert.NoError(t, h.syncRepository(ctx, &cfg, sel, "library/postgres"))
+	link, _, err := h.manifests.GetTag("hub", "library/postgres", "16.2")
+	assert.NoError(t, err)
+	assert.NotEqual(t, old.Digest, link.Digest)
+	assert.Equal(t, moved.Digest, link.Digest)
+}
+
+func TestStartSync_RejectsInvalidRule(t *testing.T) {
+	_, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   "http://127.0.0.1:1",
+		Sync:        []configstore.SyncRule{{Repositories: []string{"app"}, Semver: "~>"}},
+	})
+	defer h.Close()
+	assert.ErrorContains(t, h.StartSync(), "hub: sync rule 1")
+}
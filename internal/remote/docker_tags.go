@@ -0,0 +1,153 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// tagRevalidateTimeout bounds how long a stale tag waits on upstream before
+// the last known digest is served instead.
+const tagRevalidateTimeout = 10 * time.Second
+
+// upstreamError carries the status of a failed upstream response.
+type upstreamError struct {
+	StatusCode int
+	Err        error
+	// RetryAfter is how long upstream asked to wait, for 429 answers.
+	RetryAfter time.Duration
+	// Errors are the entries of upstream's error body, if it had one.
+	Errors []registryErrorEntry
+}
+
+func (e *upstreamError) Error() string { return e.Err.Error() }
+func (e *upstreamError) Unwrap() error { return e.Err }
+
+// upstreamUnavailable reports whether err means upstream could not answer,
+// as opposed to answering authoritatively (e.g. 404 for a deleted tag).
+func upstreamUnavailable(err error) bool {
+	var ue *upstreamError
+	if errors.As(err, &ue) {
+		return ue.StatusCode >= http.StatusInternalServerError || ue.StatusCode == http.StatusTooManyRequests
+	}
+	return err != nil
+}
+
+// resolveTag returns the manifest a tag points to in a media type the client
+// accepts: its current resolution when acceptable, and otherwise the variant
+// upstream serves for the client's Accept list.
+func (h *DockerRemoteHandler) resolveTag(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name, tag string, hdr http.Header) (manifests.Descriptor, []byte, error) {
+	accept := acceptedTypes(hdr)
+	desc, data, err := h.resolveCurrentTag(ctx, cfg, client, name, tag, canonicalHeaders(hdr))
+	if err != nil || oci.Acceptable(accept, desc.MediaType) {
+		return desc, data, err
+	}
+	return h.resolveTagVariant(ctx, cfg, client, name, tag, accept, hdr)
+}
+
+// resolveCurrentTag returns the manifest a tag currently points to. A cached
+// resolution younger than the remote's TagTTL is served directly; older ones
+// are revalidated with a HEAD request and re-fetched only when the digest
+// changed. When upstream errors or times out, or the remote is offline, the
+// last known digest is served.
+func (h *DockerRemoteHandler) resolveCurrentTag(ctx context.Context, cfg *configstore.RepoConfig, client *oci.RegistryClient, name, tag string, hdr http.Header) (manifests.Descriptor, []byte, error) {
+	logger := log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name, "tag": tag})
+
+	link, found, err := h.manifests.GetTag(cfg.RepoKey, name, tag)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read cached tag")
+	}
+	var stale manifests.Descriptor
+	var staleData []byte
+	if found {
+		desc, data, err := h.manifests.Get(ctx, link.Digest)
+		switch {
+		case err == nil:
+			if cfg.Offline || time.Since(link.Checked) < cfg.TagTTL {
+				logger.WithField("digest", desc.Digest).Debug("Tag served from cache")
+				return desc, data, nil
+			}
+			stale, staleData = desc, data
+		case !errors.Is(err, manifests.ErrNotFound):
+			logger.WithError(err).Warn("Failed to read cached manifest for tag")
+		}
+	}
+
+	if staleData != nil {
+		current, err := h.headTagDigest(ctx, client, name, tag, hdr)
+		if err == nil && current != "" {
+			current = h.trackTag(cfg, name, tag, current)
+		}
+		switch {
+		case err == nil && current == stale.Digest:
+			if err := h.manifests.PutTag(cfg.RepoKey, name, tag, current); err != nil {
+				logger.WithError(err).Warn("Failed to refresh cached tag")
+			}
+			logger.WithField("digest", current).Debug("Tag revalidated upstream")
+			return stale, staleData, nil
+		case upstreamUnavailable(err):
+			logger.WithError(err).Warn("Upstream unavailable, serving stale tag")
+			return stale, staleData, nil
+		}
+	}
+
+	if staleData != nil && h.clients.Constrained(cfg) {
+		logger.Warn("Upstream rate limit nearly reached, serving stale tag")
+		return stale, staleData, nil
+	}
+	desc, data, err := h.fetchManifest(ctx, client, name, oci.Reference{Tag: tag}, hdr)
+	if err != nil {
+		if staleData != nil && upstreamUnavailable(err) {
+			logger.WithError(err).Warn("Upstream unavailable, serving stale tag")
+			return stale, staleData, nil
+		}
+		return manifests.Descriptor{}, nil, err
+	}
+	if pinned := h.trackTag(cfg, name, tag, desc.Digest); pinned != desc.Digest {
+		if staleData != nil && stale.Digest == pinned {
+			desc, data = stale, staleData
+		} else if desc, data, err = h.pinnedManifest(ctx, client, name, pinned, hdr); err != nil {
+			return manifests.Descriptor{}, nil, err
+		}
+	}
+	if err := h.manifests.PutTag(cfg.RepoKey, name, tag, desc.Digest); err != nil {
+		logger.WithError(err).Warn("Failed to cache tag")
+	}
+	if staleData != nil && stale.Digest != desc.Digest {
+		logger.WithFields(log.Fields{"old": stale.Digest, "new": desc.Digest}).Info("Tag updated from upstream")
+	}
+	return desc, data, nil
+}
+
+// headTagDigest asks upstream which digest a tag currently points to. An empty
+// digest with a nil error means upstream answered without one.
+func (h *DockerRemoteHandler) headTagDigest(ctx context.Context, client *oci.RegistryClient, name, tag string, hdr http.Header) (digest.Digest, error) {
+	ctx, cancel := context.WithTimeout(ctx, tagRevalidateTimeout)
+	defer cancel()
+	resp, err := client.HeadManifest(ctx, name, tag, hdr)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Warnf("failed to close response body: %v", cerr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", newUpstreamError(resp, fmt.Errorf("manifest head failed: %s", resp.Status))
+	}
+	// Registries that omit the digest on HEAD leave the caller to fall back to GET.
+	d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return "", nil
+	}
+	return d, nil
+}
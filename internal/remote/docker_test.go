@@ -0,0 +1,478 @@
+package remote
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/uploads"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const testManifest = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":2,"digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},"layers":[]}`
+
+// fakeRegistry serves a single manifest under every tag and digest and counts requests.
+type fakeRegistry struct {
+	gets  atomic.Int32
+	heads atomic.Int32
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d := digest.FromString(testManifest)
+	w.Header().Set("Content-Type", v1.MediaTypeImageManifest)
+	w.Header().Set("Docker-Content-Digest", d.String())
+	if r.Header.Get("If-None-Match") != "" {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		f.heads.Add(1)
+		return
+	}
+	f.gets.Add(1)
+	_, _ = w.Write([]byte(testManifest))
+}
+
+func newTestDockerHandler(t *testing.T, cfg configstore.RepoConfig) (*gin.Engine, *DockerRemoteHandler) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	bfs, err := blobs.NewBlobStoreFS(filepath.Join(dir, "blobs"))
+	assert.NoError(t, err)
+	ms, err := manifests.NewStore(bfs, filepath.Join(dir, "manifests"))
+	assert.NoError(t, err)
+	ups, err := uploads.NewStore(filepath.Join(dir, "uploads"))
+	assert.NoError(t, err)
+	store := configstore.NewRepoConfigStore()
+	store.Add(cfg)
+	h := NewDockerRemoteHandler(bfs, ms, ups, store, false)
+	r := gin.New()
+	h.RegisterRoutes(r)
+	return r, h
+}
+
+func TestGetManifest_TagCache(t *testing.T) {
+	fake := &fakeRegistry{}
+	upstream := httptest.NewServer(fake)
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		TagTTL:      time.Hour,
+	})
+
+	pull := func(ref string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/hub/org/app/manifests/"+ref, nil))
+		return w
+	}
+
+	w := pull("latest")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testManifest, w.Body.String())
+	assert.Equal(t, digest.FromString(testManifest).String(), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, v1.MediaTypeImageManifest, w.Header().Get("Content-Type"))
+	assert.Equal(t, int32(1), fake.gets.Load())
+
+	// Within the TTL neither the tag nor the digest goes upstream.
+	assert.Equal(t, http.StatusOK, pull("latest").Code)
+	assert.Equal(t, http.StatusOK, pull(digest.FromString(testManifest).String()).Code)
+	assert.Equal(t, int32(1), fake.gets.Load())
+	assert.Equal(t, int32(0), fake.heads.Load())
+}
+
+func TestGetManifest_ServeStale(t *testing.T) {
+	fake := &fakeRegistry{}
+	upstream := httptest.NewServer(fake)
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+
+	pull := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/hub/org/app/manifests/latest", nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, pull().Code)
+	// A zero TTL revalidates with HEAD instead of downloading again.
+	assert.Equal(t, http.StatusOK, pull().Code)
+	assert.Equal(t, int32(1), fake.gets.Load())
+	assert.Equal(t, int32(1), fake.heads.Load())
+
+	upstream.Close()
+	w := pull()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testManifest, w.Body.String())
+}
+
+func TestGetManifest_IfNoneMatch(t *testing.T) {
+	fake := &fakeRegistry{}
+	upstream := httptest.NewServer(fake)
+	defer upstream.Close()
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		TagTTL:      time.Hour,
+	})
+	etag := `"` + digest.FromString(testManifest).String() + `"`
+	pull := func(inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v2/hub/org/app/manifests/latest", nil)
+		req.Header.Set("If-None-Match", inm)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The conditional header is not forwarded, so the manifest still gets
+	// fetched and cached, and the match is answered locally.
+	w := pull(etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, int32(1), fake.gets.Load())
+
+	w = pull(`"sha256:other"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testManifest, w.Body.String())
+	assert.Equal(t, int32(1), fake.gets.Load())
+}
+
+func TestHeadManifest(t *testing.T) {
+	fake := &fakeRegistry{}
+	upstream := httptest.NewServer(fake)
+	defer upstream.Close()
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		TagTTL:      time.Hour,
+	})
+	head := func(ref string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/v2/hub/org/app/manifests/"+ref, nil))
+		return w
+	}
+
+	// Not cached yet: answered by an upstream HEAD without downloading.
+	w := head("latest")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, digest.FromString(testManifest).String(), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, int32(0), fake.gets.Load())
+	assert.Equal(t, int32(1), fake.heads.Load())
+
+	get := httptest.NewRecorder()
+	r.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/v2/hub/org/app/manifests/latest", nil))
+	assert.Equal(t, http.StatusOK, get.Code)
+
+	// Cached: answered locally with the spec headers.
+	w = head("latest")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, v1.MediaTypeImageManifest, w.Header().Get("Content-Type"))
+	assert.Equal(t, strconv.Itoa(len(testManifest)), w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, int32(1), fake.heads.Load())
+}
+
+func TestHeadManifestWithoutUpstreamDigest(t *testing.T) {
+	reg := newTestRegistry("org/app")
+	image := reg.addImage(t, "v1", "latest")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// Some registries answer HEAD without Docker-Content-Digest.
+			w.Header().Set("Content-Type", v1.MediaTypeImageManifest)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+
+	w := serve(r, http.MethodHead, "/v2/hub/org/app/manifests/latest", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, image.Digest.String(), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, 1, reg.count("/v2/org/app/manifests/latest"), "fell back to GET")
+}
+
+func TestHeadBlob(t *testing.T) {
+	cached := []byte("cached layer")
+	remote := []byte("remote layer")
+	var heads atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		heads.Add(1)
+		if r.Method != http.MethodHead || !strings.HasSuffix(r.URL.Path, digest.FromBytes(remote).String()) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(remote)))
+	}))
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	assert.NoError(t, h.blobs.Put(t.Context(), digest.FromBytes(cached), bytes.NewReader(cached)))
+	head := func(d digest.Digest) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/v2/hub/org/app/blobs/"+d.String(), nil))
+		return w
+	}
+
+	// Cached: answered locally.
+	w := head(digest.FromBytes(cached))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, strconv.Itoa(len(cached)), w.Header().Get("Content-Length"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, digest.FromBytes(cached).String(), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, int32(0), heads.Load())
+
+	// Not cached: answered by an upstream HEAD.
+	w = head(digest.FromBytes(remote))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, strconv.Itoa(len(remote)), w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, int32(1), heads.Load())
+
+	// Upstream 404 is passed through.
+	w = head(digest.FromString("missing"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleV2_UnknownPaths(t *testing.T) {
+	r, _ := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   "http://127.0.0.1:1",
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/v2/hub/org/app/tags/list", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/v2/hub/org/app/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   byteRange
+		err    error
+	}{
+		{"bytes=0-9", byteRange{0, 10}, nil},
+		{"bytes=90-", byteRange{90, 10}, nil},
+		{"bytes=-5", byteRange{95, 5}, nil},
+		{"bytes=50-500", byteRange{50, 50}, nil},
+		{"bytes=100-", byteRange{}, errRangeNotSatisfiable},
+		{"bytes=-0", byteRange{}, errRangeNotSatisfiable},
+		{"bytes=9-3", byteRange{}, errIgnoredRange},
+		{"bytes=a-3", byteRange{}, errIgnoredRange},
+		{"items=0-1", byteRange{}, errIgnoredRange},
+		{"bytes=0-1,5-6", byteRange{}, errMultipleRanges},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseByteRange(tt.header, 100)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetBlob_RedirectStripsAuth(t *testing.T) {
+	content := []byte("layer stored elsewhere")
+	d := digest.FromBytes(content)
+	var storageAuth atomic.Value
+	storageAuth.Store("")
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageAuth.Store(r.Header.Get("Authorization"))
+		_, _ = w.Write(content)
+	}))
+	defer storage.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "pw" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, storage.URL+"/blobs/"+d.Encoded(), http.StatusTemporaryRedirect)
+	}))
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+		Username:    "alice",
+		Password:    "pw",
+	})
+	defer h.Close()
+
+	w := serve(r, http.MethodGet, "/v2/hub/org/app/blobs/"+d.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.Bytes())
+	assert.Empty(t, storageAuth.Load(), "upstream credentials must not follow the redirect")
+}
+
+func TestGetBlob_Range(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	d := digest.FromBytes(content)
+	var upstreamRange atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRange.Store(r.Header.Get("Range"))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write(content)
+	}))
+	defer upstream.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	get := func(hdr http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v2/hub/org/app/blobs/"+d.String(), nil)
+		for k, v := range hdr {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Cache miss: the client gets its range, the cache gets the whole blob.
+	w := get(http.Header{"Range": {"bytes=10-15"}})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "abcdef", w.Body.String())
+	assert.Equal(t, "bytes 10-15/36", w.Header().Get("Content-Range"))
+	assert.Equal(t, "", upstreamRange.Load())
+	exists, err := h.blobs.Exists(t.Context(), d)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Cache hit.
+	w = get(http.Header{"Range": {"bytes=-3"}})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "xyz", w.Body.String())
+	assert.Equal(t, "3", w.Header().Get("Content-Length"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+
+	w = get(http.Header{"Range": {"bytes=0-1,4-5"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	assert.Equal(t, "bytes */36", w.Header().Get("Content-Range"))
+
+	// A unit we do not understand is ignored, not refused.
+	w = get(http.Header{"Range": {"items=0-1"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, string(content), w.Body.String())
+
+	// A stale If-Range falls back to the full blob.
+	w = get(http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"sha256:other"`}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, string(content), w.Body.String())
+}
+
+func TestClientPool_ReusesTokens(t *testing.T) {
+	fake := &fakeRegistry{}
+	var tokenFetches atomic.Int32
+	mux := http.NewServeMux()
+	var realm string
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenFetches.Add(1)
+		_, _ = w.Write([]byte(`{"token":"secret","expires_in":300}`))
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`",service="test",scope="repository:org/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+	upstream := httptest.NewServer(mux)
+	defer upstream.Close()
+	realm = upstream.URL + "/token"
+
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "hub",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   upstream.URL,
+	})
+	defer h.Close()
+	for range 3 {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/hub/org/app/manifests/latest", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, int32(1), tokenFetches.Load())
+
+	cfg := configstore.RepoConfig{RepoKey: "hub", RemoteURL: upstream.URL}
+	first := h.clients.Get(&cfg)
+	assert.Same(t, first, h.clients.Get(&cfg))
+	cfg.Username = "other"
+	assert.NotSame(t, first, h.clients.Get(&cfg))
+}
+
+func TestGetBlob_SharedFillFailureRetries(t *testing.T) {
+	content := []byte("layer only the good remote has")
+	d := digest.FromBytes(content)
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write(content)
+	}))
+	defer good.Close()
+	r, h := newTestDockerHandler(t, configstore.RepoConfig{
+		RepoKey:     "bad",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   bad.URL,
+	})
+	h.store.Add(configstore.RepoConfig{
+		RepoKey:     "good",
+		PackageType: configstore.PackageTypeDocker,
+		RemoteURL:   good.URL,
+	})
+	get := func(repoKey string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/"+repoKey+"/org/app/blobs/"+d.String(), nil))
+		return w
+	}
+
+	badDone := make(chan *httptest.ResponseRecorder)
+	go func() { badDone <- get("bad") }()
+	<-requested
+	goodDone := make(chan *httptest.ResponseRecorder)
+	go func() { goodDone <- get("good") }()
+	// Give the second request time to join the in-flight fill.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	w := <-badDone
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "BLOB_UNKNOWN", registryErrorCode(t, w))
+	w = <-goodDone
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, string(content), w.Body.String())
+}
@@ -0,0 +1,253 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/mw"
+	"github.com/martencassel/gobinrepo/internal/util/manifests"
+	"github.com/martencassel/gobinrepo/internal/util/oci"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleVirtualV2 serves pulls for a virtual repository by trying its members
+// in order. Content is cached by the member that answers.
+func (h *DockerRemoteHandler) handleVirtualV2(c *gin.Context, cfg *configstore.RepoConfig, rest string) {
+	method := c.Request.Method
+	if method != http.MethodGet && method != http.MethodHead {
+		writeRegistryError(c, http.StatusMethodNotAllowed, codeUnsupported, "repository is read-only")
+		return
+	}
+	switch {
+	case strings.Contains(rest, "/manifests/"):
+		parts := strings.SplitN(rest, "/manifests/", 2)
+		h.getVirtualManifest(c, cfg, parts[0], parts[1])
+	case strings.Contains(rest, "/blobs/"):
+		parts := strings.SplitN(rest, "/blobs/", 2)
+		h.getVirtualBlob(c, cfg, parts[0])
+	case strings.Contains(rest, "/tags/list"):
+		if method == http.MethodHead {
+			c.Status(http.StatusMethodNotAllowed)
+			return
+		}
+		h.virtualTagList(c, cfg, strings.SplitN(rest, "/tags/list", 2)[0])
+	default:
+		writeRegistryError(c, http.StatusNotFound, codeUnsupported, "unsupported v2 path")
+	}
+}
+
+// members returns the repositories a virtual repository resolves through, in
+// order. Unknown, non-docker and virtual members are skipped, so virtual
+// repositories cannot form cycles.
+func (h *DockerRemoteHandler) members(cfg *configstore.RepoConfig) []configstore.RepoConfig {
+	out := make([]configstore.RepoConfig, 0, len(cfg.Members))
+	for _, key := range cfg.Members {
+		m, ok := h.store.Get(key)
+		if !ok || m.PackageType != configstore.PackageTypeDocker || m.IsVirtual() {
+			log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "member": key}).Warn("Skipping invalid virtual repository member")
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// memberMiss reports whether err means a member does not have the content, as
+// opposed to failing to answer. Registries answer 401 or 403 for
+// repositories they do not know, so any client error counts as a miss. An
+// offline member misses whatever it has not cached, and a member holding a
+// tag only in media types the client does not accept misses it too.
+func memberMiss(err error) bool {
+	if errors.Is(err, manifests.ErrNotFound) || errors.Is(err, errOffline) || errors.Is(err, errNotAcceptable) {
+		return true
+	}
+	var ue *upstreamError
+	return errors.As(err, &ue) && ue.StatusCode >= 400 && ue.StatusCode < 500 && ue.StatusCode != http.StatusTooManyRequests
+}
+
+func (h *DockerRemoteHandler) getVirtualManifest(c *gin.Context, cfg *configstore.RepoConfig, name, ref string) {
+	if _, err := digest.Parse(ref); err != nil && !oci.IsValidTag(ref) {
+		writeRegistryError(c, http.StatusBadRequest, codeTagInvalid, "invalid tag")
+		return
+	}
+	_, desc, data, err := h.resolveVirtualManifest(c.Request.Context(), cfg, name, ref, upstreamHeaders(c.Request.Header))
+	switch {
+	case errors.Is(err, manifests.ErrNotFound):
+		writeRegistryError(c, http.StatusNotFound, codeManifestUnknown, "manifest unknown to registry")
+	case writeDenied(c, err):
+	case writeManifestRefused(c, err):
+	case err != nil:
+		writeUpstreamError(c, err, codeManifestUnknown, "failed to get manifest from upstream")
+	default:
+		writeManifest(c, desc, data)
+	}
+}
+
+// resolveVirtualManifest tries the members of a virtual repository in order
+// and returns the first that has ref, with the manifest. It returns
+// manifests.ErrNotFound when no member has it.
+func (h *DockerRemoteHandler) resolveVirtualManifest(ctx context.Context, cfg *configstore.RepoConfig, name, ref string, hdr http.Header) (configstore.RepoConfig, manifests.Descriptor, []byte, error) {
+	logger := log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "name": name, "ref": ref})
+	var failed error
+	for _, m := range h.members(cfg) {
+		desc, data, err := h.memberManifest(ctx, &m, name, ref, hdr)
+		if err == nil {
+			logger.WithFields(log.Fields{"member": m.RepoKey, "digest": desc.Digest}).Info("Manifest resolved through virtual repository")
+			return m, desc, data, nil
+		}
+		logger.WithError(err).WithField("member", m.RepoKey).Debug("Virtual member could not resolve manifest")
+		if !memberMiss(err) {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return configstore.RepoConfig{}, manifests.Descriptor{}, nil, failed
+	}
+	return configstore.RepoConfig{}, manifests.Descriptor{}, nil, manifests.ErrNotFound
+}
+
+// memberManifest resolves ref in a hosted or remote repository.
+func (h *DockerRemoteHandler) memberManifest(ctx context.Context, cfg *configstore.RepoConfig, name, ref string, hdr http.Header) (manifests.Descriptor, []byte, error) {
+	if cfg.IsHosted() {
+		desc, data, err := h.hostedManifest(ctx, cfg.RepoKey, name, ref)
+		if err == nil && !oci.Acceptable(acceptedTypes(hdr), desc.MediaType) {
+			return manifests.Descriptor{}, nil, errNotAcceptable
+		}
+		return desc, data, err
+	}
+	var reference oci.Reference
+	if _, err := digest.Parse(ref); err == nil {
+		reference.Digest = ref
+	} else {
+		reference.Tag = ref
+	}
+	return h.remoteManifest(ctx, cfg, normalizeName(cfg.RemoteURL, name), reference, hdr)
+}
+
+// resolveManifest resolves ref in any docker repository and returns the
+// repository that holds it: cfg itself, or the answering member of a virtual
+// repository.
+func (h *DockerRemoteHandler) resolveManifest(ctx context.Context, cfg *configstore.RepoConfig, name, ref string, hdr http.Header) (configstore.RepoConfig, manifests.Descriptor, []byte, error) {
+	if cfg.IsVirtual() {
+		return h.resolveVirtualManifest(ctx, cfg, name, ref, hdr)
+	}
+	desc, data, err := h.memberManifest(ctx, cfg, name, ref, hdr)
+	return *cfg, desc, data, err
+}
+
+// getVirtualBlob serves a blob from the local store, or streams it from the
+// first remote member that has it. Blobs pushed to hosted members are always
+// in the local store already.
+func (h *DockerRemoteHandler) getVirtualBlob(c *gin.Context, cfg *configstore.RepoConfig, name string) {
+	ociURL, d, err := oci.ParseDigestURL(c.Request.URL.String(), "registry-1.docker.io")
+	if err != nil {
+		writeRegistryError(c, http.StatusBadRequest, codeDigestInvalid, "invalid blob request")
+		return
+	}
+	ctx := c.Request.Context()
+	corrID, _ := c.Get(mw.CorrelationIDHeader)
+	req := &blobRequest{
+		Ctx:    ctx,
+		Gin:    c,
+		URL:    ociURL,
+		Digest: d,
+		Start:  time.Now(),
+		Logger: log.WithFields(log.Fields{
+			"correlation_id": corrID,
+			"method":         c.Request.Method,
+			"path":           c.Request.URL.String(),
+			"repoKey":        cfg.RepoKey,
+		}),
+	}
+
+	size, err := h.blobs.Stat(ctx, d)
+	switch {
+	case err == nil && c.Request.Method == http.MethodHead:
+		writeBlobHeaders(c, d, size)
+		c.Status(http.StatusOK)
+		return
+	case err == nil:
+		h.serveCachedBlob(req)
+		return
+	case !errors.Is(err, os.ErrNotExist):
+		log.WithError(err).Warnf("failed to stat blob %s", d)
+	}
+
+	hdr := upstreamHeaders(c.Request.Header, "Range", "If-Range")
+	var failed error
+	for _, m := range h.members(cfg) {
+		if m.IsHosted() {
+			continue
+		}
+		size, err := h.headUpstreamBlob(ctx, &m, normalizeName(m.RemoteURL, name), d, hdr)
+		if err != nil {
+			req.Logger.WithError(err).WithField("member", m.RepoKey).Debug("Virtual member does not have blob")
+			if !memberMiss(err) {
+				failed = err
+			}
+			continue
+		}
+		if c.Request.Method == http.MethodHead {
+			writeBlobHeaders(c, d, size)
+			c.Status(http.StatusOK)
+			return
+		}
+		req.Logger = req.Logger.WithField("member", m.RepoKey)
+		h.streamBlob(req, &m)
+		return
+	}
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if failed != nil {
+		writeUpstreamError(c, failed, codeBlobUnknown, "failed to fetch blob from upstream")
+		return
+	}
+	writeRegistryError(c, http.StatusNotFound, codeBlobUnknown, "blob unknown to registry")
+}
+
+// virtualTagList merges the tags every member knows for name.
+func (h *DockerRemoteHandler) virtualTagList(c *gin.Context, cfg *configstore.RepoConfig, name string) {
+	ctx := c.Request.Context()
+	hdr := upstreamHeaders(c.Request.Header)
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, m := range h.members(cfg) {
+		var list []string
+		var err error
+		if m.IsHosted() {
+			list, err = h.manifests.Tags(m.RepoKey, name)
+		} else {
+			mode := m.TagList
+			if mode == configstore.TagListUpstream {
+				mode = configstore.TagListMerge
+			}
+			list, err = h.listTags(ctx, &m, h.clients.Get(&m), normalizeName(m.RemoteURL, name), mode, hdr)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"repoKey": cfg.RepoKey, "member": m.RepoKey, "name": name}).WithError(err).Warn("Failed to list member tags")
+			continue
+		}
+		for _, tag := range list {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) == 0 {
+		writeRegistryError(c, http.StatusNotFound, codeNameUnknown, "repository name not known to registry")
+		return
+	}
+	sort.Strings(tags)
+	writeTagList(c, cfg.RepoKey+"/"+name, tags)
+}
@@ -0,0 +1,78 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVirtual_ResolvesInOrder(t *testing.T) {
+	layer := []byte("upstream layer")
+	layerDigest := digest.FromBytes(layer)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/org/app/manifests/latest", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", v1.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromString(testManifest).String())
+		_, _ = w.Write([]byte(testManifest))
+	})
+	mux.HandleFunc("/v2/org/app/blobs/"+layerDigest.String(), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(layer)
+	})
+	mux.HandleFunc("/v2/org/app/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"org/app","tags":["latest"]}`))
+	})
+	upstream := httptest.NewServer(mux)
+	defer upstream.Close()
+
+	r, h := newTestHostedHandler(t)
+	h.store.Add(configstore.RepoConfig{RepoKey: "hub", PackageType: configstore.PackageTypeDocker, RemoteURL: upstream.URL})
+	h.store.Add(configstore.RepoConfig{
+		RepoKey:     "all",
+		PackageType: configstore.PackageTypeDocker,
+		Kind:        configstore.RepoKindVirtual,
+		Members:     []string{"internal", "missing", "hub"},
+	})
+
+	// A tag pushed to the hosted member wins over upstream.
+	pushed := []byte(`{"schemaVersion":2,"config":{"digest":"` + digest.FromString("cfg").String() + `"},"layers":[]}`)
+	desc, err := h.manifests.Put(t.Context(), v1.MediaTypeImageManifest, pushed)
+	assert.NoError(t, err)
+	assert.NoError(t, h.manifests.PutRevision("internal", "org/app", desc.Digest))
+	assert.NoError(t, h.manifests.PutTag("internal", "org/app", "dev", desc.Digest))
+
+	w := serve(r, http.MethodGet, "/v2/all/org/app/manifests/dev", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, string(pushed), w.Body.String())
+
+	w = serve(r, http.MethodGet, "/v2/all/org/app/manifests/latest", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testManifest, w.Body.String())
+	// The answering member cached the tag.
+	_, found, err := h.manifests.GetTag("hub", "org/app", "latest")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/v2/all/org/app/manifests/nope", nil, nil).Code)
+
+	w = serve(r, http.MethodHead, "/v2/all/org/app/blobs/"+layerDigest.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(r, http.MethodGet, "/v2/all/org/app/blobs/"+layerDigest.String(), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, string(layer), w.Body.String())
+	w = serve(r, http.MethodGet, "/v2/all/org/app/blobs/"+digest.FromString("absent").String(), nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(r, http.MethodGet, "/v2/all/org/app/tags/list", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"all/org/app","tags":["dev","latest"]}`, w.Body.String())
+
+	w = serve(r, http.MethodGet, "/v2/all/_catalog", nil, nil)
+	assert.JSONEq(t, `{"repositories":["all/org/app"]}`, w.Body.String())
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(r, http.MethodPost, "/v2/all/org/app/blobs/uploads/", nil, nil).Code)
+}
@@ -0,0 +1,91 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	"github.com/martencassel/gobinrepo/internal/util/filestore"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// fileCache keeps the path-addressed files of Helm and Debian remotes: the
+// content lives in the blob store and each repoKey/path maps to its digest.
+type fileCache struct {
+	blobs blobs.BlobStore
+	files filestore.FileStore
+}
+
+// open returns the cached file at path; found is false when there is none.
+func (f *fileCache) open(ctx context.Context, repoKey, path string) (io.ReadCloser, bool, error) {
+	s, found, err := f.files.Get(repoKey, path)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	d, err := digest.Parse(s)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid digest for %s: %w", path, err)
+	}
+	rc, err := f.blobs.Get(ctx, d)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return rc, true, nil
+}
+
+// store copies body to w and caches it under path once it has been read
+// completely.
+func (f *fileCache) store(ctx context.Context, repoKey, path string, body io.Reader, w io.Writer) error {
+	tmp, err := os.CreateTemp("", "gobinrepo-file-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	digester := digest.Canonical.Digester()
+	if _, err := io.Copy(io.MultiWriter(tmp, digester.Hash(), w), body); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d := digester.Digest()
+	if err := f.blobs.Put(ctx, d, tmp); err != nil {
+		return err
+	}
+	return f.files.Put(repoKey, path, d.String())
+}
+
+// serve writes the cached file at path and reports whether there was one.
+func (f *fileCache) serve(c *gin.Context, repoKey, path, contentType string) bool {
+	rc, found, err := f.open(c.Request.Context(), repoKey, path)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"repoKey": repoKey, "path": path}).Warn("Failed to read cached file")
+	}
+	if !found {
+		return false
+	}
+	defer func() {
+		if cerr := rc.Close(); cerr != nil {
+			log.Warnf("failed to close cached file: %v", cerr)
+		}
+	}()
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, rc); err != nil {
+		log.WithError(err).WithFields(log.Fields{"repoKey": repoKey, "path": path}).Warn("Failed to stream cached file")
+	}
+	log.WithFields(log.Fields{"repoKey": repoKey, "path": path}).Info("File served from cache")
+	return true
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/martencassel/gobinrepo/internal/configstore"
 	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	"github.com/martencassel/gobinrepo/internal/util/filestore"
 	log "github.com/sirupsen/logrus"
 	repo "helm.sh/helm/v3/pkg/repo"
 	"sigs.k8s.io/yaml"
@@ -21,6 +22,7 @@ import (
 
 type HelmRepoHandler struct {
 	blobs blobs.BlobStore
+	files *fileCache
 	store *configstore.RepoConfigStore
 
 	// Delegates for test injection
@@ -29,9 +31,10 @@ type HelmRepoHandler struct {
 	onChart    func(*gin.Context)
 }
 
-func NewHelmRepoHandler(blobs blobs.BlobStore, store *configstore.RepoConfigStore) *HelmRepoHandler {
+func NewHelmRepoHandler(blobs blobs.BlobStore, files filestore.FileStore, store *configstore.RepoConfigStore) *HelmRepoHandler {
 	h := &HelmRepoHandler{
 		blobs: blobs,
+		files: &fileCache{blobs: blobs, files: files},
 		store: store,
 	}
 	// default to real methods
@@ -89,6 +92,16 @@ func (h *HelmRepoHandler) handleRedirectedChartFile(c *gin.Context) {
 	externalURL = strings.Replace(externalURL, "https/", "https://", 1)
 	externalURL = strings.Replace(externalURL, "http/", "http://", 1)
 
+	// Charts are cached under their proxied path, e.g. external/https/...
+	cachePath := "external/" + externalPath
+	if h.files.serve(c, repoName, cachePath, "application/gzip") {
+		return
+	}
+	if repoConfig, ok := h.store.Get(repoName); ok && repoConfig.Offline {
+		writeHelmOffline(c, repoName, cachePath)
+		return
+	}
+
 	// Fetch the chart from the external URL
 
 	log.Infof("Fetching Helm chart from external URL: %s", externalURL)
@@ -109,7 +122,11 @@ func (h *HelmRepoHandler) handleRedirectedChartFile(c *gin.Context) {
 	}
 	c.Status(res.StatusCode)
 	// Copy response body to client
-	_, err = io.Copy(c.Writer, res.Body)
+	if res.StatusCode == http.StatusOK {
+		err = h.files.store(c.Request.Context(), repoName, cachePath, res.Body, c.Writer)
+	} else {
+		_, err = io.Copy(c.Writer, res.Body)
+	}
 	if err != nil {
 		c.String(500, "failed to copy response body: %v", err)
 		return
@@ -123,18 +140,28 @@ func (h *HelmRepoHandler) handleChartFile(c *gin.Context) {
 	repoConfig, ok := h.store.Get(repoName)
 	if !ok {
 		c.String(404, "repository not found")
+		return
 	}
 	log.Info("Handling Helm Chart file request")
 	log.Infof("repoConfig: %v", repoConfig)
 	path := c.Request.URL.Path
 	// Normalize path to remove /helm/:repoKey/ prefix
 	path = path[len("/helm/"+repoName+"/"):]
+	// Chart versions do not change once published; serve them from cache.
+	if h.files.serve(c, repoName, path, "application/gzip") {
+		return
+	}
+	if repoConfig.Offline {
+		writeHelmOffline(c, repoName, path)
+		return
+	}
 	// Forward the request to the remote Helm repo
 	log.Infof("Forwarding Helm chart file request to remote: repo=%s, path=%s", repoName, path)
 	res := h.forwardRequest(c, repoName, path)
 	if res == nil {
 		return
 	}
+	defer res.Body.Close()
 	// Copy response headers
 	for k, v := range res.Header {
 		for _, vv := range v {
@@ -143,7 +170,12 @@ func (h *HelmRepoHandler) handleChartFile(c *gin.Context) {
 	}
 	c.Status(res.StatusCode)
 	// Copy response body to client
-	_, err := io.Copy(c.Writer, res.Body)
+	var err error
+	if res.StatusCode == http.StatusOK {
+		err = h.files.store(c.Request.Context(), repoName, path, res.Body, c.Writer)
+	} else {
+		_, err = io.Copy(c.Writer, res.Body)
+	}
 	if err != nil {
 		c.String(500, "failed to copy response body: %v", err)
 		return
@@ -167,22 +199,8 @@ func (h *HelmRepoHandler) handleIndex(c *gin.Context) {
 	path := c.Request.URL.Path
 	// Normalize path to remove /helm/:repoKey/ prefix
 	path = path[len("/helm/"+repoName+"/"):]
-	// Forward the request to the remote Helm repo
-	res := h.forwardRequest(c, repoName, path)
-	if res == nil {
-		return
-	}
-	// Copy response headers
-	for k, v := range res.Header {
-		for _, vv := range v {
-			c.Writer.Header().Add(k, vv)
-		}
-	}
-
-	// Read the entire body
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		c.String(500, "failed to read index.yaml: %v", err)
+	data, status, ok := h.fetchIndex(c, &repoConfig, path)
+	if !ok {
 		return
 	}
 	index, err := LoadIndexReader(bytes.NewReader(data))
@@ -207,12 +225,65 @@ func (h *HelmRepoHandler) handleIndex(c *gin.Context) {
 	// Adjust headers
 	c.Writer.Header().Del("Content-Length")
 	c.Writer.Header().Set("Content-Type", "application/x-yaml")
-	c.Status(res.StatusCode)
+	c.Status(status)
 
 	// Write response
 	c.Writer.Write(rewritten)
 }
 
+// fetchIndex reads index.yaml from upstream and caches the latest copy, which
+// is what an offline remote serves. It writes the response itself and
+// returns false when there is no index to rewrite.
+func (h *HelmRepoHandler) fetchIndex(c *gin.Context, repoConfig *configstore.RepoConfig, path string) ([]byte, int, bool) {
+	if repoConfig.Offline {
+		rc, found, err := h.files.open(c.Request.Context(), repoConfig.RepoKey, path)
+		if err != nil {
+			log.Warnf("failed to read cached %s: %v", path, err)
+		}
+		if !found {
+			writeHelmOffline(c, repoConfig.RepoKey, path)
+			return nil, 0, false
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			c.String(500, "failed to read index.yaml: %v", err)
+			return nil, 0, false
+		}
+		return data, http.StatusOK, true
+	}
+	// Forward the request to the remote Helm repo
+	res := h.forwardRequest(c, repoConfig.RepoKey, path)
+	if res == nil {
+		return nil, 0, false
+	}
+	defer res.Body.Close()
+	// Copy response headers
+	for k, v := range res.Header {
+		for _, vv := range v {
+			c.Writer.Header().Add(k, vv)
+		}
+	}
+
+	// Read the entire body
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.String(500, "failed to read index.yaml: %v", err)
+		return nil, 0, false
+	}
+	if res.StatusCode == http.StatusOK {
+		if err := h.files.store(c.Request.Context(), repoConfig.RepoKey, path, bytes.NewReader(data), io.Discard); err != nil {
+			log.Warnf("failed to cache %s: %v", path, err)
+		}
+	}
+	return data, res.StatusCode, true
+}
+
+// writeHelmOffline reports a file that an offline remote has not cached.
+func writeHelmOffline(c *gin.Context, repoKey, path string) {
+	c.String(http.StatusNotFound, "%s not cached and %s is offline", path, repoKey)
+}
+
 func (r *HelmRepoHandler) forwardRequest(c *gin.Context, repoKey, path string) *http.Response {
 	// Forward the request to the remote Helm repo.
 	repoConfig, ok := r.store.Get(repoKey)
@@ -3,9 +3,13 @@ package remote
 import (
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/martencassel/gobinrepo/internal/configstore"
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	"github.com/martencassel/gobinrepo/internal/util/filestore"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -57,3 +61,61 @@ func TestHandleHelmRequest_Dispatch(t *testing.T) {
 		})
 	}
 }
+
+func TestHelmOffline(t *testing.T) {
+	index := `apiVersion: v1
+entries:
+  foo:
+  - name: foo
+    version: 1.0.0
+    urls:
+    - charts/foo-1.0.0.tgz
+generated: "2024-01-01T00:00:00Z"
+`
+	hits := map[string]int{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/index.yaml":
+			_, _ = w.Write([]byte(index))
+		case "/charts/foo-1.0.0.tgz", "/charts/foo-2.0.0.tgz":
+			_, _ = w.Write([]byte("chart"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	bfs, err := blobs.NewBlobStoreFS(filepath.Join(dir, "blobs"))
+	assert.NoError(t, err)
+	store := configstore.NewRepoConfigStore()
+	cfg := configstore.RepoConfig{RepoKey: "charts", PackageType: configstore.PackageTypeHelm, RemoteURL: upstream.URL}
+	store.Add(cfg)
+	h := NewHelmRepoHandler(bfs, filestore.NewFileStore(filepath.Join(dir, "files")), store)
+	r := gin.New()
+	h.Register(r)
+
+	w := serve(r, http.MethodGet, "/helm/charts/index.yaml", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	online := w.Body.String()
+	w = serve(r, http.MethodGet, "/helm/charts/charts/foo-1.0.0.tgz", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	cfg.Offline = true
+	store.Add(cfg)
+	w = serve(r, http.MethodGet, "/helm/charts/index.yaml", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, online, w.Body.String())
+	w = serve(r, http.MethodGet, "/helm/charts/charts/foo-1.0.0.tgz", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "chart", w.Body.String())
+
+	w = serve(r, http.MethodGet, "/helm/charts/charts/foo-2.0.0.tgz", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "charts is offline")
+	assert.Equal(t, 1, hits["/index.yaml"])
+	assert.Equal(t, 1, hits["/charts/foo-1.0.0.tgz"])
+	assert.Zero(t, hits["/charts/foo-2.0.0.tgz"])
+}
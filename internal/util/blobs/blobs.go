@@ -14,6 +14,10 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrDigestMismatch is returned when written content does not hash to the
+// digest it was stored under.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
 // BlobStore defines opaque binary object storage.
 // Keys are typically digests or content hashes.
 type BlobStore interface {
@@ -26,6 +30,10 @@ type BlobStore interface {
 	// Exists checks if a blob is present.
 	Exists(ctx context.Context, d digest.Digest) (bool, error)
 
+	// Stat returns the size of a stored blob. Missing blobs yield an error
+	// satisfying errors.Is(err, os.ErrNotExist).
+	Stat(ctx context.Context, d digest.Digest) (int64, error)
+
 	// Writer returns a WriteCloser that streams into the blob store
 	// and verifies the digest on Close.
 	Writer(ctx context.Context, expected digest.Digest) (io.WriteCloser, error)
@@ -98,6 +106,18 @@ func (fs *BlobStoreFS) Exists(ctx context.Context, d digest.Digest) (bool, error
 	return err == nil, err
 }
 
+func (fs *BlobStoreFS) Stat(ctx context.Context, d digest.Digest) (int64, error) {
+	p, err := fs.blobPath(d)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 type verifyingWriter struct {
 	f      *os.File
 	dig    digest.Digester
@@ -114,7 +134,7 @@ func (vw *verifyingWriter) Close() error {
 		return err
 	}
 	if got := vw.dig.Digest(); got != vw.expect {
-		return fmt.Errorf("digest mismatch: got %s, want %s", got, vw.expect)
+		return fmt.Errorf("%w: got %s, want %s", ErrDigestMismatch, got, vw.expect)
 	}
 	return nil
 }
@@ -183,7 +203,7 @@ func (w *atomicWriter) Close() error {
 	got := digest.NewDigest(digest.SHA256, w.h)
 	if got != w.expected {
 		_ = os.Remove(w.tmpPath)
-		return fmt.Errorf("digest mismatch: got %s, want %s", got, w.expected)
+		return fmt.Errorf("%w: got %s, want %s", ErrDigestMismatch, got, w.expected)
 	}
 
 	// Ensure final directory exists
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"io"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	digest "github.com/opencontainers/go-digest"
 	assert "github.com/stretchr/testify/require"
@@ -38,3 +42,123 @@ func TestBlobs(t *testing.T) {
 	assert.NoError(t, err, "Failed to read blob data")
 	assert.Equal(t, blobData, retrievedData, "Retrieved blob data does not match original")
 }
+
+func TestFillGroupCoalesces(t *testing.T) {
+	bfs, err := NewBlobStoreFS(t.TempDir())
+	assert.NoError(t, err)
+	g := NewFillGroup(bfs)
+	defer g.Close()
+
+	blobData := getRandomBlobData(1024 * 512)
+	d := digest.FromBytes(blobData)
+
+	// The upstream body is fed in by hand so readers have to follow a growing file.
+	pr, pw := io.Pipe()
+	var fetches atomic.Int32
+	fetch := func(ctx context.Context) (io.ReadCloser, int64, error) {
+		fetches.Add(1)
+		return pr, int64(len(blobData)), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 5)
+	for i := range results {
+		f := g.Join(d, "test", fetch)
+		r, err := f.NewReader(context.Background())
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			results[i] = data
+		}()
+	}
+	for off := 0; off < len(blobData); off += 64 * 1024 {
+		_, err := pw.Write(blobData[off:min(off+64*1024, len(blobData))])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, pw.Close())
+	wg.Wait()
+
+	assert.Equal(t, int32(1), fetches.Load())
+	for _, data := range results {
+		assert.Equal(t, blobData, data)
+	}
+	exists, err := bfs.Exists(context.Background(), d)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestFillGroupDropsStalledFill(t *testing.T) {
+	bfs, err := NewBlobStoreFS(t.TempDir())
+	assert.NoError(t, err)
+	g := NewFillGroup(bfs)
+	g.IdleTimeout = 50 * time.Millisecond
+	defer g.Close()
+
+	blobData := getRandomBlobData(1024)
+	d := digest.FromBytes(blobData)
+
+	// Upstream sends a few bytes and then goes silent.
+	pr, pw := io.Pipe()
+	go func() { _, _ = pw.Write(blobData[:10]) }()
+	stalled := func(ctx context.Context) (io.ReadCloser, int64, error) {
+		return pr, int64(len(blobData)), nil
+	}
+	f := g.Join(d, "test", stalled)
+	r, err := f.NewReader(context.Background())
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrFillStalled)
+	assert.NoError(t, r.Close())
+
+	// The dead fill is not joined again; a new fetch starts.
+	healthy := func(ctx context.Context) (io.ReadCloser, int64, error) {
+		return io.NopCloser(bytes.NewReader(blobData)), int64(len(blobData)), nil
+	}
+	f2 := g.Join(d, "test", healthy)
+	assert.NotSame(t, f, f2)
+	r, err = f2.NewReader(context.Background())
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, blobData, data)
+}
+
+func TestFillGroupPutWaitsForFill(t *testing.T) {
+	bfs, err := NewBlobStoreFS(t.TempDir())
+	assert.NoError(t, err)
+	g := NewFillGroup(bfs)
+	defer g.Close()
+
+	blobData := getRandomBlobData(64 * 1024)
+	d := digest.FromBytes(blobData)
+
+	pr, pw := io.Pipe()
+	f := g.Join(d, "proxy", func(ctx context.Context) (io.ReadCloser, int64, error) {
+		return pr, int64(len(blobData)), nil
+	})
+	_, err = f.Wait(context.Background())
+	assert.NoError(t, err)
+
+	// A push of the same digest must not write alongside the running fill.
+	done := make(chan error)
+	go func() { done <- g.Put(context.Background(), d, bytes.NewReader(blobData)) }()
+	_, err = pw.Write(blobData)
+	assert.NoError(t, err)
+	assert.NoError(t, pw.Close())
+	assert.NoError(t, <-done)
+
+	r, err := bfs.Get(context.Background(), d)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, blobData, data)
+
+	err = g.Put(context.Background(), d, bytes.NewReader([]byte("tampered")))
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+}
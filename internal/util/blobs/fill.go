@@ -0,0 +1,353 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultFillIdleTimeout is how long a fill waits for upstream to send more
+// bytes before it gives up on the download.
+const DefaultFillIdleTimeout = 60 * time.Second
+
+// ErrFillStalled is the error of a fill whose upstream stopped sending data.
+var ErrFillStalled = errors.New("upstream stalled while filling blob")
+
+// FetchFunc opens the upstream stream for a blob. size is -1 when unknown.
+type FetchFunc func(ctx context.Context) (body io.ReadCloser, size int64, err error)
+
+// FillGroup coalesces concurrent cache misses: each digest is downloaded from
+// upstream once into the store, and every requester streams the growing
+// partial file as bytes arrive.
+type FillGroup struct {
+	store  BlobStore
+	ctx    context.Context
+	cancel context.CancelFunc
+	// IdleTimeout aborts a fill that receives no data for this long, so a
+	// stalled upstream cannot hold a digest forever.
+	IdleTimeout time.Duration
+
+	mu    sync.Mutex
+	fills map[digest.Digest]*Fill
+}
+
+// NewFillGroup creates a FillGroup writing into store.
+func NewFillGroup(store BlobStore) *FillGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &FillGroup{
+		store:       store,
+		ctx:         ctx,
+		cancel:      cancel,
+		IdleTimeout: DefaultFillIdleTimeout,
+		fills:       make(map[digest.Digest]*Fill),
+	}
+}
+
+// Join returns the in-flight fill for d, starting one with fetch when none is
+// running. origin names the requester that supplies fetch and is recorded on
+// a new fill. The download is not tied to any single requester, so it finishes
+// filling the cache even if the client that started it goes away.
+func (g *FillGroup) Join(d digest.Digest, origin string, fetch FetchFunc) *Fill {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.fills[d]; ok && !f.failed() {
+		return f
+	}
+	f := &Fill{
+		Digest: d,
+		Origin: origin,
+		store:  g.store,
+		ready:  make(chan struct{}),
+		notify: make(chan struct{}),
+		size:   -1,
+	}
+	g.fills[d] = f
+	go func() {
+		f.run(g.ctx, g.IdleTimeout, fetch)
+		g.mu.Lock()
+		if g.fills[d] == f {
+			delete(g.fills, d)
+		}
+		g.mu.Unlock()
+	}()
+	return f
+}
+
+// Close aborts all in-flight downloads.
+func (g *FillGroup) Close() {
+	g.cancel()
+}
+
+// Fill is a single download of a blob into the store.
+type Fill struct {
+	Digest digest.Digest
+	// Origin is the requester whose fetch function the fill runs.
+	Origin string
+	store  BlobStore
+	ready  chan struct{} // closed once upstream has answered
+
+	mu      sync.Mutex
+	size    int64
+	path    string
+	written int64
+	done    bool
+	err     error
+	notify  chan struct{} // closed and replaced whenever progress is made
+}
+
+func (f *Fill) run(parent context.Context, idle time.Duration, fetch FetchFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	// The timer is pushed back on every chunk; firing cancels the fetch and
+	// closes the body so a blocked read returns.
+	stall := time.AfterFunc(idle, func() { cancel(ErrFillStalled) })
+	defer stall.Stop()
+
+	body, size, err := fetch(ctx)
+	if err != nil {
+		f.finish(nil, stalledErr(ctx, err))
+		return
+	}
+	stopClose := context.AfterFunc(ctx, func() { _ = body.Close() })
+	defer func() {
+		if stopClose() {
+			if cerr := body.Close(); cerr != nil {
+				log.Warnf("failed to close response body: %v", cerr)
+			}
+		}
+	}()
+	w, err := f.store.WriterAtomic(ctx, f.Digest)
+	if err != nil {
+		f.finish(nil, err)
+		return
+	}
+	named, ok := w.(interface{ Name() string })
+	if !ok {
+		_ = w.Close()
+		f.finish(nil, errors.New("blob store writer cannot be read while filling"))
+		return
+	}
+	f.mu.Lock()
+	f.size = size
+	f.path = named.Name()
+	f.mu.Unlock()
+	close(f.ready)
+
+	buf := make([]byte, 256<<10)
+	for {
+		n, rerr := body.Read(buf)
+		stall.Reset(idle)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				f.finish(w, werr)
+				return
+			}
+			f.mu.Lock()
+			f.written += int64(n)
+			f.broadcastLocked()
+			f.mu.Unlock()
+		}
+		if rerr == io.EOF {
+			f.finish(w, nil)
+			return
+		}
+		if rerr != nil {
+			f.finish(w, stalledErr(ctx, rerr))
+			return
+		}
+	}
+}
+
+// stalledErr reports ErrFillStalled in place of err when the idle timer
+// caused the failure.
+func stalledErr(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrFillStalled) {
+		return ErrFillStalled
+	}
+	return err
+}
+
+// failed reports whether the fill ended with an error; such fills are
+// replaced rather than joined.
+func (f *Fill) failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.done && f.err != nil
+}
+
+// finish commits or aborts the partial file and wakes all readers. Closing the
+// writer happens under the lock so no reader opens the partial path after it
+// has been promoted or removed.
+func (f *Fill) finish(w io.WriteCloser, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if w != nil {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	f.done = true
+	f.err = err
+	f.broadcastLocked()
+	select {
+	case <-f.ready:
+	default:
+		close(f.ready)
+	}
+}
+
+func (f *Fill) broadcastLocked() {
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// Wait blocks until upstream has answered and returns the blob size, or -1
+// when upstream did not announce it.
+func (f *Fill) Wait(ctx context.Context) (int64, error) {
+	select {
+	case <-f.ready:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done && f.err != nil && f.path == "" {
+		return 0, f.err
+	}
+	return f.size, nil
+}
+
+// Done blocks until the fill has finished and returns its error.
+func (f *Fill) Done(ctx context.Context) error {
+	for {
+		f.mu.Lock()
+		done, err, notify := f.done, f.err, f.notify
+		f.mu.Unlock()
+		if done {
+			return err
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Put stores the content of r under d, verifying the digest. It goes through
+// the group so it never writes the same digest as a concurrent fill: if one is
+// running, Put waits for it and only verifies r against the stored blob.
+func (g *FillGroup) Put(ctx context.Context, d digest.Digest, r io.Reader) error {
+	origin := "put:" + d.String()
+	for {
+		exists, err := g.store.Exists(ctx, d)
+		if err != nil {
+			return err
+		}
+		if exists {
+			verifier := d.Verifier()
+			if _, err := io.Copy(verifier, r); err != nil {
+				return err
+			}
+			if !verifier.Verified() {
+				return fmt.Errorf("%w: content does not match %s", ErrDigestMismatch, d)
+			}
+			return nil
+		}
+		var ran bool
+		f := g.Join(d, origin, func(ctx context.Context) (io.ReadCloser, int64, error) {
+			ran = true
+			return io.NopCloser(r), -1, nil
+		})
+		err = f.Done(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if ran {
+			return err
+		}
+		// Someone else's fill finished first; check what it left behind.
+	}
+}
+
+// NewReader returns a reader over the blob that follows the download as it
+// progresses. It fails with the fill's error if the download went wrong.
+func (f *Fill) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	if _, err := f.Wait(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.store.Get(ctx, f.Digest)
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return &tailReader{fill: f, file: file, ctx: ctx}, nil
+}
+
+// tailReader reads a partial file up to the bytes written so far, waiting for
+// more until the fill completes.
+type tailReader struct {
+	fill *Fill
+	file *os.File
+	ctx  context.Context
+	off  int64
+}
+
+func (r *tailReader) Read(p []byte) (int, error) {
+	for {
+		r.fill.mu.Lock()
+		avail := r.fill.written - r.off
+		done, err, notify := r.fill.done, r.fill.err, r.fill.notify
+		r.fill.mu.Unlock()
+
+		if avail > 0 {
+			if int64(len(p)) > avail {
+				p = p[:avail]
+			}
+			n, rerr := r.file.ReadAt(p, r.off)
+			r.off += int64(n)
+			if rerr == io.EOF && n > 0 {
+				rerr = nil
+			}
+			return n, rerr
+		}
+		if done {
+			if err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		select {
+		case <-notify:
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		}
+	}
+}
+
+// Seek moves the read offset; reads past the bytes written so far wait for them.
+func (r *tailReader) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart || offset < 0 {
+		return r.off, errors.New("tailReader: only absolute seeks are supported")
+	}
+	r.off = offset
+	return r.off, nil
+}
+
+func (r *tailReader) Close() error {
+	return r.file.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/martencassel/gobinrepo/internal/configstore"
 	"gopkg.in/yaml.v3"
@@ -14,6 +15,13 @@ type Config struct {
 		Listen    string `yaml:"listen"`
 		Trace     bool   `yaml:"trace"`
 		PublicURL string `yaml:"public_url"`
+		// DockerMirror is the docker remote serving paths without a repoKey
+		// prefix, for use in dockerd's registry-mirrors. When empty, the only
+		// Docker Hub remote, if there is exactly one, is used.
+		DockerMirror string `yaml:"docker_mirror"`
+		// Offline stops every remote from contacting its upstream; only
+		// cached content is served.
+		Offline bool `yaml:"offline"`
 	} `yaml:"server"`
 
 	Cache struct {
@@ -25,9 +33,68 @@ type Config struct {
 
 type RemoteConfig struct {
 	PackageType configstore.PackageType `yaml:"package_type"`
-	RemoteURL   string                  `yaml:"remote_url"`
-	Username    *string                 `yaml:"username,omitempty"`
-	Password    *string                 `yaml:"password,omitempty"`
+	// Kind is "remote" (the default) to proxy RemoteURL, "hosted" to
+	// accept pushes and serve them without an upstream, or "virtual" to
+	// resolve pulls through Members.
+	Kind      configstore.RepoKind `yaml:"kind,omitempty"`
+	RemoteURL string               `yaml:"remote_url"`
+	Username  *string              `yaml:"username,omitempty"`
+	Password  *string              `yaml:"password,omitempty"`
+	// TagTTL is how long a resolved tag is served from cache before it is
+	// revalidated upstream. Zero revalidates on every pull.
+	TagTTL time.Duration `yaml:"tag_ttl,omitempty"`
+	// TagList is "upstream" (the default), "cached" to list only tags held
+	// in the cache, or "merge" to combine both.
+	TagList configstore.TagListMode `yaml:"tag_list,omitempty"`
+	// Members lists the docker remotes a virtual repository tries, in order.
+	Members []string `yaml:"members,omitempty"`
+	// Namespaces routes requests carrying containerd's ?ns=<registry> to this
+	// remote without a repoKey prefix in the path.
+	Namespaces []string `yaml:"namespaces,omitempty"`
+	// Hosts routes requests for these Host headers to this remote without a
+	// repoKey prefix in the path.
+	Hosts []string `yaml:"hosts,omitempty"`
+	// PrefetchPlatforms lists platforms such as linux/arm64 or linux/arm/v7
+	// whose images are cached in the background whenever one of their
+	// indexes is pulled.
+	PrefetchPlatforms []string `yaml:"prefetch_platforms,omitempty"`
+	// Sync keeps matching tags of the listed repositories pulled into the
+	// cache, re-checked every interval.
+	Sync []configstore.SyncRule `yaml:"sync,omitempty"`
+	// Offline serves this remote from its cache only, as server.offline
+	// does for all remotes.
+	Offline bool `yaml:"offline,omitempty"`
+	// CosignKeys lists cosign public key files. Manifests without a valid
+	// signature by one of these keys are refused.
+	CosignKeys []string `yaml:"cosign_keys,omitempty"`
+	// PinTags keeps serving the first digest seen for each tag after it
+	// moves upstream, until the move is approved through /api/pins.
+	PinTags bool `yaml:"pin_tags,omitempty"`
+	// Credentials are further accounts to pull with; they take turns with
+	// username/password whenever one of them is rate limited.
+	Credentials []configstore.Credential `yaml:"credentials,omitempty"`
+	// RateLimitReserve is how many pulls of the upstream rate limit are kept
+	// spare: below it, tags are served from cache without re-fetching.
+	RateLimitReserve int `yaml:"rate_limit_reserve,omitempty"`
+	// DockerConfig is the path of a docker config.json whose auths,
+	// credHelpers or credsStore supply the credential for remote_url.
+	DockerConfig string `yaml:"docker_config,omitempty"`
+	// CredentialHelper names a docker-credential-<helper> binary asked for
+	// the credential of remote_url; it takes precedence over docker_config.
+	CredentialHelper string `yaml:"credential_helper,omitempty"`
+	// CredentialTTL is how long a credential read from docker_config or
+	// credential_helper is used before it is read again. Defaults to 10m.
+	CredentialTTL time.Duration `yaml:"credential_ttl,omitempty"`
+	// CacheRedirects reuses the storage URL a blob pull was redirected to
+	// until the URL expires, instead of asking the registry each time.
+	CacheRedirects bool `yaml:"cache_redirects,omitempty"`
+	// AllowSchema1 serves deprecated Docker schema 1 manifests, with a
+	// warning, instead of refusing them; on hosted remotes it accepts
+	// schema 1 pushes.
+	AllowSchema1 bool `yaml:"allow_schema1,omitempty"`
+	// SBOM caches the layers of every image a client pulls and records the
+	// packages installed in it, served through /api/docker/.../sbom.
+	SBOM bool `yaml:"sbom,omitempty"`
 }
 
 // LoadConfig reads a YAML config file, expands env vars, and unmarshals into Config.
@@ -56,6 +123,13 @@ func LoadConfig(path string) (*Config, error) {
 	for name, r := range cfg.Remotes {
 		r.Username = normalizeEnv(r.Username)
 		r.Password = normalizeEnv(r.Password)
+		creds := r.Credentials[:0]
+		for _, c := range r.Credentials {
+			if normalizeEnv(&c.Username) != nil && normalizeEnv(&c.Password) != nil {
+				creds = append(creds, c)
+			}
+		}
+		r.Credentials = creds
 		cfg.Remotes[name] = r
 	}
 
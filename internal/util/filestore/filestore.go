@@ -3,6 +3,8 @@ package filestore
 import (
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type FileStore interface {
@@ -11,6 +13,10 @@ type FileStore interface {
 	Delete(repoKey, path string) error
 	Exists(repoKey, path string) (bool, error)
 	List(repoKey string) ([]Mapping, error)
+	// ModTime reports when the mapping at path was last written.
+	ModTime(repoKey, path string) (time.Time, bool, error)
+	// Children lists the names directly below dir, or nil if dir is absent.
+	Children(repoKey, dir string) ([]string, error)
 }
 
 type Mapping struct {
@@ -90,7 +96,7 @@ func (fs *fileStoreImpl) Get(repoKey, path string) (string, bool, error) {
 	if err != nil {
 		return "", false, err
 	}
-	return string(data), true, nil
+	return strings.TrimSpace(string(data)), true, nil
 }
 
 func (fs *fileStoreImpl) Delete(repoKey, path string) error {
@@ -110,6 +116,33 @@ func (fs *fileStoreImpl) Exists(repoKey, path string) (bool, error) {
 	return true, nil
 }
 
+func (fs *fileStoreImpl) ModTime(repoKey, path string) (time.Time, bool, error) {
+	targetPath := filepath.Join(fs.BasePath, repoKey, path)
+	info, err := os.Stat(targetPath)
+	if os.IsNotExist(err) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return info.ModTime(), true, nil
+}
+
+func (fs *fileStoreImpl) Children(repoKey, dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(fs.BasePath, repoKey, dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
 func (fs *fileStoreImpl) List(repoKey string) ([]Mapping, error) {
 	var mappings []Mapping
 	baseDir := filepath.Join(fs.BasePath, repoKey)
@@ -0,0 +1,40 @@
+package manifests
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Repositories lists the repository names of repoKey that hold at least one
+// tag or manifest link, sorted. Names are found by walking the link tree:
+// a directory with a _manifests child is a repository, and directories whose
+// name starts with an underscore are never path components of a name.
+func (s *Store) Repositories(repoKey string) ([]string, error) {
+	var names []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		children, err := s.links.Children(repoKey, dir)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if child == "_manifests" && dir != "" {
+				names = append(names, dir)
+				continue
+			}
+			if strings.HasPrefix(child, "_") {
+				continue
+			}
+			if err := walk(path.Join(dir, child)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,56 @@
+package manifests
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// ErrNoInventory is returned when no package inventory is stored for a
+// manifest.
+var ErrNoInventory = errors.New("package inventory not found")
+
+// inventoryPath keeps the package inventory of an image next to the
+// descriptor of its manifest.
+func (s *Store) inventoryPath(d digest.Digest) (string, error) {
+	p, err := s.descriptorPath(d)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(p, ".json") + ".inventory.json", nil
+}
+
+// PutInventory stores the package inventory generated for image manifest d.
+func (s *Store) PutInventory(d digest.Digest, data []byte) error {
+	p, err := s.inventoryPath(d)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, data)
+}
+
+// GetInventory returns the package inventory stored for image manifest d,
+// or ErrNoInventory.
+func (s *Store) GetInventory(d digest.Digest) ([]byte, error) {
+	p, err := s.inventoryPath(d)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoInventory
+	}
+	return data, err
+}
+
+// HasInventory reports whether a package inventory is stored for d.
+func (s *Store) HasInventory(d digest.Digest) bool {
+	p, err := s.inventoryPath(d)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
@@ -0,0 +1,83 @@
+package manifests
+
+import (
+	"errors"
+	"os"
+	"path"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Hosted repositories scope content to a repository name: a manifest or blob
+// is only visible under a name once a link for it has been written there.
+// The content itself stays shared in the BlobStore.
+
+func revisionLinkPath(name string, d digest.Digest) string {
+	return path.Join(name, "_manifests", "revisions", d.Algorithm().String(), d.Encoded(), "link")
+}
+
+func layerLinkPath(name string, d digest.Digest) string {
+	return path.Join(name, "_layers", d.Algorithm().String(), d.Encoded(), "link")
+}
+
+// PutRevision links manifest d into repository name of repoKey.
+func (s *Store) PutRevision(repoKey, name string, d digest.Digest) error {
+	return s.links.Put(repoKey, revisionLinkPath(name, d), d.String())
+}
+
+// HasRevision reports whether manifest d is linked into repository name.
+func (s *Store) HasRevision(repoKey, name string, d digest.Digest) (bool, error) {
+	return s.links.Exists(repoKey, revisionLinkPath(name, d))
+}
+
+// DeleteRevision unlinks manifest d from repository name and removes every
+// tag there that points to it. It reports whether the manifest was linked.
+func (s *Store) DeleteRevision(repoKey, name string, d digest.Digest) (bool, error) {
+	found, err := s.HasRevision(repoKey, name, d)
+	if err != nil || !found {
+		return false, err
+	}
+	tags, err := s.Tags(repoKey, name)
+	if err != nil {
+		return false, err
+	}
+	for _, tag := range tags {
+		link, ok, err := s.GetTag(repoKey, name, tag)
+		if err != nil {
+			return false, err
+		}
+		if ok && link.Digest == d {
+			if err := s.DeleteTag(repoKey, name, tag); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, unlink(s.links.Delete(repoKey, revisionLinkPath(name, d)))
+}
+
+// PutLayer links blob d into repository name of repoKey.
+func (s *Store) PutLayer(repoKey, name string, d digest.Digest) error {
+	return s.links.Put(repoKey, layerLinkPath(name, d), d.String())
+}
+
+// HasLayer reports whether blob d is linked into repository name.
+func (s *Store) HasLayer(repoKey, name string, d digest.Digest) (bool, error) {
+	return s.links.Exists(repoKey, layerLinkPath(name, d))
+}
+
+// DeleteLayer unlinks blob d from repository name. It reports whether the
+// blob was linked.
+func (s *Store) DeleteLayer(repoKey, name string, d digest.Digest) (bool, error) {
+	err := s.links.Delete(repoKey, layerLinkPath(name, d))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlink(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
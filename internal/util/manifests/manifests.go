@@ -0,0 +1,182 @@
+package manifests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	"github.com/martencassel/gobinrepo/internal/util/filestore"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// MaxManifestSize bounds how much of a manifest body is read into memory.
+const MaxManifestSize = 4 << 20
+
+// ErrNotFound is returned when a manifest is not present in the store.
+var ErrNotFound = errors.New("manifest not found")
+
+// Descriptor records what is known about a cached manifest besides its bytes.
+type Descriptor struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	Size      int64         `json:"size"`
+}
+
+// Store keeps manifests content-addressed in a BlobStore and records the
+// media type and size of each one in a descriptor file alongside. Tags are
+// kept as links from repoKey/name to manifest digests.
+type Store struct {
+	blobs    blobs.BlobStore
+	links    filestore.FileStore
+	basePath string
+	mu       sync.Mutex
+}
+
+// NewStore creates a manifest store writing content to blobs and descriptors
+// and links under basePath.
+func NewStore(blobs blobs.BlobStore, basePath string) (*Store, error) {
+	if err := os.MkdirAll(basePath, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{
+		blobs:    blobs,
+		links:    filestore.NewFileStore(filepath.Join(basePath, "repositories")),
+		basePath: basePath,
+	}, nil
+}
+
+func (s *Store) descriptorPath(d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil {
+		return "", fmt.Errorf("invalid digest %q: %w", d, err)
+	}
+	return filepath.Join(s.basePath, "descriptors", d.Algorithm().String(), d.Encoded()+".json"), nil
+}
+
+// Put stores data under its digest and records the media type alongside.
+// Storing a manifest that is already present only refreshes its descriptor.
+func (s *Store) Put(ctx context.Context, mediaType string, data []byte) (Descriptor, error) {
+	desc := Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	// Manifests are small; serializing writers keeps concurrent pulls of the
+	// same manifest from racing on the blob store's partial file.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.blobs.Exists(ctx, desc.Digest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if !exists {
+		w, err := s.blobs.WriterAtomic(ctx, desc.Digest)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		if _, err := w.Write(data); err != nil {
+			_ = w.Close()
+			return Descriptor{}, err
+		}
+		if err := w.Close(); err != nil {
+			return Descriptor{}, err
+		}
+	}
+	if err := s.writeDescriptor(desc); err != nil {
+		return Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func (s *Store) writeDescriptor(desc Descriptor) error {
+	p, err := s.descriptorPath(desc.Digest)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, data)
+}
+
+// writeFileAtomic replaces the file at p with data, so readers never see a
+// partial file.
+func writeFileAtomic(p string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Stat returns the descriptor of a stored manifest, or ErrNotFound.
+func (s *Store) Stat(ctx context.Context, d digest.Digest) (Descriptor, error) {
+	p, err := s.descriptorPath(d)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	raw, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return Descriptor{}, ErrNotFound
+	}
+	if err != nil {
+		return Descriptor{}, err
+	}
+	var desc Descriptor
+	if err := json.Unmarshal(raw, &desc); err != nil {
+		return Descriptor{}, fmt.Errorf("invalid descriptor for %s: %w", d, err)
+	}
+	exists, err := s.blobs.Exists(ctx, d)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if !exists {
+		return Descriptor{}, ErrNotFound
+	}
+	return desc, nil
+}
+
+// Get returns the descriptor and content of a stored manifest, or ErrNotFound.
+func (s *Store) Get(ctx context.Context, d digest.Digest) (Descriptor, []byte, error) {
+	desc, err := s.Stat(ctx, d)
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	r, err := s.blobs.Get(ctx, d)
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(r, MaxManifestSize+1)); err != nil {
+		return Descriptor{}, nil, err
+	}
+	if int64(buf.Len()) != desc.Size {
+		return Descriptor{}, nil, fmt.Errorf("stored manifest %s has size %d, want %d", d, buf.Len(), desc.Size)
+	}
+	return desc, buf.Bytes(), nil
+}
@@ -0,0 +1,140 @@
+package manifests
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStore(t *testing.T) *Store {
+	dir := t.TempDir()
+	bfs, err := blobs.NewBlobStoreFS(filepath.Join(dir, "blobs"))
+	assert.NoError(t, err)
+	s, err := NewStore(bfs, filepath.Join(dir, "manifests"))
+	assert.NoError(t, err)
+	return s
+}
+
+func TestStorePutGet(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	data := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+
+	desc, err := s.Put(ctx, v1.MediaTypeImageIndex, data)
+	assert.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(data), desc.Digest)
+	assert.Equal(t, int64(len(data)), desc.Size)
+
+	got, content, err := s.Get(ctx, desc.Digest)
+	assert.NoError(t, err)
+	assert.Equal(t, desc, got)
+	assert.Equal(t, data, content)
+
+	// Storing the same content again is a no-op for the blob.
+	_, err = s.Put(ctx, v1.MediaTypeImageIndex, data)
+	assert.NoError(t, err)
+}
+
+func TestStoreNotFound(t *testing.T) {
+	s := newTestStore(t)
+	_, err := s.Stat(context.Background(), digest.FromString("missing"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, _, err = s.Get(context.Background(), digest.FromString("missing"))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStoreRepositories(t *testing.T) {
+	s := newTestStore(t)
+	d := digest.FromString("manifest")
+	assert.NoError(t, s.PutTag("hub", "library/nginx", "latest", d))
+	assert.NoError(t, s.PutRevision("hub", "library", d))
+	assert.NoError(t, s.PutTag("hub", "org/team/app", "v1", d))
+	assert.NoError(t, s.PutLayer("hub", "blobonly", d))
+	assert.NoError(t, s.PutTag("other", "app", "v1", d))
+
+	names, err := s.Repositories("hub")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"library", "library/nginx", "org/team/app"}, names)
+
+	names, err = s.Repositories("empty")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestStoreMovedTags(t *testing.T) {
+	s := newTestStore(t)
+	pinned, moved := digest.FromString("pinned"), digest.FromString("moved")
+	assert.NoError(t, s.PutTag("hub", "org/app", "1.0", pinned))
+	assert.NoError(t, s.PutPin("hub", "org/app", "1.0", pinned))
+	assert.NoError(t, s.PutTag("hub", "org/app", "2.0", pinned))
+	assert.NoError(t, s.PutPin("hub", "org/app", "2.0", pinned))
+	assert.NoError(t, s.PutPending("hub", "org/app", "1.0", moved))
+
+	got, err := s.MovedTags("hub")
+	assert.NoError(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "org/app", got[0].Name)
+		assert.Equal(t, "1.0", got[0].Tag)
+		assert.Equal(t, pinned, got[0].Pinned)
+		assert.Equal(t, moved, got[0].Pending)
+		assert.False(t, got[0].Detected.IsZero())
+	}
+
+	assert.NoError(t, s.DeletePending("hub", "org/app", "1.0"))
+	assert.NoError(t, s.DeletePending("hub", "org/app", "1.0"))
+	got, err = s.MovedTags("hub")
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestStoreTagVariants(t *testing.T) {
+	s := newTestStore(t)
+	index, amd64 := digest.FromString("index"), digest.FromString("amd64")
+	const docker = "application/vnd.docker.distribution.manifest.v2+json"
+	assert.NoError(t, s.PutTag("hub", "org/app", "1.0", index))
+	assert.NoError(t, s.PutTagVariant("hub", "org/app", "1.0", docker, amd64))
+
+	link, found, err := s.GetTagVariant("hub", "org/app", "1.0", docker)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, amd64, link.Digest)
+	link, found, err = s.GetTag("hub", "org/app", "1.0")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, index, link.Digest)
+	_, found, err = s.GetTagVariant("hub", "org/app", "1.0", "application/vnd.oci.image.manifest.v1+json")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, s.DeleteTag("hub", "org/app", "1.0"))
+	_, found, err = s.GetTagVariant("hub", "org/app", "1.0", docker)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestStoreInventory(t *testing.T) {
+	s := newTestStore(t)
+	ctx := t.Context()
+	desc, err := s.Put(ctx, "application/vnd.oci.image.manifest.v1+json", []byte(`{"schemaVersion":2}`))
+	assert.NoError(t, err)
+
+	_, err = s.GetInventory(desc.Digest)
+	assert.ErrorIs(t, err, ErrNoInventory)
+	assert.False(t, s.HasInventory(desc.Digest))
+
+	assert.NoError(t, s.PutInventory(desc.Digest, []byte(`{"packages":[]}`)))
+	data, err := s.GetInventory(desc.Digest)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"packages":[]}`, string(data))
+	assert.True(t, s.HasInventory(desc.Digest))
+
+	// The inventory lives beside the descriptor without disturbing it.
+	got, err := s.Stat(ctx, desc.Digest)
+	assert.NoError(t, err)
+	assert.Equal(t, desc, got)
+}
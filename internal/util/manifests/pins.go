@@ -0,0 +1,102 @@
+package manifests
+
+import (
+	"fmt"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// MovedTag is a tag whose upstream digest differs from the pinned one and
+// waits for approval.
+type MovedTag struct {
+	Name     string        `json:"name"`
+	Tag      string        `json:"tag"`
+	Pinned   digest.Digest `json:"pinned"`
+	Pending  digest.Digest `json:"pending"`
+	Detected time.Time     `json:"detected"`
+}
+
+// PutPin records d as the trusted digest of tag: the first one seen, or one
+// approved later.
+func (s *Store) PutPin(repoKey, name, tag string, d digest.Digest) error {
+	return s.links.Put(repoKey, tagStateLinkPath(name, tag, "pinned"), d.String())
+}
+
+// GetPin returns the trusted digest of tag.
+func (s *Store) GetPin(repoKey, name, tag string) (TagLink, bool, error) {
+	return s.getTagState(repoKey, name, tag, "pinned")
+}
+
+// PutPending records that upstream moved tag to d.
+func (s *Store) PutPending(repoKey, name, tag string, d digest.Digest) error {
+	return s.links.Put(repoKey, tagStateLinkPath(name, tag, "pending"), d.String())
+}
+
+// GetPending returns the digest tag moved to upstream, if it awaits approval.
+func (s *Store) GetPending(repoKey, name, tag string) (TagLink, bool, error) {
+	return s.getTagState(repoKey, name, tag, "pending")
+}
+
+// DeletePending drops a pending move. Deleting one that does not exist is not
+// an error.
+func (s *Store) DeletePending(repoKey, name, tag string) error {
+	return unlink(s.links.Delete(repoKey, tagStateLinkPath(name, tag, "pending")))
+}
+
+// MovedTags lists the pending moves of every repository of repoKey.
+func (s *Store) MovedTags(repoKey string) ([]MovedTag, error) {
+	names, err := s.Repositories(repoKey)
+	if err != nil {
+		return nil, err
+	}
+	var moved []MovedTag
+	for _, name := range names {
+		tags, err := s.Tags(repoKey, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			pending, found, err := s.GetPending(repoKey, name, tag)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			pin, _, err := s.GetPin(repoKey, name, tag)
+			if err != nil {
+				return nil, err
+			}
+			moved = append(moved, MovedTag{
+				Name:     name,
+				Tag:      tag,
+				Pinned:   pin.Digest,
+				Pending:  pending.Digest,
+				Detected: pending.Checked,
+			})
+		}
+	}
+	return moved, nil
+}
+
+func (s *Store) getTagState(repoKey, name, tag, state string) (TagLink, bool, error) {
+	return s.readTagLink(repoKey, name, tag, tagStateLinkPath(name, tag, state), state)
+}
+
+// readTagLink reads the link at p, one of the links kept for tag.
+func (s *Store) readTagLink(repoKey, name, tag, p, kind string) (TagLink, bool, error) {
+	raw, found, err := s.links.Get(repoKey, p)
+	if err != nil || !found {
+		return TagLink{}, false, err
+	}
+	d, err := digest.Parse(raw)
+	if err != nil {
+		return TagLink{}, false, fmt.Errorf("invalid %s link %s/%s:%s: %w", kind, repoKey, name, tag, err)
+	}
+	modified, _, err := s.links.ModTime(repoKey, p)
+	if err != nil {
+		return TagLink{}, false, err
+	}
+	return TagLink{Digest: d, Checked: modified}, true, nil
+}
@@ -0,0 +1,76 @@
+package manifests
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+func referrerLinkPath(name string, subject, d digest.Digest) string {
+	return path.Join(name, "_referrers", subject.Algorithm().String(), subject.Encoded(), d.Algorithm().String()+"-"+d.Encoded(), "link")
+}
+
+func referrersIndexLinkPath(name string, subject digest.Digest) string {
+	return path.Join(name, "_referrers", subject.Algorithm().String(), subject.Encoded(), "index", "link")
+}
+
+// PutReferrer records that manifest d in repository name of repoKey refers
+// to subject.
+func (s *Store) PutReferrer(repoKey, name string, subject, d digest.Digest) error {
+	return s.links.Put(repoKey, referrerLinkPath(name, subject, d), d.String())
+}
+
+// Referrers lists the manifests recorded as referring to subject, sorted.
+func (s *Store) Referrers(repoKey, name string, subject digest.Digest) ([]digest.Digest, error) {
+	dir := path.Join(name, "_referrers", subject.Algorithm().String(), subject.Encoded())
+	entries, err := s.links.Children(repoKey, dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []digest.Digest
+	for _, e := range entries {
+		if e == "index" {
+			continue
+		}
+		raw, found, err := s.links.Get(repoKey, path.Join(dir, e, "link"))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		d, err := digest.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid referrer link %s/%s/%s: %w", repoKey, name, e, err)
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, nil
+}
+
+// PutReferrersIndex records the referrers index last fetched upstream for
+// subject. Writing it again marks it as freshly checked.
+func (s *Store) PutReferrersIndex(repoKey, name string, subject, index digest.Digest) error {
+	return s.links.Put(repoKey, referrersIndexLinkPath(name, subject), index.String())
+}
+
+// GetReferrersIndex returns the cached referrers index of subject.
+func (s *Store) GetReferrersIndex(repoKey, name string, subject digest.Digest) (TagLink, bool, error) {
+	p := referrersIndexLinkPath(name, subject)
+	raw, found, err := s.links.Get(repoKey, p)
+	if err != nil || !found {
+		return TagLink{}, false, err
+	}
+	d, err := digest.Parse(raw)
+	if err != nil {
+		return TagLink{}, false, fmt.Errorf("invalid referrers link %s/%s@%s: %w", repoKey, name, subject, err)
+	}
+	checked, _, err := s.links.ModTime(repoKey, p)
+	if err != nil {
+		return TagLink{}, false, err
+	}
+	return TagLink{Digest: d, Checked: checked}, true, nil
+}
@@ -0,0 +1,99 @@
+package manifests
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// TagLink is the last known resolution of a tag to a manifest digest.
+type TagLink struct {
+	Digest digest.Digest
+	// Checked is when the link was last written or confirmed against upstream.
+	Checked time.Time
+}
+
+func tagLinkPath(name, tag string) string {
+	return tagStateLinkPath(name, tag, "current")
+}
+
+// tagStateLinkPath is the link holding one state of a tag: current is what
+// the tag resolves to, pinned the digest it is held at and pending an
+// upstream digest it moved to that awaits approval.
+func tagStateLinkPath(name, tag, state string) string {
+	return path.Join(name, "_manifests", "tags", tag, state, "link")
+}
+
+// tagVariantLinkPath is the link holding what tag resolved to for a client
+// accepting only mediaType, next to the tag's current resolution.
+func tagVariantLinkPath(name, tag, mediaType string) string {
+	return path.Join(name, "_manifests", "tags", tag, "variants", url.PathEscape(mediaType), "link")
+}
+
+// PutTag records that tag in repository name of repoKey resolves to d.
+// Writing the same digest again marks the link as freshly checked.
+func (s *Store) PutTag(repoKey, name, tag string, d digest.Digest) error {
+	return s.links.Put(repoKey, tagLinkPath(name, tag), d.String())
+}
+
+// GetTag returns the last known resolution of a tag.
+func (s *Store) GetTag(repoKey, name, tag string) (TagLink, bool, error) {
+	p := tagLinkPath(name, tag)
+	raw, found, err := s.links.Get(repoKey, p)
+	if err != nil || !found {
+		return TagLink{}, false, err
+	}
+	d, err := digest.Parse(raw)
+	if err != nil {
+		return TagLink{}, false, fmt.Errorf("invalid tag link %s/%s:%s: %w", repoKey, name, tag, err)
+	}
+	checked, _, err := s.links.ModTime(repoKey, p)
+	if err != nil {
+		return TagLink{}, false, err
+	}
+	return TagLink{Digest: d, Checked: checked}, true, nil
+}
+
+// PutTagVariant records that tag resolves to d, of mediaType, for clients
+// that do not accept the media type of its current resolution.
+func (s *Store) PutTagVariant(repoKey, name, tag, mediaType string, d digest.Digest) error {
+	return s.links.Put(repoKey, tagVariantLinkPath(name, tag, mediaType), d.String())
+}
+
+// GetTagVariant returns what tag resolved to for clients accepting mediaType.
+func (s *Store) GetTagVariant(repoKey, name, tag, mediaType string) (TagLink, bool, error) {
+	return s.readTagLink(repoKey, name, tag, tagVariantLinkPath(name, tag, mediaType), mediaType)
+}
+
+// DeleteTag removes a tag, with its variants, from repository name of
+// repoKey. Deleting a tag that does not exist is not an error.
+func (s *Store) DeleteTag(repoKey, name, tag string) error {
+	variants, err := s.links.Children(repoKey, path.Join(name, "_manifests", "tags", tag, "variants"))
+	if err != nil {
+		return err
+	}
+	for _, v := range variants {
+		mediaType, err := url.PathUnescape(v)
+		if err != nil {
+			continue
+		}
+		if err := unlink(s.links.Delete(repoKey, tagVariantLinkPath(name, tag, mediaType))); err != nil {
+			return err
+		}
+	}
+	return unlink(s.links.Delete(repoKey, tagLinkPath(name, tag)))
+}
+
+// Tags lists the tags known for repository name of repoKey, sorted.
+func (s *Store) Tags(repoKey, name string) ([]string, error) {
+	tags, err := s.links.Children(repoKey, path.Join(name, "_manifests", "tags"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
@@ -2,15 +2,32 @@ package oci
 
 import "net/http"
 
+// credentialKey carries the Credential a TokenRoundTripper picked for a
+// request, so the BasicAuthRoundTripper below it authenticates as the same
+// account.
+type credentialKey struct{}
+
 type BasicAuthRoundTripper struct {
 	Username string
 	Password string
 	Base     http.RoundTripper
 }
 
+// RoundTrip adds Basic credentials unless the request is already authorized,
+// so a bearer token set further up the chain is not overwritten. A credential
+// chosen by a TokenRoundTripper takes precedence over Username and Password;
+// one holding an identity token only works through a bearer token.
 func (rt *BasicAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	if rt.Username != "" {
-		req.SetBasicAuth(rt.Username, rt.Password)
+	username, password := rt.Username, rt.Password
+	if cred, ok := req.Context().Value(credentialKey{}).(Credential); ok {
+		username, password = cred.Username, cred.Password
+		if cred.IdentityToken != "" {
+			username = ""
+		}
+	}
+	if username != "" && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(username, password)
 	}
 	return rt.Base.RoundTrip(req)
 }
@@ -5,10 +5,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 
-	"golang.org/x/sync/singleflight"
-
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 )
@@ -16,29 +15,47 @@ import (
 type RegistryClient struct {
 	httpClient *http.Client
 	baseURL    string
+
+	// redirectTransport sends hops to hosts other than the registry.
+	redirectTransport http.RoundTripper
+	maxRedirects      int
+	redirects         *redirectCache
 }
 
 // NewRegistryClient constructs a client that talks to an upstream registry
-// using the provided TokenRoundTripper for Bearer-token auth.
-func NewRegistryClient(baseURL string, rt http.RoundTripper, opts ...func(*http.Client)) *RegistryClient {
+// using the provided TokenRoundTripper for Bearer-token auth. Redirects are
+// followed by the client itself; see WithRedirectTransport.
+func NewRegistryClient(baseURL string, rt http.RoundTripper, opts ...RegistryClientOption) *RegistryClient {
 	if rt == nil {
 		defaultRT := http.DefaultTransport
 		rt = defaultRT
 	}
 	client := &http.Client{
-		Transport: rt,
-		Timeout:   0,
+		Transport:     rt,
+		Timeout:       0,
+		CheckRedirect: noFollow,
+	}
+	c := &RegistryClient{
+		httpClient:        client,
+		baseURL:           strings.TrimSuffix(baseURL, "/"),
+		redirectTransport: http.DefaultTransport,
+		maxRedirects:      defaultMaxRedirects,
 	}
 	for _, opt := range opts {
 		if opt != nil {
-			opt(client)
+			opt(c)
 		}
 	}
-	return &RegistryClient{
-		httpClient: client,
-		baseURL:    strings.TrimSuffix(baseURL, "/"),
-	}
+	return c
+}
 
+// host is the host of the registry, the only one credentials are sent to.
+func (c *RegistryClient) host() string {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
 }
 
 // Ping checks if the registry is alive.
@@ -47,7 +64,7 @@ func (c *RegistryClient) Ping(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
@@ -89,9 +106,9 @@ func (c *RegistryClient) FetchManifest(ctx context.Context, repo, reference stri
 		copyForwardHeaders(req.Header, hdr)
 	}
 	if req.Header.Get("Accept") == "" {
-		req.Header.Set("Accept", v1.MediaTypeImageManifest)
+		req.Header.Set("Accept", ManifestAccept)
 	}
-	return c.httpClient.Do(req)
+	return c.do(req)
 }
 
 // GetBlob fetches a blob (layer) from the registry and streams it into the provided writer.
@@ -103,6 +120,9 @@ func (c *RegistryClient) GetBlob(ctx context.Context, repo, digest string, hdr h
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Warnf("failed to close response body: %v", cerr)
+		}
 		return nil, fmt.Errorf("blob fetch failed (%s): %s", digest, resp.Status)
 	}
 	return resp, nil
@@ -117,7 +137,7 @@ func (c *RegistryClient) FetchBlob(ctx context.Context, repo, digest string, hdr
 		return nil, err
 	}
 	copyForwardHeaders(req.Header, hdr)
-	return c.httpClient.Do(req)
+	return c.do(req)
 }
 
 // GetManifest fetches and decodes an image manifest into an OCIManifest struct.
@@ -143,7 +163,34 @@ func (c *RegistryClient) HeadManifest(ctx context.Context, repo, reference strin
 		return nil, err
 	}
 	copyForwardHeaders(req.Header, hdr)
-	return c.httpClient.Do(req)
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", ManifestAccept)
+	}
+	return c.do(req)
+}
+
+// HeadBlob performs a HEAD request for the specified blob.
+func (c *RegistryClient) HeadBlob(ctx context.Context, repo, digest string, hdr http.Header) (*http.Response, error) {
+	u := c.baseURL + "/v2/" + repo + "/blobs/" + digest
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	copyForwardHeaders(req.Header, hdr)
+	return c.do(req)
+}
+
+// FetchReferrers retrieves the referrers index of a manifest through the OCI
+// Referrers API. The caller is responsible for closing resp.Body.
+func (c *RegistryClient) FetchReferrers(ctx context.Context, repo, digest string, hdr http.Header) (*http.Response, error) {
+	u := c.baseURL + "/v2/" + repo + "/referrers/" + digest
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	copyForwardHeaders(req.Header, hdr)
+	req.Header.Set("Accept", v1.MediaTypeImageIndex)
+	return c.do(req)
 }
 
 // ForwardRequest is a generic method to forward an arbitrary downstream request to the upstream registry,
@@ -154,7 +201,7 @@ func (c *RegistryClient) ForwardRequest(ctx context.Context, method, upstreamPat
 		return nil, err
 	}
 	copyForwardHeaders(req.Header, hdr)
-	return c.httpClient.Do(req)
+	return c.do(req)
 }
 
 func (c *RegistryClient) StreamAndCache(
@@ -306,19 +353,6 @@ func (c *RegistryClient) StreamAndCacheWithRetry(
 	return lastErr
 }
 
-var g singleflight.Group
-
-func (c *RegistryClient) FetchBlobOnce(ctx context.Context, repo, digest string, hdr http.Header) (*http.Response, error) {
-	key := repo + "@" + digest
-	v, err, _ := g.Do(key, func() (interface{}, error) {
-		return c.FetchBlob(ctx, repo, digest, hdr)
-	})
-	if err != nil {
-		return nil, err
-	}
-	return v.(*http.Response), nil
-}
-
 func (c *RegistryClient) GetTagList(ctx context.Context, repo string, hdr http.Header) (*http.Response, error) {
 	u := c.baseURL + "/v2/" + repo + "/tags/list"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
@@ -326,5 +360,5 @@ func (c *RegistryClient) GetTagList(ctx context.Context, repo string, hdr http.H
 		return nil, err
 	}
 	copyForwardHeaders(req.Header, hdr)
-	return c.httpClient.Do(req)
+	return c.do(req)
 }
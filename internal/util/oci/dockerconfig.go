@@ -0,0 +1,148 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// dockerHubAuthKey is the key docker login stores Docker Hub credentials
+// under, whichever registry host the pulls go to.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// helperTokenUsername is the username a credential helper returns when the
+// secret is an identity token rather than a password.
+const helperTokenUsername = "<token>"
+
+// errHelperNotFound is the message credential helpers answer get with when
+// they hold nothing for a server.
+const errHelperNotFound = "credentials not found in native keychain"
+
+// DockerConfig is the part of a docker config.json holding credentials.
+type DockerConfig struct {
+	Auths       map[string]DockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+// DockerAuth is an auths entry of a docker config.json.
+type DockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// LoadDockerConfig reads a docker config.json.
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read docker config: %w", err)
+	}
+	var cfg DockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Credential returns the credential docker would use for registry, a host or
+// URL such as https://registry-1.docker.io: a credHelpers entry for the host
+// first, then the credsStore, then the auths entry. found is false when the
+// config holds nothing for the registry.
+func (c *DockerConfig) Credential(ctx context.Context, registry string) (cred Credential, found bool, err error) {
+	host := registryHost(registry)
+	if helper := c.CredHelpers[host]; helper != "" {
+		return HelperCredential(ctx, helper, CredentialServerURL(registry))
+	}
+	if c.CredsStore != "" {
+		cred, found, err := HelperCredential(ctx, c.CredsStore, CredentialServerURL(registry))
+		if err != nil || found {
+			return cred, found, err
+		}
+	}
+	for key, auth := range c.Auths {
+		if registryHost(key) != host {
+			continue
+		}
+		return auth.credential()
+	}
+	return Credential{}, false, nil
+}
+
+func (a DockerAuth) credential() (Credential, bool, error) {
+	cred := Credential{Username: a.Username, Password: a.Password, IdentityToken: a.IdentityToken}
+	if a.Auth != "" {
+		raw, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return Credential{}, false, fmt.Errorf("invalid auth: %w", err)
+		}
+		user, pass, ok := strings.Cut(string(raw), ":")
+		if !ok {
+			return Credential{}, false, errors.New("invalid auth: no colon")
+		}
+		cred.Username, cred.Password = user, pass
+	}
+	return cred, cred != Credential{}, nil
+}
+
+// HelperCredential asks docker-credential-<helper> for the credential of
+// serverURL, using the get command of the credential helper protocol.
+func HelperCredential(ctx context.Context, helper, serverURL string) (Credential, bool, error) {
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(msg, errHelperNotFound) {
+			return Credential{}, false, nil
+		}
+		return Credential{}, false, fmt.Errorf("docker-credential-%s: %w: %s", helper, err, msg)
+	}
+	var out struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Credential{}, false, fmt.Errorf("docker-credential-%s: invalid output: %w", helper, err)
+	}
+	if out.Username == helperTokenUsername {
+		return Credential{IdentityToken: out.Secret}, true, nil
+	}
+	return Credential{Username: out.Username, Password: out.Secret}, true, nil
+}
+
+// registryHost reduces a registry URL or auths key to its host, mapping the
+// Docker Hub hosts to the one docker login uses.
+func registryHost(registry string) string {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+	host := registry
+	if u, err := url.Parse(registry); err == nil {
+		host = u.Host
+	}
+	switch host {
+	case "registry-1.docker.io", "docker.io", "index.docker.io":
+		return "index.docker.io"
+	}
+	return host
+}
+
+// CredentialServerURL is the server URL docker login stores the credential
+// of registry under, and so the one to ask credential helpers for.
+func CredentialServerURL(registry string) string {
+	host := registryHost(registry)
+	if host == "index.docker.io" {
+		return dockerHubAuthKey
+	}
+	return host
+}
@@ -0,0 +1,134 @@
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHelper installs docker-credential-<name> on PATH, answering get with
+// out and recording the server URL it was asked for in a file it returns.
+func fakeHelper(t *testing.T, name, out string) string {
+	t.Helper()
+	dir := t.TempDir()
+	asked := filepath.Join(dir, "asked")
+	script := "#!/bin/sh\ncat > " + asked + "\necho '" + out + "'\n"
+	if out == "" {
+		script = "#!/bin/sh\ncat > " + asked + "\necho 'credentials not found in native keychain'\nexit 1\n"
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docker-credential-"+name), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return asked
+}
+
+func TestDockerConfigCredential(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("alice:s3cret"))
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "`+auth+`"},
+			"ghcr.io": {"identitytoken": "refresh-me"}
+		},
+		"credHelpers": {"registry.example.com": "fake"}
+	}`), 0o600))
+	dc, err := LoadDockerConfig(path)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	cred, found, err := dc.Credential(ctx, "https://registry-1.docker.io")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Credential{Username: "alice", Password: "s3cret"}, cred)
+
+	cred, found, err = dc.Credential(ctx, "https://ghcr.io")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Credential{IdentityToken: "refresh-me"}, cred)
+
+	_, found, err = dc.Credential(ctx, "https://quay.io")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	asked := fakeHelper(t, "fake", `{"ServerURL":"registry.example.com","Username":"bob","Secret":"pw"}`)
+	cred, found, err = dc.Credential(ctx, "https://registry.example.com/v2/")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Credential{Username: "bob", Password: "pw"}, cred)
+	sent, _ := os.ReadFile(asked)
+	assert.Equal(t, "registry.example.com", string(sent))
+}
+
+func TestHelperCredential(t *testing.T) {
+	asked := fakeHelper(t, "token", `{"ServerURL":"https://index.docker.io/v1/","Username":"<token>","Secret":"idt"}`)
+	cred, found, err := HelperCredential(context.Background(), "token", CredentialServerURL("registry-1.docker.io"))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Credential{IdentityToken: "idt"}, cred)
+	sent, _ := os.ReadFile(asked)
+	assert.Equal(t, "https://index.docker.io/v1/", string(sent))
+
+	fakeHelper(t, "empty", "")
+	_, found, err = HelperCredential(context.Background(), "empty", "ghcr.io")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, _, err = HelperCredential(context.Background(), "missing-helper", "ghcr.io")
+	assert.Error(t, err)
+}
+
+func TestTokenRoundTripperIdentityToken(t *testing.T) {
+	var form map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.NoError(t, r.ParseForm())
+		form = map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		_, _ = w.Write([]byte(`{"access_token":"tok","expires_in":300}`))
+	})
+	var srv *httptest.Server
+	var challenges int
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			challenges++
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="reg",scope="repository:org/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	trt := NewTokenRoundTripper(false, WithCredentials(NewCredentialPool(Credential{IdentityToken: "idt"})))
+	defer trt.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v2/org/app/manifests/latest", nil)
+	resp, err := trt.RoundTrip(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]string{
+		"grant_type":    "refresh_token",
+		"client_id":     "gobinrepo",
+		"refresh_token": "idt",
+		"service":       "reg",
+		"scope":         "repository:org/app:pull",
+	}, form)
+
+	// The token is reused for the repository without another challenge.
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/v2/org/app/manifests/latest", nil)
+	resp, err = trt.RoundTrip(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, challenges)
+}
@@ -0,0 +1,83 @@
+package oci
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Docker manifest media types. Schema 1 is deprecated and only recognised so
+// it can be refused or flagged.
+const (
+	MediaTypeDockerManifest      = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerSchema1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// ManifestMediaTypes are the current manifest kinds, indexes first.
+var ManifestMediaTypes = []string{
+	v1.MediaTypeImageIndex,
+	MediaTypeDockerManifestList,
+	v1.MediaTypeImageManifest,
+	MediaTypeDockerManifest,
+}
+
+// ManifestAccept is the Accept header asking for any current manifest kind.
+var ManifestAccept = strings.Join(ManifestMediaTypes, ", ")
+
+// IsSchema1 reports whether mediaType is a Docker schema 1 manifest.
+func IsSchema1(mediaType string) bool {
+	return mediaType == MediaTypeDockerSchema1 || mediaType == MediaTypeDockerSchema1Signed
+}
+
+// ParseAccept returns the media types of Accept header values, most preferred
+// first. Types refused with q=0 are left out. No values means no preference.
+func ParseAccept(values []string) []string {
+	type entry struct {
+		mediaType string
+		q         float64
+	}
+	var entries []entry
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if raw, ok := params["q"]; ok {
+				if f, err := strconv.ParseFloat(raw, 64); err == nil {
+					q = f
+				}
+			}
+			if q <= 0 {
+				continue
+			}
+			entries = append(entries, entry{mediaType: mt, q: q})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.mediaType
+	}
+	return out
+}
+
+// Acceptable reports whether mediaType satisfies an Accept list from
+// ParseAccept. An empty list accepts anything.
+func Acceptable(accept []string, mediaType string) bool {
+	if len(accept) == 0 {
+		return true
+	}
+	for _, a := range accept {
+		if a == mediaType || a == "*/*" || (a == "application/*" && strings.HasPrefix(mediaType, "application/")) {
+			return true
+		}
+	}
+	return false
+}
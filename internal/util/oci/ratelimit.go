@@ -0,0 +1,221 @@
+package oci
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultThrottle is how long a throttled credential rests when upstream
+// gives no hint when to come back.
+const defaultThrottle = time.Minute
+
+// RateLimit is a pull limit as announced by Docker Hub in the RateLimit-Limit
+// and RateLimit-Remaining headers, e.g. "100;w=21600".
+type RateLimit struct {
+	Limit     int           `json:"limit"`
+	Remaining int           `json:"remaining"`
+	Window    time.Duration `json:"window"`
+	Observed  time.Time     `json:"observed"`
+}
+
+// ParseRateLimit reads the rate limit headers of an upstream response; ok is
+// false when there are none.
+func ParseRateLimit(h http.Header) (rl RateLimit, ok bool) {
+	limit, window, ok := parseRateLimitValue(h.Get("RateLimit-Limit"))
+	if !ok {
+		return RateLimit{}, false
+	}
+	remaining, _, ok := parseRateLimitValue(h.Get("RateLimit-Remaining"))
+	if !ok {
+		return RateLimit{}, false
+	}
+	return RateLimit{Limit: limit, Remaining: remaining, Window: window, Observed: time.Now()}, true
+}
+
+// parseRateLimitValue parses "<count>[;w=<seconds>]".
+func parseRateLimitValue(v string) (int, time.Duration, bool) {
+	count, params, _ := strings.Cut(v, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n < 0 {
+		return 0, 0, false
+	}
+	var window time.Duration
+	for _, p := range strings.Split(params, ";") {
+		k, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+		if k != "w" {
+			continue
+		}
+		if secs, err := strconv.Atoi(val); err == nil && secs > 0 {
+			window = time.Duration(secs) * time.Second
+		}
+	}
+	return n, window, true
+}
+
+// RetryAfter returns how long upstream asks a throttled client to wait, from
+// Retry-After or else the rate limit window, and zero when it does not say.
+func RetryAfter(resp *http.Response) time.Duration {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(time.Until(t), 0)
+		}
+	}
+	if rl, ok := ParseRateLimit(resp.Header); ok && rl.Remaining == 0 {
+		return rl.Window
+	}
+	return 0
+}
+
+// Credential is one account of a remote: a username and password, or an
+// identity token as docker login stores for registries using OAuth2.
+type Credential struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+// account tells credentials apart in token caches; identity tokens of
+// helpers often come without a username.
+func (c Credential) account() string {
+	if c.IdentityToken != "" {
+		return helperTokenUsername + c.Username
+	}
+	return c.Username
+}
+
+// CredentialStatus describes a credential of a pool without its password.
+type CredentialStatus struct {
+	Username       string     `json:"username"`
+	Current        bool       `json:"current"`
+	RateLimit      *RateLimit `json:"rateLimit,omitempty"`
+	ThrottledUntil *time.Time `json:"throttledUntil,omitempty"`
+}
+
+type poolEntry struct {
+	cred      Credential
+	rateLimit *RateLimit
+	throttled time.Time
+}
+
+// CredentialPool holds the credentials a remote may pull with and the rate
+// limit last seen for each. Requests use the current credential; a throttled
+// one is rotated out until upstream allows it again. A pool without
+// credentials holds a single anonymous one, so anonymous limits are tracked
+// the same way.
+type CredentialPool struct {
+	mu      sync.Mutex
+	entries []poolEntry
+	current int
+}
+
+// NewCredentialPool returns a pool of creds.
+func NewCredentialPool(creds ...Credential) *CredentialPool {
+	if len(creds) == 0 {
+		creds = []Credential{{}}
+	}
+	p := &CredentialPool{entries: make([]poolEntry, len(creds))}
+	for i, c := range creds {
+		p.entries[i].cred = c
+	}
+	return p
+}
+
+// Len returns the number of credentials in the pool.
+func (p *CredentialPool) Len() int {
+	return len(p.entries)
+}
+
+// Current returns the credential to use next and its index.
+func (p *CredentialPool) Current() (int, Credential) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current, p.entries[p.current].cred
+}
+
+// Observe records the rate limit upstream reported for credential i.
+func (p *CredentialPool) Observe(i int, rl RateLimit) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries[i].rateLimit = &rl
+}
+
+// Throttle rests credential i for d, or defaultThrottle when d is zero, and
+// makes the next usable credential current. It reports whether one is left.
+func (p *CredentialPool) Throttle(i int, d time.Duration) bool {
+	if d <= 0 {
+		d = defaultThrottle
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.entries[i].throttled = now.Add(d)
+	for n := 1; n <= len(p.entries); n++ {
+		j := (i + n) % len(p.entries)
+		if p.usable(j, now, 0) {
+			p.current = j
+			return true
+		}
+	}
+	return false
+}
+
+// Constrained reports whether no credential may pull more than reserve
+// images right now: every one is throttled or has at most reserve pulls
+// remaining. Such a remote should be spared requests it can answer from
+// cache.
+func (p *CredentialPool) Constrained(reserve int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for i := range p.entries {
+		if p.usable(i, now, reserve) {
+			return false
+		}
+	}
+	return true
+}
+
+// usable reports whether credential i is not throttled and, as far as the
+// last rate limit seen for it tells, has more than reserve pulls left. That
+// limit is trusted for the time one pull takes to free up in its window.
+func (p *CredentialPool) usable(i int, now time.Time, reserve int) bool {
+	e := p.entries[i]
+	if now.Before(e.throttled) {
+		return false
+	}
+	rl := e.rateLimit
+	if rl == nil || rl.Remaining > reserve {
+		return true
+	}
+	rest := defaultThrottle
+	if rl.Limit > 0 && rl.Window > 0 {
+		rest = rl.Window / time.Duration(rl.Limit)
+	}
+	return now.Sub(rl.Observed) >= rest
+}
+
+// Status describes every credential of the pool.
+func (p *CredentialPool) Status() []CredentialStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	out := make([]CredentialStatus, len(p.entries))
+	for i, e := range p.entries {
+		out[i] = CredentialStatus{Username: e.cred.Username, Current: i == p.current}
+		if e.rateLimit != nil {
+			rl := *e.rateLimit
+			out[i].RateLimit = &rl
+		}
+		if now.Before(e.throttled) {
+			until := e.throttled
+			out[i].ThrottledUntil = &until
+		}
+	}
+	return out
+}
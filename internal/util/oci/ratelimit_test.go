@@ -0,0 +1,87 @@
+package oci
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	h := http.Header{}
+	h.Set("RateLimit-Limit", "100;w=21600")
+	h.Set("RateLimit-Remaining", "76;w=21600")
+	rl, ok := ParseRateLimit(h)
+	assert.True(t, ok)
+	assert.Equal(t, 100, rl.Limit)
+	assert.Equal(t, 76, rl.Remaining)
+	assert.Equal(t, 6*time.Hour, rl.Window)
+
+	_, ok = ParseRateLimit(http.Header{})
+	assert.False(t, ok)
+
+	h.Set("RateLimit-Remaining", "0;w=21600")
+	assert.Equal(t, 6*time.Hour, RetryAfter(&http.Response{Header: h}))
+	h.Set("Retry-After", "30")
+	assert.Equal(t, 30*time.Second, RetryAfter(&http.Response{Header: h}))
+}
+
+func TestCredentialPool(t *testing.T) {
+	p := NewCredentialPool(Credential{Username: "a"}, Credential{Username: "b"})
+	i, cred := p.Current()
+	assert.Equal(t, 0, i)
+	assert.Equal(t, "a", cred.Username)
+	assert.False(t, p.Constrained(0))
+
+	p.Observe(1, RateLimit{Limit: 100, Remaining: 5, Window: time.Hour, Observed: time.Now()})
+	assert.True(t, p.Throttle(0, time.Hour))
+	_, cred = p.Current()
+	assert.Equal(t, "b", cred.Username)
+	assert.False(t, p.Constrained(0))
+	assert.True(t, p.Constrained(5))
+
+	assert.False(t, p.Throttle(1, time.Hour))
+	assert.True(t, p.Constrained(0))
+	status := p.Status()
+	assert.NotNil(t, status[0].ThrottledUntil)
+	assert.Equal(t, 5, status[1].RateLimit.Remaining)
+}
+
+func TestTokenRoundTripperRotatesCredentials(t *testing.T) {
+	var users []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		users = append(users, user)
+		w.Header().Set("RateLimit-Limit", "100;w=21600")
+		if user == "a" {
+			w.Header().Set("RateLimit-Remaining", "0;w=21600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("RateLimit-Remaining", "99;w=21600")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	pool := NewCredentialPool(Credential{Username: "a", Password: "x"}, Credential{Username: "b", Password: "y"})
+	trt := NewTokenRoundTripper(false,
+		WithTransport(&BasicAuthRoundTripper{Base: http.DefaultTransport}),
+		WithCredentials(pool),
+	)
+	defer trt.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v2/app/manifests/latest", nil)
+	assert.NoError(t, err)
+	resp, err := trt.RoundTrip(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"a", "b"}, users)
+
+	status := pool.Status()
+	assert.NotNil(t, status[0].ThrottledUntil)
+	assert.True(t, status[1].Current)
+	assert.Equal(t, 99, status[1].RateLimit.Remaining)
+}
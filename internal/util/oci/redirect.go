@@ -0,0 +1,305 @@
+package oci
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxRedirects matches the limit net/http applies.
+	defaultMaxRedirects = 10
+	// redirectExpiryMargin is taken off a redirect target's lifetime so a
+	// cached URL is not used in the seconds before it stops working.
+	redirectExpiryMargin = 30 * time.Second
+)
+
+// RegistryClientOption configures a RegistryClient.
+type RegistryClientOption func(*RegistryClient)
+
+// WithClientOption applies opt to the http.Client talking to the registry.
+func WithClientOption(opt func(*http.Client)) RegistryClientOption {
+	return func(c *RegistryClient) {
+		if opt != nil {
+			opt(c.httpClient)
+		}
+	}
+}
+
+// WithRedirectTransport sets the transport redirects to other hosts, such as
+// the S3 or CDN URLs registries send blob pulls to, are followed with. It
+// must not add registry credentials; those are only sent to the registry.
+func WithRedirectTransport(rt http.RoundTripper) RegistryClientOption {
+	return func(c *RegistryClient) {
+		c.redirectTransport = rt
+	}
+}
+
+// WithMaxRedirects limits how many redirects one request follows; with zero,
+// redirects are returned to the caller.
+func WithMaxRedirects(n int) RegistryClientOption {
+	return func(c *RegistryClient) {
+		c.maxRedirects = n
+	}
+}
+
+// WithRedirectCache remembers where blob requests were redirected to for as
+// long as the target URL stays valid, so repeated pulls of a blob go straight
+// to storage without asking the registry again.
+func WithRedirectCache() RegistryClientOption {
+	return func(c *RegistryClient) {
+		c.redirects = &redirectCache{entries: make(map[string]cachedRedirect)}
+	}
+}
+
+type cachedRedirect struct {
+	location  *url.URL
+	expiresAt time.Time
+}
+
+// redirectCache maps "<method> <registry URL>" to the storage URL upstream
+// redirected it to; presigned URLs are signed for one method.
+type redirectCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedRedirect
+}
+
+func (rc *redirectCache) get(key string) (*url.URL, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expiresAt) {
+		delete(rc.entries, key)
+		return nil, false
+	}
+	return e.location, true
+}
+
+func (rc *redirectCache) put(key string, location *url.URL, expiresAt time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	for k, e := range rc.entries {
+		if now.After(e.expiresAt) {
+			delete(rc.entries, k)
+		}
+	}
+	rc.entries[key] = cachedRedirect{location: location, expiresAt: expiresAt}
+}
+
+func (rc *redirectCache) drop(key string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.entries, key)
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// do sends req to the registry and follows redirects itself. Hops on the
+// registry's host go through the authenticating transport; once a redirect
+// leaves it, Authorization is dropped and the rest of the chain goes through
+// the redirect transport, so credentials and tokens never reach storage
+// hosts. Only blob requests consult the redirect cache.
+func (c *RegistryClient) do(req *http.Request) (*http.Response, error) {
+	cacheable := c.redirects != nil && isBlobRequest(req)
+	key := req.Method + " " + req.URL.String()
+	if cacheable {
+		if location, ok := c.redirects.get(key); ok {
+			next := req.Clone(req.Context())
+			next.URL, next.Host = location, ""
+			resp, err := c.send(next)
+			if err == nil && resp.StatusCode < http.StatusBadRequest && !isRedirect(resp.StatusCode) {
+				return resp, nil
+			}
+			// The target stopped working early; ask the registry again.
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			c.redirects.drop(key)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil || !isRedirect(resp.StatusCode) || c.maxRedirects <= 0 {
+		return resp, err
+	}
+	final, location, lifetime, err := c.follow(req, resp)
+	if err != nil {
+		return nil, err
+	}
+	if cacheable && lifetime > 0 && location != nil && location.Host != c.host() &&
+		final.StatusCode < http.StatusBadRequest {
+		c.redirects.put(key, location, time.Now().Add(lifetime))
+	}
+	return final, nil
+}
+
+// follow follows the redirect resp answered req with, and any after it. It
+// returns the final response, the URL it came from, and how long the last
+// redirect's target may be reused.
+func (c *RegistryClient) follow(req *http.Request, resp *http.Response) (*http.Response, *url.URL, time.Duration, error) {
+	origin := req.URL.String()
+	var location *url.URL
+	var lifetime time.Duration
+	for hops := 0; isRedirect(resp.StatusCode); hops++ {
+		loc, err := resp.Location()
+		if err != nil {
+			// Nothing to follow; the caller sees the redirect as is.
+			return resp, location, 0, nil
+		}
+		_ = resp.Body.Close()
+		if hops == c.maxRedirects {
+			return nil, nil, 0, fmt.Errorf("stopped after %d redirects from %s", c.maxRedirects, origin)
+		}
+		lifetime = redirectLifetime(resp, loc)
+		if req, err = redirectRequest(req, resp.StatusCode, loc); err != nil {
+			return nil, nil, 0, err
+		}
+		if resp, err = c.send(req); err != nil {
+			return nil, nil, 0, err
+		}
+		location = loc
+		log.WithFields(log.Fields{
+			"url":      origin,
+			"location": redactQuery(loc),
+			"hop":      hops + 1,
+			"status":   resp.StatusCode,
+		}).Debug("Followed upstream redirect")
+	}
+	return resp, location, lifetime, nil
+}
+
+// send sends one hop: through the authenticating transport on the
+// registry's host, and without Authorization through the redirect transport
+// anywhere else.
+func (c *RegistryClient) send(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == c.host() {
+		return c.httpClient.Do(req)
+	}
+	req.Header.Del("Authorization")
+	client := &http.Client{
+		Transport:     c.redirectTransport,
+		CheckRedirect: noFollow,
+		Timeout:       c.httpClient.Timeout,
+	}
+	return client.Do(req)
+}
+
+// redirectRequest prepares the request for the hop a redirect with status
+// asks for: 307 and 308 repeat the method and body, the others turn anything
+// but HEAD into a GET without a body, as browsers and net/http do.
+func redirectRequest(req *http.Request, status int, location *url.URL) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	next.URL = location
+	next.Host = ""
+	if status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect {
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("cannot follow %d redirect to %s: request body cannot be replayed", status, redactQuery(location))
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			next.Body = body
+		}
+		return next, nil
+	}
+	if req.Method != http.MethodHead {
+		next.Method = http.MethodGet
+	}
+	next.Body, next.GetBody, next.ContentLength = nil, nil, 0
+	next.Header.Del("Content-Type")
+	next.Header.Del("Content-Length")
+	return next, nil
+}
+
+// redirectLifetime returns how long the target of a redirect may be reused:
+// until the expiry signed into a presigned S3, GCS or CloudFront URL, or for
+// the max-age of the redirect response. Zero means it may not be reused.
+func redirectLifetime(resp *http.Response, location *url.URL) time.Duration {
+	q := location.Query()
+	var expires time.Time
+	switch {
+	case q.Get("X-Amz-Date") != "" && q.Get("X-Amz-Expires") != "":
+		expires = signedExpiry(q.Get("X-Amz-Date"), q.Get("X-Amz-Expires"))
+	case q.Get("X-Goog-Date") != "" && q.Get("X-Goog-Expires") != "":
+		expires = signedExpiry(q.Get("X-Goog-Date"), q.Get("X-Goog-Expires"))
+	case q.Get("Expires") != "":
+		if secs, err := strconv.ParseInt(q.Get("Expires"), 10, 64); err == nil {
+			expires = time.Unix(secs, 0)
+		}
+	}
+	if expires.IsZero() {
+		maxAge, ok := cacheControlMaxAge(resp.Header.Get("Cache-Control"))
+		if !ok {
+			return 0
+		}
+		expires = time.Now().Add(maxAge)
+	}
+	return max(time.Until(expires)-redirectExpiryMargin, 0)
+}
+
+// signedExpiry reads the signing time and lifetime in seconds of a SigV4
+// style presigned URL.
+func signedExpiry(date, lifetime string) time.Time {
+	signed, err := time.Parse("20060102T150405Z", date)
+	if err != nil {
+		return time.Time{}
+	}
+	secs, err := strconv.Atoi(lifetime)
+	if err != nil {
+		return time.Time{}
+	}
+	return signed.Add(time.Duration(secs) * time.Second)
+}
+
+func cacheControlMaxAge(v string) (time.Duration, bool) {
+	for _, directive := range strings.Split(v, ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(k) {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		case "max-age":
+			if secs, err := strconv.Atoi(val); err == nil && secs > 0 {
+				return time.Duration(secs) * time.Second, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// isBlobRequest reports whether req reads a blob, whose content and so
+// whose redirect target do not change.
+func isBlobRequest(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		strings.Contains(req.URL.Path, "/blobs/")
+}
+
+// redactQuery renders u without its query, which carries the signature of
+// presigned URLs.
+func redactQuery(u *url.URL) string {
+	r := *u
+	r.RawQuery = ""
+	return r.String()
+}
+
+func noFollow(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
@@ -0,0 +1,86 @@
+package oci
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryClientRedirects(t *testing.T) {
+	var storageAuth atomic.Value
+	storageAuth.Store("")
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageAuth.Store(r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte("layer"))
+	}))
+	defer storage.Close()
+
+	var registryHits atomic.Int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryHits.Add(1)
+		if user, _, ok := r.BasicAuth(); !ok || user != "alice" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/org/app/blobs/sha256:aaa":
+			// Same host first, then off to storage with a presigned URL.
+			http.Redirect(w, r, "/v2/org/app/blobs/sha256:aaa/data", http.StatusTemporaryRedirect)
+		case "/v2/org/app/blobs/sha256:aaa/data":
+			q := url.Values{
+				"X-Amz-Date":    {time.Now().UTC().Format("20060102T150405Z")},
+				"X-Amz-Expires": {"600"},
+			}
+			http.Redirect(w, r, storage.URL+"/blob?"+q.Encode(), http.StatusTemporaryRedirect)
+		case "/v2/org/app/blobs/sha256:loop":
+			http.Redirect(w, r, r.URL.Path, http.StatusFound)
+		}
+	}))
+	defer registry.Close()
+
+	rt := &BasicAuthRoundTripper{Username: "alice", Password: "pw", Base: http.DefaultTransport}
+	c := NewRegistryClient(registry.URL, rt, WithRedirectCache(), WithMaxRedirects(3))
+	ctx := context.Background()
+
+	resp, err := c.FetchBlob(ctx, "org/app", "sha256:aaa", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, storageAuth.Load(), "credentials must not reach the storage host")
+	assert.Equal(t, int32(2), registryHits.Load())
+
+	// The presigned target is reused without asking the registry.
+	resp, err = c.FetchBlob(ctx, "org/app", "sha256:aaa", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), registryHits.Load())
+
+	_, err = c.FetchBlob(ctx, "org/app", "sha256:loop", nil)
+	assert.ErrorContains(t, err, "stopped after 3 redirects")
+}
+
+func TestRedirectLifetime(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	signed := url.Values{
+		"X-Amz-Date":    {time.Now().UTC().Add(-time.Minute).Format("20060102T150405Z")},
+		"X-Amz-Expires": {"600"},
+	}
+	u, _ := url.Parse("https://s3.example.com/blob?" + signed.Encode())
+	assert.InDelta(t, (9*time.Minute - redirectExpiryMargin).Seconds(), redirectLifetime(resp, u).Seconds(), 2)
+
+	u, _ = url.Parse("https://cdn.example.com/blob?Expires=" + time.Now().Add(-time.Hour).Format("20060102"))
+	assert.Zero(t, redirectLifetime(resp, u))
+
+	u, _ = url.Parse("https://cdn.example.com/blob")
+	assert.Zero(t, redirectLifetime(resp, u))
+	resp.Header.Set("Cache-Control", "public, max-age=3600")
+	assert.InDelta(t, (time.Hour - redirectExpiryMargin).Seconds(), redirectLifetime(resp, u).Seconds(), 2)
+}
@@ -1,6 +1,12 @@
 package oci
 
-import digest "github.com/opencontainers/go-digest"
+import (
+	"regexp"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+var tagRegExp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
 
 type Reference struct {
 	Tag    string
@@ -37,3 +43,8 @@ func (r Reference) IsTag() bool {
 func (r Reference) IsDigest() bool {
 	return r.Digest != ""
 }
+
+// IsValidTag reports whether s is a tag as defined by the distribution spec.
+func IsValidTag(s string) bool {
+	return tagRegExp.MatchString(s)
+}
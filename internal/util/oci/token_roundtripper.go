@@ -27,8 +27,9 @@ func newDefaultTransport() *http.Transport {
 }
 
 type cacheKey struct {
-	service string
-	scope   string
+	service  string
+	scope    string
+	username string
 }
 
 type cachedToken struct {
@@ -43,12 +44,15 @@ type TokenRoundTripper struct {
 	Client          *http.Client
 	Username        string
 	Password        string
+	Credentials     *CredentialPool
 	cache           map[cacheKey]cachedToken
+	scopes          map[string]cacheKey // repository -> token that last authorized it
 	mu              sync.RWMutex
 	timeout         time.Duration
 	stopCh          chan struct{} // to stop cleanup when shutting down
 	cleanupInterval time.Duration
 	closeOnce       sync.Once
+	clientSet       bool // Client was provided explicitly via WithHTTPClient
 }
 type TokenRoundTripperOption func(*TokenRoundTripper)
 
@@ -59,9 +63,18 @@ func WithBasicAuth(username, password string) TokenRoundTripperOption {
 	}
 }
 
+// WithCredentials authenticates with the credentials of pool, rotating to the
+// next one when upstream answers 429 Too Many Requests.
+func WithCredentials(pool *CredentialPool) TokenRoundTripperOption {
+	return func(trt *TokenRoundTripper) {
+		trt.Credentials = pool
+	}
+}
+
 func WithHTTPClient(c *http.Client) TokenRoundTripperOption {
 	return func(trt *TokenRoundTripper) {
 		trt.Client = c
+		trt.clientSet = true
 		if c.Transport != nil {
 			trt.Base = c.Transport
 		}
@@ -85,7 +98,9 @@ func WithTransport(t http.RoundTripper) TokenRoundTripperOption {
 	return func(trt *TokenRoundTripper) {
 		trt.Base = t
 		// also update the client if not overridden separately
-		trt.Client.Transport = t
+		if !trt.clientSet {
+			trt.Client.Transport = t
+		}
 	}
 }
 
@@ -97,17 +112,22 @@ func NewTokenRoundTripper(debug bool, opts ...TokenRoundTripperOption) *TokenRou
 		Debug:           debug,
 		Client:          &http.Client{Timeout: defaultTimeout, Transport: newDefaultTransport()},
 		cache:           make(map[cacheKey]cachedToken),
+		scopes:          make(map[string]cacheKey),
 		stopCh:          make(chan struct{}),
 		cleanupInterval: 10 * time.Minute,
 		timeout:         defaultTimeout,
 	}
 
-	if len(opts) == 1 {
-		return trt
-	}
 	for _, opt := range opts {
 		opt(trt)
 	}
+	if trt.Credentials == nil {
+		var creds []Credential
+		if trt.Username != "" {
+			creds = append(creds, Credential{Username: trt.Username, Password: trt.Password})
+		}
+		trt.Credentials = NewCredentialPool(creds...)
+	}
 	go trt.cleanupLoop()
 	return trt
 }
@@ -138,6 +158,11 @@ func (trt *TokenRoundTripper) pruneExpired() {
 			n++
 		}
 	}
+	for repo, k := range trt.scopes {
+		if _, ok := trt.cache[k]; !ok {
+			delete(trt.scopes, repo)
+		}
+	}
 	if n > 0 {
 		log.Debugf("pruned %d expired tokens from cache\n", n)
 	}
@@ -154,6 +179,9 @@ type Challenge struct {
 	Scopes  []string
 }
 
+// tokenClientID identifies gobinrepo to OAuth2 token endpoints.
+const tokenClientID = "gobinrepo"
+
 var ErrMissingRealm = errors.New("missing realm in challenge")
 
 func ParseChallenge(header string) (*Challenge, error) {
@@ -199,9 +227,64 @@ func applyKV(c *Challenge, key, val string) {
 	}
 }
 
+// requestScope identifies the repository a registry request addresses, so a
+// token obtained for one request can be presented up front on the next.
+func requestScope(req *http.Request) string {
+	path := req.URL.Path
+	for _, marker := range []string{"/manifests/", "/blobs/", "/tags/", "/referrers/"} {
+		if i := strings.LastIndex(path, marker); i >= 0 {
+			return req.URL.Host + path[:i]
+		}
+	}
+	return req.URL.Host + path
+}
+
+// RoundTrip sends req with the current credential of the pool. A 429 answer
+// throttles that credential, and a request without a body is retried once
+// with each other credential still usable.
 func (trt *TokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	// First attempt
-	resp, err := trt.Base.RoundTrip(req)
+	for attempt := 1; ; attempt++ {
+		i, cred := trt.Credentials.Current()
+		resp, err := trt.roundTrip(req, cred)
+		if err != nil {
+			return nil, err
+		}
+		if rl, ok := ParseRateLimit(resp.Header); ok {
+			trt.Credentials.Observe(i, rl)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		rotated := trt.Credentials.Throttle(i, RetryAfter(resp))
+		logger := log.WithFields(log.Fields{"host": req.URL.Host, "username": cred.Username})
+		if !rotated || attempt >= trt.Credentials.Len() || (req.Body != nil && req.Body != http.NoBody) {
+			logger.Warn("Upstream rate limit reached")
+			return resp, nil
+		}
+		logger.Warn("Upstream rate limit reached, rotating credentials")
+		_ = resp.Body.Close()
+	}
+}
+
+// roundTrip sends req authenticated as cred, answering a bearer challenge
+// with a token obtained for cred.
+func (trt *TokenRoundTripper) roundTrip(req *http.Request, cred Credential) (*http.Response, error) {
+	req = req.WithContext(context.WithValue(req.Context(), credentialKey{}, cred))
+	scope := requestScope(req)
+	// First attempt, with the token that last worked for this repository
+	first := req
+	if req.Header.Get("Authorization") == "" {
+		trt.mu.RLock()
+		key, known := trt.scopes[scope]
+		trt.mu.RUnlock()
+		if known && key.username == cred.account() {
+			if token, ok := trt.getCachedToken(key); ok {
+				first = req.Clone(req.Context())
+				first.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+	}
+	resp, err := trt.Base.RoundTrip(first)
 	if err != nil {
 		return nil, err
 	}
@@ -211,47 +294,50 @@ func (trt *TokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 	// Parse challenge
 	var challenge *Challenge
 	for _, hdr := range resp.Header.Values("WWW-Authenticate") {
-		challenge, _ = ParseChallenge(hdr)
+		if c, err := ParseChallenge(hdr); err == nil {
+			challenge = c
+		}
+	}
+	// Without a bearer challenge there is nothing to retry with.
+	if challenge == nil {
+		return resp, nil
 	}
 	// We’re retrying, so discard the 401 body
 	_ = resp.Body.Close()
 	// Fetch token
-	token, err := trt.fetchToken(req.Context(), challenge)
+	token, err := trt.fetchToken(req.Context(), challenge, cred)
 	if err != nil {
 		return nil, err
 	}
+	trt.mu.Lock()
+	trt.scopes[scope] = newCacheKey(challenge.Service, challenge.Scopes, cred.account())
+	trt.mu.Unlock()
 	// Clone request and retry with Authorization header
 	req2 := req.Clone(req.Context())
 	req2.Header.Set("Authorization", "Bearer "+token)
 	return trt.Base.RoundTrip(req2)
 }
 
-func newCacheKey(service string, scopes []string) cacheKey {
+func newCacheKey(service string, scopes []string, username string) cacheKey {
 	sorted := append([]string(nil), scopes...)
 	sort.Strings(sorted)
 	return cacheKey{
-		service: strings.ToLower(strings.TrimSpace(service)),
-		scope:   strings.Join(sorted, " "),
+		service:  strings.ToLower(strings.TrimSpace(service)),
+		scope:    strings.Join(sorted, " "),
+		username: username,
 	}
 }
 
-func (trt *TokenRoundTripper) fetchToken(ctx context.Context, ch *Challenge) (string, error) {
-	key := newCacheKey(ch.Service, ch.Scopes)
+func (trt *TokenRoundTripper) fetchToken(ctx context.Context, ch *Challenge, cred Credential) (string, error) {
+	key := newCacheKey(ch.Service, ch.Scopes, cred.account())
 	if token, ok := trt.getCachedToken(key); ok {
 		log.Debugf("cache hit for %s\n", key)
 		return token, nil
 	}
-	tokenURL, err := buildTokenURL(ch)
+	req, err := newTokenRequest(ctx, ch, cred)
 	if err != nil {
 		return "", err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
-	if err != nil {
-		return "", err
-	}
-	if trt.Username != "" {
-		req.SetBasicAuth(trt.Username, trt.Password)
-	}
 	resp, err := trt.Client.Do(req)
 	if err != nil {
 		return "", err
@@ -272,6 +358,44 @@ func (trt *TokenRoundTripper) fetchToken(ctx context.Context, ch *Challenge) (st
 	return token, nil
 }
 
+// newTokenRequest builds the token request for a challenge. A credential
+// carrying an identity token is exchanged through the OAuth2 refresh token
+// grant, as docker does; any other is sent as Basic credentials to the GET
+// token endpoint.
+func newTokenRequest(ctx context.Context, ch *Challenge, cred Credential) (*http.Request, error) {
+	if cred.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {tokenClientID},
+			"refresh_token": {cred.IdentityToken},
+		}
+		if ch.Service != "" {
+			form.Set("service", ch.Service)
+		}
+		if len(ch.Scopes) > 0 {
+			form.Set("scope", strings.Join(ch.Scopes, " "))
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.Realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+	tokenURL, err := buildTokenURL(ch)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cred.Username != "" {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	return req, nil
+}
+
 func decodeTokenResponse(r io.Reader) (string, time.Time, error) {
 	var body struct {
 		Token       string `json:"token"`
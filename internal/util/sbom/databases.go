@@ -0,0 +1,137 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// stanzas splits data into blocks separated by blank lines, the layout of
+// both the dpkg status file and the apk installed database.
+func stanzas(data []byte) [][]string {
+	var out [][]string
+	var cur []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64<<10), maxDatabaseSize)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(cur) > 0 {
+				out = append(out, cur)
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, line)
+	}
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// parseDpkgStatus lists the installed packages of a dpkg status file.
+// Packages that were removed but left config files behind are skipped; the
+// status files of distroless images have no Status field at all.
+func parseDpkgStatus(data []byte) []Package {
+	var out []Package
+	for _, stanza := range stanzas(data) {
+		fields := map[string]string{}
+		for _, line := range stanza {
+			if line[0] == ' ' || line[0] == '\t' {
+				// Continuation of a multi-line field such as Description.
+				continue
+			}
+			k, v, ok := strings.Cut(line, ":")
+			if ok {
+				fields[k] = strings.TrimSpace(v)
+			}
+		}
+		if fields["Package"] == "" || fields["Version"] == "" {
+			continue
+		}
+		if status, ok := fields["Status"]; ok {
+			words := strings.Fields(status)
+			if len(words) == 0 || words[len(words)-1] != "installed" {
+				continue
+			}
+		}
+		pkg := Package{
+			Type:         TypeDeb,
+			Name:         fields["Package"],
+			Version:      fields["Version"],
+			Architecture: fields["Architecture"],
+		}
+		// Source may carry the source version: "glibc (2.36-9)".
+		if src, _, _ := strings.Cut(fields["Source"], " "); src != pkg.Name {
+			pkg.Source = src
+		}
+		out = append(out, pkg)
+	}
+	return out
+}
+
+// parseApkInstalled lists the packages of an apk installed database, whose
+// lines are single letter keys: P name, V version, A architecture, L
+// license and o origin.
+func parseApkInstalled(data []byte) []Package {
+	var out []Package
+	for _, stanza := range stanzas(data) {
+		pkg := Package{Type: TypeApk}
+		for _, line := range stanza {
+			k, v, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			switch k {
+			case "P":
+				pkg.Name = v
+			case "V":
+				pkg.Version = v
+			case "A":
+				pkg.Architecture = v
+			case "L":
+				pkg.License = v
+			case "o":
+				pkg.Source = v
+			}
+		}
+		if pkg.Name == "" || pkg.Version == "" {
+			continue
+		}
+		if pkg.Source == pkg.Name {
+			pkg.Source = ""
+		}
+		out = append(out, pkg)
+	}
+	return out
+}
+
+// parseOSRelease reads the distribution from an os-release file.
+func parseOSRelease(data []byte) *OS {
+	os := &OS{}
+	for _, line := range strings.Split(string(data), "\n") {
+		k, v, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(v); err == nil {
+			v = unquoted
+		} else {
+			v = strings.Trim(v, `'"`)
+		}
+		switch k {
+		case "ID":
+			os.ID = v
+		case "VERSION_ID":
+			os.VersionID = v
+		case "PRETTY_NAME":
+			os.Name = v
+		}
+	}
+	if os.ID == "" {
+		return nil
+	}
+	return os
+}
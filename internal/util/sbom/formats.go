@@ -0,0 +1,262 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Content types of the rendered documents.
+const (
+	ContentTypeSPDX      = "application/spdx+json"
+	ContentTypeCycloneDX = "application/vnd.cyclonedx+json"
+)
+
+// toolName is the creator recorded in generated documents.
+const toolName = "gobinrepo"
+
+// PURL returns the package URL of p, installed on os.
+func (p Package) PURL(os *OS) string {
+	namespace := "debian"
+	if p.Type == TypeApk {
+		namespace = "alpine"
+	}
+	if os != nil && os.ID != "" {
+		namespace = os.ID
+	}
+	s := "pkg:" + p.Type + "/" + namespace + "/" + url.PathEscape(p.Name) + "@" + url.PathEscape(p.Version)
+	var qualifiers []string
+	if p.Architecture != "" {
+		qualifiers = append(qualifiers, "arch="+url.QueryEscape(p.Architecture))
+	}
+	if os != nil && os.ID != "" && os.VersionID != "" {
+		qualifiers = append(qualifiers, "distro="+url.QueryEscape(os.ID+"-"+os.VersionID))
+	}
+	if len(qualifiers) > 0 {
+		s += "?" + strings.Join(qualifiers, "&")
+	}
+	return s
+}
+
+// marshalDocument renders doc indented and without escaping the & of
+// package URL qualifiers.
+func marshalDocument(doc any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// documentID derives a stable identifier from the image, so documents of
+// the same image share it.
+func (inv *Inventory) documentID() string {
+	return "urn:uuid:" + uuid.NewSHA1(uuid.NameSpaceURL, []byte(inv.Image.String())).String()
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+	Comment  string   `json:"comment,omitempty"`
+}
+
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	LicenseDeclared       string            `json:"licenseDeclared"`
+	SourceInfo            string            `json:"sourceInfo,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// SPDX renders the inventory as an SPDX 2.3 JSON document describing the
+// image name, which contains every package.
+func (inv *Inventory) SPDX(name string) ([]byte, error) {
+	const imageID = "SPDXRef-Image"
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: inv.documentID(),
+		CreationInfo: spdxCreationInfo{
+			Created:  inv.Generated.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + toolName},
+			Comment:  strings.Join(inv.Skipped, "\n"),
+		},
+		Packages: []spdxPackage{{
+			SPDXID:                imageID,
+			Name:                  name,
+			VersionInfo:           inv.Image.String(),
+			DownloadLocation:      "NOASSERTION",
+			LicenseDeclared:       "NOASSERTION",
+			PrimaryPackagePurpose: "CONTAINER",
+			Checksums: []spdxChecksum{{
+				Algorithm:     strings.ToUpper(inv.Image.Algorithm().String()),
+				ChecksumValue: inv.Image.Encoded(),
+			}},
+		}},
+		Relationships: []spdxRelationship{{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: imageID,
+		}},
+	}
+	for i, p := range inv.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		pkg := spdxPackage{
+			SPDXID:           id,
+			Name:             p.Name,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  p.PURL(inv.OS),
+			}},
+		}
+		if p.License != "" {
+			pkg.LicenseDeclared = p.License
+		}
+		if p.Source != "" {
+			pkg.SourceInfo = "built from source package " + p.Source
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      imageID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	return marshalDocument(doc)
+}
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp  string        `json:"timestamp"`
+	Tools      cdxTools      `json:"tools"`
+	Component  cdxComponent  `json:"component"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Licenses   []cdxLicense  `json:"licenses,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CycloneDX renders the inventory as a CycloneDX 1.5 JSON document whose
+// subject is the container image name.
+func (inv *Inventory) CycloneDX(name string) ([]byte, error) {
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: inv.documentID(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: inv.Generated.UTC().Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: toolName}}},
+			Component: cdxComponent{
+				Type:    "container",
+				BOMRef:  inv.Image.String(),
+				Name:    name,
+				Version: inv.Image.String(),
+			},
+		},
+		Components: []cdxComponent{},
+	}
+	for _, s := range inv.Skipped {
+		doc.Metadata.Properties = append(doc.Metadata.Properties, cdxProperty{Name: toolName + ":skipped", Value: s})
+	}
+	if inv.OS != nil {
+		doc.Components = append(doc.Components, cdxComponent{
+			Type:    "operating-system",
+			BOMRef:  "os:" + inv.OS.ID,
+			Name:    inv.OS.ID,
+			Version: inv.OS.VersionID,
+		})
+	}
+	for _, p := range inv.Packages {
+		purl := p.PURL(inv.OS)
+		c := cdxComponent{
+			Type:    "library",
+			BOMRef:  purl,
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    purl,
+		}
+		if p.License != "" {
+			c.Licenses = []cdxLicense{{Expression: p.License}}
+		}
+		if p.Source != "" {
+			c.Properties = append(c.Properties, cdxProperty{Name: toolName + ":source", Value: p.Source})
+		}
+		doc.Components = append(doc.Components, c)
+	}
+	return marshalDocument(doc)
+}
@@ -0,0 +1,60 @@
+// Package sbom builds a package inventory of a container image from its
+// layers, by reading the databases of the distribution's package manager,
+// and renders it as SPDX or CycloneDX JSON.
+package sbom
+
+import (
+	"sort"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Package types, as used in package URLs.
+const (
+	TypeDeb = "deb"
+	TypeApk = "apk"
+)
+
+// Package is one installed package.
+type Package struct {
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture,omitempty"`
+	// Source is the source package the package was built from, when it
+	// differs from the package name.
+	Source  string `json:"source,omitempty"`
+	License string `json:"license,omitempty"`
+}
+
+// OS identifies the distribution of an image, from its os-release file.
+type OS struct {
+	ID        string `json:"id"`
+	VersionID string `json:"versionId,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// Inventory lists the packages installed in an image.
+type Inventory struct {
+	// Image is the digest of the image manifest the inventory describes.
+	Image     digest.Digest `json:"image"`
+	Generated time.Time     `json:"generated"`
+	OS        *OS           `json:"os,omitempty"`
+	Packages  []Package     `json:"packages"`
+	// Skipped names the package databases found in the image whose
+	// packages are not listed, with the reason.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+func sortPackages(pkgs []Package) {
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Type != pkgs[j].Type {
+			return pkgs[i].Type < pkgs[j].Type
+		}
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Architecture < pkgs[j].Architecture
+	})
+}
@@ -0,0 +1,166 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// layer builds an uncompressed layer tar holding files; an empty content
+// makes a directory.
+func layer(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		name, content := files[i], files[i+1]
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if content == "" {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func zstded(t *testing.T, data []byte) []byte {
+	zw, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer zw.Close()
+	return zw.EncodeAll(data, nil)
+}
+
+const dpkgStatusFile = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Source: glibc (2.36-9)
+Version: 2.36-9+deb12u4
+Description: GNU C Library
+ Contains the standard libraries.
+
+Package: base-files
+Status: install ok installed
+Architecture: amd64
+Version: 12.4+deb12u5
+
+Package: old-tool
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+`
+
+const apkInstalledFile = `C:Q1abc=
+P:musl
+V:1.2.4-r2
+A:x86_64
+L:MIT
+o:musl
+
+P:busybox
+V:1.36.1-r15
+A:x86_64
+L:GPL-2.0-only
+o:busybox-src
+`
+
+func TestScannerDpkg(t *testing.T) {
+	s := NewScanner()
+	require.NoError(t, s.AddLayer(bytes.NewReader(gzipped(t, layer(t,
+		"etc/", "",
+		"etc/os-release", "ID=debian\nVERSION_ID=\"12\"\nPRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\n",
+		"./var/lib/dpkg/status", dpkgStatusFile,
+	)))))
+	inv := s.Inventory(digest.FromString("image"))
+
+	assert.Equal(t, &OS{ID: "debian", VersionID: "12", Name: "Debian GNU/Linux 12 (bookworm)"}, inv.OS)
+	assert.Equal(t, []Package{
+		{Type: TypeDeb, Name: "base-files", Version: "12.4+deb12u5", Architecture: "amd64"},
+		{Type: TypeDeb, Name: "libc6", Version: "2.36-9+deb12u4", Architecture: "amd64", Source: "glibc"},
+	}, inv.Packages)
+	assert.Equal(t, "pkg:deb/debian/libc6@2.36-9+deb12u4?arch=amd64&distro=debian-12", inv.Packages[1].PURL(inv.OS))
+}
+
+func TestScannerApkAndWhiteouts(t *testing.T) {
+	s := NewScanner()
+	require.NoError(t, s.AddLayer(bytes.NewReader(zstded(t, layer(t,
+		"etc/os-release", "ID=alpine\nVERSION_ID=3.19.1\n",
+		"lib/apk/db/installed", apkInstalledFile,
+		"var/lib/dpkg/status", dpkgStatusFile,
+		"var/lib/rpm/rpmdb.sqlite", "sqlite",
+	)))))
+	// The upper layer deletes the dpkg database and the whole rpm directory.
+	require.NoError(t, s.AddLayer(bytes.NewReader(layer(t,
+		"var/lib/dpkg/.wh.status", "x",
+		"var/lib/rpm/.wh..wh..opq", "x",
+	))))
+	inv := s.Inventory(digest.FromString("image"))
+
+	assert.Equal(t, []Package{
+		{Type: TypeApk, Name: "busybox", Version: "1.36.1-r15", Architecture: "x86_64", Source: "busybox-src", License: "GPL-2.0-only"},
+		{Type: TypeApk, Name: "musl", Version: "1.2.4-r2", Architecture: "x86_64", License: "MIT"},
+	}, inv.Packages)
+	assert.Empty(t, inv.Skipped)
+	assert.Equal(t, "pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64&distro=alpine-3.19.1", inv.Packages[1].PURL(inv.OS))
+}
+
+func TestScannerDistrolessAndRPM(t *testing.T) {
+	s := NewScanner()
+	require.NoError(t, s.AddLayer(bytes.NewReader(layer(t,
+		"var/lib/dpkg/status.d/tzdata", "Package: tzdata\nVersion: 2024a-0+deb12u1\nArchitecture: all\n",
+		"var/lib/dpkg/status.d/tzdata.md5sums", "d41d8cd98f00b204e9800998ecf8427e  usr/share/zoneinfo/UTC\n",
+		"usr/lib/sysimage/rpm/rpmdb.sqlite", "sqlite",
+	))))
+	inv := s.Inventory(digest.FromString("image"))
+
+	assert.Nil(t, inv.OS)
+	assert.Equal(t, []Package{{Type: TypeDeb, Name: "tzdata", Version: "2024a-0+deb12u1", Architecture: "all"}}, inv.Packages)
+	assert.Equal(t, []string{"usr/lib/sysimage/rpm/rpmdb.sqlite: rpm databases are not read"}, inv.Skipped)
+}
+
+func TestDocuments(t *testing.T) {
+	inv := &Inventory{
+		Image:    digest.FromString("image"),
+		OS:       &OS{ID: "alpine", VersionID: "3.19.1"},
+		Packages: []Package{{Type: TypeApk, Name: "musl", Version: "1.2.4-r2", Architecture: "x86_64", License: "MIT"}},
+	}
+
+	raw, err := inv.SPDX("hub/library/alpine@" + inv.Image.String())
+	require.NoError(t, err)
+	var spdx spdxDocument
+	require.NoError(t, json.Unmarshal(raw, &spdx))
+	assert.Equal(t, "SPDX-2.3", spdx.SPDXVersion)
+	require.Len(t, spdx.Packages, 2)
+	assert.Equal(t, "CONTAINER", spdx.Packages[0].PrimaryPackagePurpose)
+	assert.Equal(t, "MIT", spdx.Packages[1].LicenseDeclared)
+	assert.Equal(t, "pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64&distro=alpine-3.19.1", spdx.Packages[1].ExternalRefs[0].ReferenceLocator)
+	assert.Len(t, spdx.Relationships, 2)
+
+	raw, err = inv.CycloneDX("hub/library/alpine@" + inv.Image.String())
+	require.NoError(t, err)
+	var cdx cdxDocument
+	require.NoError(t, json.Unmarshal(raw, &cdx))
+	assert.Equal(t, "CycloneDX", cdx.BOMFormat)
+	assert.Equal(t, spdx.DocumentNamespace, cdx.SerialNumber)
+	assert.Equal(t, "container", cdx.Metadata.Component.Type)
+	require.Len(t, cdx.Components, 2)
+	assert.Equal(t, "operating-system", cdx.Components[0].Type)
+	assert.Equal(t, "musl", cdx.Components[1].Name)
+}
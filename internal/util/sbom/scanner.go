@@ -0,0 +1,197 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// maxDatabaseSize bounds how much of a package database is read into memory.
+const maxDatabaseSize = 64 << 20
+
+const (
+	dpkgStatus = "var/lib/dpkg/status"
+	// dpkgStatusDir holds one status file per package in distroless images.
+	dpkgStatusDir = "var/lib/dpkg/status.d/"
+	apkInstalled  = "lib/apk/db/installed"
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var osReleasePaths = []string{"etc/os-release", "usr/lib/os-release"}
+
+// rpmDatabases are the files of the BerkeleyDB, NDB and SQLite rpm
+// databases. They are only noted as present: reading them is not supported.
+var rpmDatabases = map[string]bool{
+	"var/lib/rpm/Packages":              true,
+	"var/lib/rpm/Packages.db":           true,
+	"var/lib/rpm/rpmdb.sqlite":          true,
+	"usr/lib/sysimage/rpm/Packages":     true,
+	"usr/lib/sysimage/rpm/Packages.db":  true,
+	"usr/lib/sysimage/rpm/rpmdb.sqlite": true,
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Scanner collects the package databases of an image's filesystem, layer by
+// layer, honouring the whiteouts with which a layer deletes files of the
+// layers below it.
+type Scanner struct {
+	files map[string][]byte
+}
+
+// NewScanner returns a Scanner for an empty filesystem.
+func NewScanner() *Scanner {
+	return &Scanner{files: map[string][]byte{}}
+}
+
+// AddLayer applies the next layer of the image, lowest first. r is the layer
+// blob: a tar archive, gzip or zstd compressed or not.
+func (s *Scanner) AddLayer(r io.Reader) error {
+	tr, closeFn, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	added := map[string][]byte{}
+	// removed holds the paths the layer deletes from the layers below; a
+	// trailing slash deletes a directory's content.
+	var removed []string
+	archive := tar.NewReader(tr)
+	for {
+		hdr, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		p := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		dir, base := path.Split(p)
+		switch {
+		case base == whiteoutOpaque:
+			removed = append(removed, dir)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := dir + strings.TrimPrefix(base, whiteoutPrefix)
+			removed = append(removed, target, target+"/")
+			continue
+		case !interesting(p):
+			continue
+		case hdr.Typeflag != tar.TypeReg:
+			// Anything but a regular file hides what was there before.
+			delete(added, p)
+			removed = append(removed, p)
+			continue
+		case rpmDatabases[p]:
+			added[p] = nil
+			continue
+		case hdr.Size > maxDatabaseSize:
+			return fmt.Errorf("%s: %d bytes exceeds the %d byte limit", p, hdr.Size, maxDatabaseSize)
+		}
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		added[p] = data
+	}
+
+	for _, r := range removed {
+		for p := range s.files {
+			if p == r || (strings.HasSuffix(r, "/") && strings.HasPrefix(p, r)) {
+				delete(s.files, p)
+			}
+		}
+	}
+	for p, data := range added {
+		s.files[p] = data
+	}
+	return nil
+}
+
+// Inventory lists the packages of the filesystem built from the layers
+// added so far, as the image image.
+func (s *Scanner) Inventory(image digest.Digest) *Inventory {
+	inv := &Inventory{Image: image, Generated: time.Now().UTC(), Packages: []Package{}}
+	for _, p := range osReleasePaths {
+		if data, ok := s.files[p]; ok {
+			inv.OS = parseOSRelease(data)
+			break
+		}
+	}
+
+	var paths []string
+	for p := range s.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		data := s.files[p]
+		switch {
+		case p == dpkgStatus:
+			inv.Packages = append(inv.Packages, parseDpkgStatus(data)...)
+		case strings.HasPrefix(p, dpkgStatusDir) && !strings.HasSuffix(p, ".md5sums"):
+			inv.Packages = append(inv.Packages, parseDpkgStatus(data)...)
+		case p == apkInstalled:
+			inv.Packages = append(inv.Packages, parseApkInstalled(data)...)
+		case rpmDatabases[p]:
+			inv.Skipped = append(inv.Skipped, p+": rpm databases are not read")
+		}
+	}
+	sortPackages(inv.Packages)
+	return inv
+}
+
+func interesting(p string) bool {
+	if p == dpkgStatus || p == apkInstalled || rpmDatabases[p] || strings.HasPrefix(p, dpkgStatusDir) {
+		return true
+	}
+	for _, r := range osReleasePaths {
+		if p == r {
+			return true
+		}
+	}
+	return false
+}
+
+// decompress returns the tar stream of a layer blob and a function releasing
+// the decompressor. The compression is told from the content, as layers
+// pushed with a wrong media type are common enough.
+func decompress(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() { _ = zr.Close() }, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return br, func() {}, nil
+}
@@ -0,0 +1,215 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// UploadTTL is how long an upload may go without a new chunk before it is
+// considered abandoned and its scratch file removed.
+const UploadTTL = 24 * time.Hour
+
+var (
+	// ErrUnknownUpload is returned for an upload ID that is not in progress.
+	ErrUnknownUpload = errors.New("blob upload unknown")
+	// ErrOutOfOrder is returned when a chunk does not start where the
+	// upload currently ends.
+	ErrOutOfOrder = errors.New("blob upload chunk out of order")
+)
+
+// Upload is a blob upload in progress. Chunks are appended to a scratch file
+// that is copied into the BlobStore once the client names the final digest.
+type Upload struct {
+	ID      string
+	RepoKey string
+	Name    string
+	Started time.Time
+
+	mu   sync.Mutex
+	path string
+	size int64
+	// updated is when the upload was started or last received a chunk.
+	updated time.Time
+}
+
+// Size returns the number of bytes received so far.
+func (u *Upload) Size() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.size
+}
+
+// Store tracks blob uploads for hosted repositories. Upload state lives in
+// memory; scratch files left by a previous run are removed on start, and
+// uploads idle for longer than the TTL are dropped as new ones start or are
+// looked up.
+type Store struct {
+	basePath string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	uploads map[string]*Upload
+}
+
+// NewStore creates an upload store keeping scratch files under basePath.
+// Scratch files of uploads interrupted by a restart are removed; anything
+// else in basePath is left alone.
+func NewStore(basePath string) (*Store, error) {
+	if err := os.MkdirAll(basePath, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if _, err := uuid.Parse(e.Name()); err != nil || !e.Type().IsRegular() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(basePath, e.Name())); err != nil {
+			log.Warnf("failed to remove stale upload %s: %v", e.Name(), err)
+		}
+	}
+	return &Store{
+		basePath: basePath,
+		ttl:      UploadTTL,
+		uploads:  make(map[string]*Upload),
+	}, nil
+}
+
+// Start begins a new upload into repository name of repoKey.
+func (s *Store) Start(repoKey, name string) (*Upload, error) {
+	id := uuid.NewString()
+	p := filepath.Join(s.basePath, id)
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(p)
+		return nil, err
+	}
+	now := time.Now()
+	u := &Upload{
+		ID:      id,
+		RepoKey: repoKey,
+		Name:    name,
+		Started: now,
+		path:    p,
+		updated: now,
+	}
+	s.mu.Lock()
+	s.expireLocked()
+	s.uploads[id] = u
+	s.mu.Unlock()
+	return u, nil
+}
+
+// Get returns the upload with the given ID if it belongs to repository name
+// of repoKey.
+func (s *Store) Get(repoKey, name, id string) (*Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	u, ok := s.uploads[id]
+	if !ok || u.RepoKey != repoKey || u.Name != name {
+		return nil, ErrUnknownUpload
+	}
+	return u, nil
+}
+
+// Append writes r to the end of the upload. A non-negative offset must equal
+// the current size, as chunks have to arrive in order. It returns the new size.
+func (u *Upload) Append(offset int64, r io.Reader) (int64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if offset >= 0 && offset != u.size {
+		return u.size, ErrOutOfOrder
+	}
+	f, err := os.OpenFile(u.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return u.size, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// Drop the partial chunk so the client can retry from u.size.
+		if terr := os.Truncate(u.path, u.size); terr != nil {
+			log.Warnf("failed to truncate upload %s: %v", u.ID, terr)
+		}
+		return u.size, err
+	}
+	u.size += n
+	u.updated = time.Now()
+	return u.size, nil
+}
+
+// Commit moves the upload into the blob store under d through fills, which
+// verifies that the received bytes hash to d and keeps the write from racing
+// a cache fill of the same digest. The upload ends whether or not it succeeds.
+func (s *Store) Commit(ctx context.Context, u *Upload, fills *blobs.FillGroup, d digest.Digest) (int64, error) {
+	defer s.finish(u)
+	if err := d.Validate(); err != nil {
+		return 0, fmt.Errorf("invalid digest %q: %w", d, err)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	f, err := os.Open(u.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := fills.Put(ctx, d, f); err != nil {
+		return 0, err
+	}
+	return u.size, nil
+}
+
+// Cancel abandons an upload and removes its scratch file.
+func (s *Store) Cancel(u *Upload) {
+	s.finish(u)
+}
+
+// expireLocked ends the uploads that have been idle for longer than the
+// store's TTL. An upload busy receiving or committing is left alone. s.mu
+// must be held.
+func (s *Store) expireLocked() {
+	for id, u := range s.uploads {
+		if !u.mu.TryLock() {
+			continue
+		}
+		idle := time.Since(u.updated)
+		u.mu.Unlock()
+		if idle <= s.ttl {
+			continue
+		}
+		delete(s.uploads, id)
+		if err := os.Remove(u.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("failed to remove upload %s: %v", id, err)
+		}
+		log.WithFields(log.Fields{"repoKey": u.RepoKey, "name": u.Name, "upload": id, "size": u.size}).Info("Abandoned blob upload expired")
+	}
+}
+
+func (s *Store) finish(u *Upload) {
+	s.mu.Lock()
+	delete(s.uploads, u.ID)
+	s.mu.Unlock()
+	if err := os.Remove(u.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warnf("failed to remove upload %s: %v", u.ID, err)
+	}
+}
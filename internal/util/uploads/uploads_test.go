@@ -0,0 +1,82 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/martencassel/gobinrepo/internal/util/blobs"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreChunkedCommit(t *testing.T) {
+	dir := t.TempDir()
+	bfs, err := blobs.NewBlobStoreFS(filepath.Join(dir, "blobs"))
+	assert.NoError(t, err)
+	fills := blobs.NewFillGroup(bfs)
+	defer fills.Close()
+	s, err := NewStore(filepath.Join(dir, "uploads"))
+	assert.NoError(t, err)
+
+	u, err := s.Start("internal", "app")
+	assert.NoError(t, err)
+	_, err = s.Get("internal", "other", u.ID)
+	assert.ErrorIs(t, err, ErrUnknownUpload)
+
+	size, err := u.Append(0, bytes.NewReader([]byte("hello ")))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), size)
+	_, err = u.Append(0, bytes.NewReader([]byte("again")))
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+	_, err = u.Append(-1, bytes.NewReader([]byte("world")))
+	assert.NoError(t, err)
+
+	d := digest.FromString("hello world")
+	size, err = s.Commit(context.Background(), u, fills, d)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), size)
+	exists, err := bfs.Exists(context.Background(), d)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	_, err = s.Get("internal", "app", u.ID)
+	assert.ErrorIs(t, err, ErrUnknownUpload)
+}
+
+func TestNewStoreKeepsForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "3f1c9a46-8c0e-4b8e-9b7e-1a2b3c4d5e6f")
+	keep := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, os.WriteFile(stale, []byte("partial"), 0o644))
+	assert.NoError(t, os.WriteFile(keep, []byte("keep"), 0o644))
+
+	_, err := NewStore(dir)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, keep)
+}
+
+func TestStoreExpiresIdleUploads(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	s.ttl = time.Minute
+
+	idle, err := s.Start("internal", "app")
+	assert.NoError(t, err)
+	_, err = idle.Append(0, bytes.NewReader([]byte("partial")))
+	assert.NoError(t, err)
+	active, err := s.Start("internal", "app")
+	assert.NoError(t, err)
+	idle.updated = time.Now().Add(-2 * time.Minute)
+
+	_, err = s.Get("internal", "app", idle.ID)
+	assert.ErrorIs(t, err, ErrUnknownUpload)
+	assert.NoFileExists(t, idle.path)
+	got, err := s.Get("internal", "app", active.ID)
+	assert.NoError(t, err)
+	assert.Same(t, active, got)
+	assert.FileExists(t, active.path)
+}